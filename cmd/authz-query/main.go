@@ -0,0 +1,158 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"istio.io/istio/pilot/pkg/config/kube/crd"
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/serviceregistry/kube"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+
+	adv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/apis/athenz/v1"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/authz"
+	adClientset "github.com/yahoo/k8s-athenz-istio-auth/pkg/client/clientset/versioned"
+	adInformer "github.com/yahoo/k8s-athenz-istio-auth/pkg/client/informers/externalversions/athenz/v1"
+	rbacv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/v1"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+)
+
+// readDomainFiles reads the AthenzDomain resources of the YAML files
+func readDomainFiles(paths string) ([]*adv1.AthenzDomain, error) {
+	domains := make([]*adv1.AthenzDomain, 0)
+	for _, path := range strings.Split(paths, ",") {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		fileDomains, err := authz.ReadAthenzDomains(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %s", path, err.Error())
+		}
+		domains = append(domains, fileDomains...)
+	}
+	return domains, nil
+}
+
+// newClusterEngine returns an engine for the AthenzDomains and the current
+// ServiceRoles, ServiceRoleBindings and ClusterRbacConfig of the cluster, read
+// from the informer caches once they synced
+func newClusterEngine(kubeconfig string, timeout time.Duration) (*authz.Engine, error) {
+	configDescriptor := model.ConfigDescriptor{
+		model.ServiceRole,
+		model.ServiceRoleBinding,
+		model.ClusterRbacConfig,
+	}
+	istioClient, err := crd.NewClient(kubeconfig, "", configDescriptor, "")
+	if err != nil {
+		return nil, fmt.Errorf("error creating istio crd client: %s", err.Error())
+	}
+
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("error creating kubernetes config: %s", err.Error())
+	}
+	adClient, err := adClientset.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating athenz domain client: %s", err.Error())
+	}
+
+	configStoreCache := crd.NewController(istioClient, kube.ControllerOptions{})
+	adIndexInformer := adInformer.NewAthenzDomainInformer(adClient, v1.NamespaceAll, 0, cache.Indexers{})
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go configStoreCache.Run(stopCh)
+	go adIndexInformer.Run(stopCh)
+
+	timeoutCh := make(chan struct{})
+	timer := time.AfterFunc(timeout, func() { close(timeoutCh) })
+	defer timer.Stop()
+	if !cache.WaitForCacheSync(timeoutCh, configStoreCache.HasSynced, adIndexInformer.HasSynced) {
+		return nil, fmt.Errorf("timed out waiting for the caches to sync")
+	}
+
+	domains := make([]*adv1.AthenzDomain, 0)
+	for _, obj := range adIndexInformer.GetIndexer().List() {
+		athenzDomain, ok := obj.(*adv1.AthenzDomain)
+		if !ok || athenzDomain.Spec.SignedDomain.Domain == nil {
+			continue
+		}
+		domains = append(domains, athenzDomain)
+	}
+	return authz.NewEngineFromDomains(domains, rbacv1.NewProvider(), configStoreCache), nil
+}
+
+func main() {
+	domainFiles := flag.String("domain-files", "", "comma separated AthenzDomain YAML files to query offline, the cluster is queried if empty")
+	kubeconfig := flag.String("kubeconfig", "", "(optional) absolute path to the kubeconfig file")
+	timeout := flag.Duration("timeout", 30*time.Second, "timeout of the informer cache sync")
+	principal := flag.String("principal", "", "athenz principal of the source, e.g. frontend.domain.frontend, every allowed principal is listed if empty")
+	namespace := flag.String("namespace", "", "namespace of the target service")
+	service := flag.String("service", "", "target service, as set by the svc label")
+	method := flag.String("method", "GET", "http method of the request")
+	path := flag.String("path", "/", "http path of the request")
+	logLevel := flag.String("log-level", "error", "logging level")
+
+	flag.Parse()
+	log.InitLogger("", *logLevel)
+
+	if *namespace == "" || *service == "" {
+		fmt.Fprintln(os.Stderr, "namespace and service must be set")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var engine *authz.Engine
+	if *domainFiles != "" {
+		domains, err := readDomainFiles(*domainFiles)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		engine = authz.NewEngineFromDomains(domains, rbacv1.NewProvider(), nil)
+	} else {
+		var err error
+		engine, err = newClusterEngine(*kubeconfig, *timeout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	}
+
+	var result interface{}
+	if *principal == "" {
+		result = engine.Principals(*namespace, *service)
+	} else {
+		result = engine.Check(authz.Request{
+			Principal: *principal,
+			Namespace: *namespace,
+			Service:   *service,
+			Method:    *method,
+			Path:      *path,
+		})
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(result)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	if decision, ok := result.(authz.Decision); ok && !decision.Allowed {
+		os.Exit(3)
+	}
+}
@@ -5,9 +5,13 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
@@ -18,84 +22,167 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/homedir"
 
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/audit"
 	adClientset "github.com/yahoo/k8s-athenz-istio-auth/pkg/client/clientset/versioned"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/config"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/controller"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/preflight"
 )
 
-const logPrefix = "[main]"
+var logger = log.WithComponent("main")
 
 func main() {
-	dnsSuffix := flag.String("dns-suffix", "svc.cluster.local", "dns suffix used for service role target services")
-	kubeconfig := flag.String("kubeconfig", "", "(optional) absolute path to the kubeconfig file")
-	adResyncIntervalRaw := flag.String("ad-resync-interval", "1h", "athenz domain resync interval")
-	crcResyncIntervalRaw := flag.String("crc-resync-interval", "1h", "cluster rbac config resync interval")
-	logFile := flag.String("log-file", "/var/log/k8s-athenz-istio-auth/k8s-athenz-istio-auth.log", "log file location")
-	logLevel := flag.String("log-level", "info", "logging level")
-
+	loader := config.NewLoader(flag.CommandLine)
 	flag.Parse()
-	log.InitLogger(*logFile, *logLevel)
+
+	// the errors are reported before the logger is initialized from the config
+	cfg, err := loader.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading the config: %s\n", err.Error())
+		os.Exit(2)
+	}
+
+	log.InitLoggerWithRotation(cfg.Log.File, cfg.Log.Level, cfg.LogRotation())
+	err = log.SetFormat(cfg.Log.Format)
+	if err != nil {
+		logger.WithError(err).Fatalf("Error setting the log format")
+	}
 
 	configDescriptor := model.ConfigDescriptor{
 		model.ServiceRole,
 		model.ServiceRoleBinding,
 		model.ClusterRbacConfig,
+		model.ServiceEntry,
 	}
 
 	// If kubeconfig arg is not passed-in, try user $HOME config only if it exists
-	if *kubeconfig == "" {
+	kubeconfig := cfg.Kube.Kubeconfig
+	if kubeconfig == "" {
 		home := filepath.Join(homedir.HomeDir(), ".kube", "config")
 		if _, err := os.Stat(home); err == nil {
-			*kubeconfig = home
+			kubeconfig = home
 		}
 	}
 
-	istioClient, err := crd.NewClient(*kubeconfig, "", configDescriptor, *dnsSuffix)
+	istioClient, err := crd.NewClient(kubeconfig, "", configDescriptor, cfg.DNSSuffix)
 	if err != nil {
-		log.Panicf("%s Error creating istio crd client: %s", logPrefix, err.Error())
+		logger.WithError(err).Fatalf("Error creating istio crd client")
 	}
 
-	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+	restConfig, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
 	if err != nil {
-		log.Panicf("%s Error creating kubernetes in cluster config: %s", logPrefix, err.Error())
+		logger.WithError(err).Fatalf("Error creating kubernetes config from kubeconfig %q", kubeconfig)
 	}
+	restConfig.QPS = float32(cfg.Kube.QPS)
+	restConfig.Burst = cfg.Kube.Burst
 
-	k8sClient, err := kubernetes.NewForConfig(config)
+	k8sClient, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
-		log.Panicf("%s Error creating k8s client: %s", logPrefix, err.Error())
+		logger.WithError(err).Fatalf("Error creating k8s client")
 	}
 
-	adClient, err := adClientset.NewForConfig(config)
+	adClient, err := adClientset.NewForConfig(restConfig)
 	if err != nil {
-		log.Panicf("%s Error creating athenz domain client: %s", logPrefix, err.Error())
+		logger.WithError(err).Fatalf("Error creating athenz domain client")
 	}
 
-	adResyncInterval, err := time.ParseDuration(*adResyncIntervalRaw)
+	// the istio custom resource definitions may be installed after the controller
+	report, err := preflight.Run(preflight.DefaultChecks(k8sClient), cfg.PreflightPolicy(), nil)
 	if err != nil {
-		log.Panicf("%s Error parsing ad-resync-interval duration: %s", logPrefix, err.Error())
+		logger.WithError(err).Fatalf("Startup checks failed: %s", report)
 	}
 
-	crcResyncInterval, err := time.ParseDuration(*crcResyncIntervalRaw)
+	opts, err := controller.NewOptions(cfg)
 	if err != nil {
-		log.Panicf("%s Error parsing crc-resync-interval duration: %s", logPrefix, err.Error())
+		logger.WithError(err).Fatalf("Error building the controller options")
+	}
+
+	debugToken := ""
+	if cfg.HTTP.DebugEndpoints {
+		token, err := ioutil.ReadFile(cfg.HTTP.DebugTokenFile)
+		if err != nil {
+			logger.WithError(err).Fatalf("Error reading debug-token-file")
+		}
+		debugToken = strings.TrimSpace(string(token))
+		if debugToken == "" {
+			logger.Fatalf("debug-token-file must hold a token when debug-endpoints is set")
+		}
+	}
+
+	if !opts.Filter.Empty() {
+		logger.Infof("Scoped to the namespaces and athenz domains matched by the filter: %+v", cfg.Filter)
+	}
+
+	if cfg.AuditLogFile != "" {
+		opts.AuditLog, err = audit.NewLogger(cfg.AuditLogFile, cfg.LogRotation())
+		if err != nil {
+			logger.WithError(err).Fatalf("Error opening audit-log-file")
+		}
 	}
 
-	c := controller.NewController(*dnsSuffix, istioClient, k8sClient, adClient, adResyncInterval, crcResyncInterval)
+	c := controller.NewController(istioClient, k8sClient, adClient, opts)
+
+	go func() {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		if cfg.HTTP.DebugEndpoints {
+			mux.Handle("/debug/deadletters", controller.RequireToken(debugToken, c.DeadLetters()))
+			mux.Handle("/debug/domains", c.DebugHandler(debugToken))
+			mux.Handle("/debug/loglevel", controller.RequireToken(debugToken, log.LevelHandler()))
+		}
+		err := http.ListenAndServe(cfg.HTTP.Addr, mux)
+		if err != nil {
+			logger.WithError(err).Errorf("Error serving http on %s", cfg.HTTP.Addr)
+		}
+	}()
 
 	stopCh := make(chan struct{})
-	go c.Run(stopCh)
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		c.Run(stopCh)
+	}()
+
+	if loader.Path() != "" {
+		go loader.Watch(cfg, stopCh, func(reloaded *config.Config, changes []config.Change) {
+			// the level set on the debug endpoint is kept unless the config changes it
+			for _, change := range changes {
+				if change.Setting != "log.level" {
+					continue
+				}
+				err := log.SetLevel(reloaded.Log.Level)
+				if err != nil {
+					logger.WithError(err).Errorf("Error setting the log level")
+				}
+			}
+			c.Reload(reloaded.AthenzDomains.ResyncInterval.Duration, reloaded.AthenzDomains.SkipUnchanged,
+				reloaded.ClusterRbacConfig.ResyncInterval.Duration, reloaded.ClusterRbacConfig.Debounce.Duration)
+		})
+	}
 
 	signalCh := make(chan os.Signal, 1)
 	signal.Notify(signalCh, syscall.SIGINT, syscall.SIGTERM)
-	for {
-		select {
-		case <-signalCh:
-			log.Infof("%s Shutdown signal received, stopping controllers...", logPrefix)
-			close(stopCh)
-			// sleep to allow go routines to successfully exit
-			time.Sleep(time.Second)
-			log.Infof("%s Shutting down...", logPrefix)
-			os.Exit(0)
+	<-signalCh
+
+	// the controller runs without leader election, there is no leadership to
+	// release before the in-flight syncs and writes are drained
+	logger.Infof("Shutdown signal received, draining the controllers for up to %s...", cfg.ShutdownTimeout)
+	close(stopCh)
+	select {
+	case <-doneCh:
+		if !c.Drained() {
+			logger.Errorf("Shutting down with undrained writes")
+			os.Exit(1)
 		}
+		logger.Infof("Shutting down...")
+		os.Exit(0)
+	case <-time.After(cfg.ShutdownTimeout.Duration):
+		logger.Errorf("Timed out draining the controllers after %s, shutting down", cfg.ShutdownTimeout)
+		os.Exit(1)
+	case <-signalCh:
+		logger.Errorf("Second shutdown signal received, shutting down without draining")
+		os.Exit(1)
 	}
 }
@@ -10,7 +10,6 @@ import (
 )
 
 // +genclient
-// +genclient:noStatus
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // AthenzDomain is a top-level type
@@ -29,6 +28,11 @@ type AthenzDomain struct {
 // AthenzDomainSpec contains the SignedDomain object https://github.com/yahoo/athenz/clients/go/zms
 type AthenzDomainSpec struct {
 	zms.SignedDomain `json:",inline"`
+
+	// PolicyVersions holds the versions of the domain policies with their
+	// assertions, which the zms client does not decode. It is only decoded
+	// from the spec, the controller never writes the spec back.
+	PolicyVersions PolicyVersions `json:"-"`
 }
 
 // DeepCopy copies the object and returns a clone
@@ -0,0 +1,110 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/yahoo/athenz/clients/go/zms"
+)
+
+// PolicyVersion is a version of an Athenz policy along with its assertions,
+// which the zms client does not tell apart from the other versions
+type PolicyVersion struct {
+	Name       zms.ResourceName `json:"name"`
+	Version    string           `json:"version,omitempty"`
+	Active     *bool            `json:"active,omitempty"`
+	Assertions []*zms.Assertion `json:"assertions,omitempty"`
+}
+
+// IsActive returns true unless the policy version is flagged inactive
+func (v PolicyVersion) IsActive() bool {
+	return v.Active == nil || *v.Active
+}
+
+// PolicyVersions is the versions of the policies of a domain
+type PolicyVersions []PolicyVersion
+
+// Has returns true if the versions of the policy are known
+func (versions PolicyVersions) Has(name zms.ResourceName) bool {
+	for _, version := range versions {
+		if version.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Select returns the version of the policy to convert, keyed by the policy
+// name and version: the preview version if the policy has it, its active
+// version otherwise. It returns false if the policy has no active version,
+// and an error if several versions match, so that an ambiguous policy grants
+// no access.
+func (versions PolicyVersions) Select(name zms.ResourceName, preview string) (PolicyVersion, bool, error) {
+	if preview != "" {
+		matches := versions.filter(name, func(v PolicyVersion) bool { return v.Version == preview })
+		if len(matches) > 1 {
+			return PolicyVersion{}, false, fmt.Errorf("policy %s has %d versions %q", name, len(matches), preview)
+		}
+		if len(matches) == 1 {
+			return matches[0], true, nil
+		}
+	}
+
+	matches := versions.filter(name, PolicyVersion.IsActive)
+	if len(matches) > 1 {
+		return PolicyVersion{}, false, fmt.Errorf("policy %s has %d active versions", name, len(matches))
+	}
+	if len(matches) == 1 {
+		return matches[0], true, nil
+	}
+	return PolicyVersion{}, false, nil
+}
+
+// filter returns the versions of the policy matched by the predicate
+func (versions PolicyVersions) filter(name zms.ResourceName, match func(PolicyVersion) bool) []PolicyVersion {
+	matches := make([]PolicyVersion, 0)
+	for _, version := range versions {
+		if version.Name == name && match(version) {
+			matches = append(matches, version)
+		}
+	}
+	return matches
+}
+
+// rawPolicies is the part of a signed domain holding the policy versions
+type rawPolicies struct {
+	Domain *struct {
+		Policies *struct {
+			Contents *struct {
+				Policies PolicyVersions `json:"policies"`
+			} `json:"contents"`
+		} `json:"policies"`
+	} `json:"domain"`
+}
+
+// UnmarshalJSON decodes the signed domain along with the versions of its
+// policies. The versions are only kept if a policy carries version metadata.
+func (in *AthenzDomainSpec) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &in.SignedDomain); err != nil {
+		return err
+	}
+
+	var raw rawPolicies
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	in.PolicyVersions = nil
+	if raw.Domain == nil || raw.Domain.Policies == nil || raw.Domain.Policies.Contents == nil {
+		return nil
+	}
+	for _, version := range raw.Domain.Policies.Contents.Policies {
+		if version.Version != "" || version.Active != nil {
+			in.PolicyVersions = raw.Domain.Policies.Contents.Policies
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package v1
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const versionedSpec = `{
+	"domain": {
+		"name": "athenz.domain",
+		"modified": "2019-06-21T19:28:09.305Z",
+		"roles": [],
+		"policies": {
+			"contents": {
+				"domain": "athenz.domain",
+				"policies": [
+					{"name": "athenz.domain:policy.reader", "version": "0", "active": true, "assertions": [
+						{"role": "athenz.domain:role.reader", "resource": "athenz.domain:svc.details", "action": "get", "effect": "ALLOW"}
+					]},
+					{"name": "athenz.domain:policy.reader", "version": "1", "active": false, "assertions": [
+						{"role": "athenz.domain:role.reader", "resource": "athenz.domain:svc.details", "action": "post", "effect": "ALLOW"}
+					]}
+				]
+			},
+			"signature": "signature",
+			"keyId": "0"
+		}
+	},
+	"signature": "signature",
+	"keyId": "0"
+}`
+
+func TestAthenzDomainSpecPolicyVersions(t *testing.T) {
+	var spec AthenzDomainSpec
+	err := json.Unmarshal([]byte(versionedSpec), &spec)
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, 2, len(spec.SignedDomain.Domain.Policies.Contents.Policies), "policies should be decoded")
+	assert.Equal(t, 2, len(spec.PolicyVersions), "policy versions should be decoded")
+	assert.Equal(t, "1", spec.PolicyVersions[1].Version, "version should be equal")
+	assert.True(t, spec.PolicyVersions[0].IsActive(), "first version should be active")
+	assert.False(t, spec.PolicyVersions[1].IsActive(), "second version should be inactive")
+	assert.Equal(t, "post", spec.PolicyVersions[1].Assertions[0].Action, "assertions of the version should be decoded")
+
+	data, err := json.Marshal(spec.SignedDomain)
+	assert.Nil(t, err, "error should be nil")
+	var unversioned AthenzDomainSpec
+	err = json.Unmarshal(data, &unversioned)
+	assert.Nil(t, err, "error should be nil")
+	assert.Nil(t, unversioned.PolicyVersions, "policy versions should be nil without versions")
+}
+
+func TestPolicyVersionsSelect(t *testing.T) {
+	active, inactive := true, false
+	versions := PolicyVersions{
+		{Name: "athenz.domain:policy.reader", Version: "1", Active: &inactive},
+		{Name: "athenz.domain:policy.reader", Version: "0", Active: &active},
+		{Name: "athenz.domain:policy.writer", Version: "0", Active: &inactive},
+		{Name: "athenz.domain:policy.admin", Version: "0"},
+		{Name: "athenz.domain:policy.admin", Version: "1"},
+	}
+
+	assert.True(t, versions.Has("athenz.domain:policy.reader"), "versions of the policy should be known")
+	assert.False(t, versions.Has("athenz.domain:policy.other"), "versions of another policy should not be known")
+
+	version, ok, err := versions.Select("athenz.domain:policy.reader", "")
+	assert.Nil(t, err, "error should be nil")
+	assert.True(t, ok, "active version should be selected")
+	assert.Equal(t, "0", version.Version, "active version should be equal")
+
+	version, ok, err = versions.Select("athenz.domain:policy.reader", "1")
+	assert.Nil(t, err, "error should be nil")
+	assert.True(t, ok, "preview version should be selected")
+	assert.Equal(t, "1", version.Version, "preview version should be equal")
+
+	version, ok, err = versions.Select("athenz.domain:policy.reader", "2")
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, "0", version.Version, "unknown preview version should select the active version")
+
+	_, ok, err = versions.Select("athenz.domain:policy.writer", "")
+	assert.Nil(t, err, "error should be nil")
+	assert.False(t, ok, "policy without an active version should not be selected")
+
+	_, ok, err = versions.Select("athenz.domain:policy.admin", "")
+	assert.NotNil(t, err, "several active versions should be ambiguous")
+	assert.False(t, ok, "ambiguous policy should not be selected")
+}
@@ -0,0 +1,180 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package athenz
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/yahoo/athenz/clients/go/zms"
+)
+
+// MemberChange is a member added to or removed from a role
+type MemberChange struct {
+	Role   zms.ResourceName `json:"role"`
+	Member zms.MemberName   `json:"member"`
+}
+
+// AssertionChange is an assertion added to or removed from a role
+type AssertionChange struct {
+	Role     zms.ResourceName `json:"role"`
+	Resource string           `json:"resource"`
+	Action   string           `json:"action"`
+	Effect   string           `json:"effect"`
+}
+
+// ModelDiff holds the access changes between two versions of a model
+type ModelDiff struct {
+	AddedRoles        []zms.ResourceName `json:"addedRoles,omitempty"`
+	RemovedRoles      []zms.ResourceName `json:"removedRoles,omitempty"`
+	AddedMembers      []MemberChange     `json:"addedMembers,omitempty"`
+	RemovedMembers    []MemberChange     `json:"removedMembers,omitempty"`
+	AddedAssertions   []AssertionChange  `json:"addedAssertions,omitempty"`
+	RemovedAssertions []AssertionChange  `json:"removedAssertions,omitempty"`
+}
+
+// Empty returns true if the two versions grant the same access
+func (d ModelDiff) Empty() bool {
+	return len(d.AddedRoles) == 0 && len(d.RemovedRoles) == 0 &&
+		len(d.AddedMembers) == 0 && len(d.RemovedMembers) == 0 &&
+		len(d.AddedAssertions) == 0 && len(d.RemovedAssertions) == 0
+}
+
+// String returns a human readable summary of the diff
+func (d ModelDiff) String() string {
+	return fmt.Sprintf("roles +%d -%d, members +%d -%d, assertions +%d -%d",
+		len(d.AddedRoles), len(d.RemovedRoles), len(d.AddedMembers), len(d.RemovedMembers),
+		len(d.AddedAssertions), len(d.RemovedAssertions))
+}
+
+// newAssertionChange returns the assertion change of an assertion of a role
+func newAssertionChange(role zms.ResourceName, assertion *zms.Assertion) AssertionChange {
+	change := AssertionChange{
+		Role:     role,
+		Resource: assertion.Resource,
+		Action:   assertion.Action,
+	}
+	if assertion.Effect != nil {
+		change.Effect = assertion.Effect.String()
+	}
+	return change
+}
+
+// roleSet returns the roles of a model as a set
+func roleSet(m Model) map[zms.ResourceName]bool {
+	roles := make(map[zms.ResourceName]bool, len(m.Roles))
+	for _, role := range m.Roles {
+		roles[role] = true
+	}
+	return roles
+}
+
+// memberSet returns the members of each role of a model as a set
+func memberSet(m Model) map[MemberChange]bool {
+	members := make(map[MemberChange]bool)
+	for role, roleMembers := range m.Members {
+		for _, member := range roleMembers {
+			if member == nil {
+				continue
+			}
+			members[MemberChange{Role: role, Member: member.MemberName}] = true
+		}
+	}
+	return members
+}
+
+// assertionSet returns the assertions of each role of a model as a set
+func assertionSet(m Model) map[AssertionChange]bool {
+	assertions := make(map[AssertionChange]bool)
+	for role, roleAssertions := range m.Rules {
+		for _, assertion := range roleAssertions {
+			if assertion == nil {
+				continue
+			}
+			assertions[newAssertionChange(role, assertion)] = true
+		}
+	}
+	return assertions
+}
+
+// DiffModels returns the roles, members and assertions added to and removed
+// from the old model by the new one, sorted by role
+func DiffModels(old, new Model) ModelDiff {
+	diff := ModelDiff{}
+
+	oldRoles, newRoles := roleSet(old), roleSet(new)
+	for role := range newRoles {
+		if !oldRoles[role] {
+			diff.AddedRoles = append(diff.AddedRoles, role)
+		}
+	}
+	for role := range oldRoles {
+		if !newRoles[role] {
+			diff.RemovedRoles = append(diff.RemovedRoles, role)
+		}
+	}
+
+	oldMembers, newMembers := memberSet(old), memberSet(new)
+	for member := range newMembers {
+		if !oldMembers[member] {
+			diff.AddedMembers = append(diff.AddedMembers, member)
+		}
+	}
+	for member := range oldMembers {
+		if !newMembers[member] {
+			diff.RemovedMembers = append(diff.RemovedMembers, member)
+		}
+	}
+
+	oldAssertions, newAssertions := assertionSet(old), assertionSet(new)
+	for assertion := range newAssertions {
+		if !oldAssertions[assertion] {
+			diff.AddedAssertions = append(diff.AddedAssertions, assertion)
+		}
+	}
+	for assertion := range oldAssertions {
+		if !newAssertions[assertion] {
+			diff.RemovedAssertions = append(diff.RemovedAssertions, assertion)
+		}
+	}
+
+	sortRoles(diff.AddedRoles)
+	sortRoles(diff.RemovedRoles)
+	sortMembers(diff.AddedMembers)
+	sortMembers(diff.RemovedMembers)
+	sortAssertions(diff.AddedAssertions)
+	sortAssertions(diff.RemovedAssertions)
+	return diff
+}
+
+func sortRoles(roles []zms.ResourceName) {
+	sort.Slice(roles, func(i, j int) bool {
+		return roles[i] < roles[j]
+	})
+}
+
+func sortMembers(members []MemberChange) {
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Role != members[j].Role {
+			return members[i].Role < members[j].Role
+		}
+		return members[i].Member < members[j].Member
+	})
+}
+
+func sortAssertions(assertions []AssertionChange) {
+	sort.Slice(assertions, func(i, j int) bool {
+		a, b := assertions[i], assertions[j]
+		if a.Role != b.Role {
+			return a.Role < b.Role
+		}
+		if a.Resource != b.Resource {
+			return a.Resource < b.Resource
+		}
+		if a.Action != b.Action {
+			return a.Action < b.Action
+		}
+		return a.Effect < b.Effect
+	})
+}
@@ -0,0 +1,60 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package athenz
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yahoo/athenz/clients/go/zms"
+)
+
+func TestDiffModels(t *testing.T) {
+	allow := zms.ALLOW
+	old := Model{
+		Name:      "athenz.domain",
+		Namespace: "athenz-domain",
+		Roles:     Roles{"athenz.domain:role.reader", "athenz.domain:role.admin"},
+		Rules: RoleAssertions{
+			"athenz.domain:role.reader": {
+				{Role: "athenz.domain:role.reader", Resource: "athenz.domain:svc.backend", Action: "get", Effect: &allow},
+			},
+		},
+		Members: RoleMembers{
+			"athenz.domain:role.reader": {
+				{MemberName: "client.domain.a"},
+				{MemberName: "client.domain.b"},
+			},
+		},
+	}
+	new := Model{
+		Name:      "athenz.domain",
+		Namespace: "athenz-domain",
+		Roles:     Roles{"athenz.domain:role.reader", "athenz.domain:role.writer"},
+		Rules: RoleAssertions{
+			"athenz.domain:role.reader": {
+				{Role: "athenz.domain:role.reader", Resource: "athenz.domain:svc.backend", Action: "get", Effect: &allow},
+				{Role: "athenz.domain:role.reader", Resource: "athenz.domain:svc.backend", Action: "post", Effect: &allow},
+			},
+		},
+		Members: RoleMembers{
+			"athenz.domain:role.reader": {
+				{MemberName: "client.domain.b"},
+				{MemberName: "client.domain.c"},
+			},
+		},
+	}
+
+	diff := DiffModels(old, new)
+	assert.Equal(t, []zms.ResourceName{"athenz.domain:role.writer"}, diff.AddedRoles, "added roles should be equal")
+	assert.Equal(t, []zms.ResourceName{"athenz.domain:role.admin"}, diff.RemovedRoles, "removed roles should be equal")
+	assert.Equal(t, []MemberChange{{Role: "athenz.domain:role.reader", Member: "client.domain.c"}}, diff.AddedMembers, "added members should be equal")
+	assert.Equal(t, []MemberChange{{Role: "athenz.domain:role.reader", Member: "client.domain.a"}}, diff.RemovedMembers, "removed members should be equal")
+	assert.Equal(t, []AssertionChange{{Role: "athenz.domain:role.reader", Resource: "athenz.domain:svc.backend", Action: "post", Effect: "ALLOW"}}, diff.AddedAssertions, "added assertions should be equal")
+	assert.Nil(t, diff.RemovedAssertions, "removed assertions should be nil")
+	assert.False(t, diff.Empty(), "diff should not be empty")
+	assert.Equal(t, "roles +1 -1, members +1 -1, assertions +1 -0", diff.String(), "summary should be equal")
+
+	assert.True(t, DiffModels(new, new).Empty(), "diff of the same model should be empty")
+}
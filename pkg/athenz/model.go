@@ -5,8 +5,13 @@ package athenz
 
 import (
 	"github.com/yahoo/athenz/clients/go/zms"
+
+	adv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/apis/athenz/v1"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
 )
 
+var logger = log.WithComponent("athenz")
+
 // Athenz data structures the way we would want
 // list of Athenz Role names for an Athenz domain
 type Roles []zms.ResourceName
@@ -43,31 +48,49 @@ func getRolesForDomain(domain *zms.DomainData) Roles {
 	return roles
 }
 
-// getRulesForDomain returns the assertions grouped by role for services(resources) in an Athenz domain
-func getRulesForDomain(domain *zms.DomainData) RoleAssertions {
+// appendAssertions appends the assertions to the rules of their roles, the
+// order of the assertions within each role follows the order they appear in
+func appendAssertions(rules RoleAssertions, assertions []*zms.Assertion) {
+	for _, assertion := range assertions {
+		roleName := zms.ResourceName(assertion.Role)
+		rules[roleName] = append(rules[roleName], assertion)
+	}
+}
+
+// getRulesForDomain returns the assertions grouped by role for services(resources) in an Athenz domain.
+// The versions of a policy are keyed by its name and version: only its active version is used, or its preview
+// version if it has one. A policy with several matching versions is ambiguous and grants no access. A policy
+// without versions is active.
+func getRulesForDomain(domain *zms.DomainData, versions adv1.PolicyVersions, preview string) RoleAssertions {
 	rules := make(RoleAssertions)
 
 	if domain == nil || domain.Policies == nil || domain.Policies.Contents == nil {
 		return rules
 	}
-	policies := domain.Policies.Contents.Policies
 
 	// Loop through all the policies and the assertions and organize the assertions by role
-	// the order of assertions within each role follow the order as they appear
-	for _, policy := range policies {
-
-		for _, assertion := range policy.Assertions {
-
-			roleName := zms.ResourceName(assertion.Role)
-
-			// fetch the list of assertions for the role if exists, or create one
-			assertionsForRole, exists := rules[roleName]
-			if !exists {
-				assertionsForRole = []*zms.Assertion{}
-				rules[roleName] = assertionsForRole
-			}
+	converted := make(map[zms.ResourceName]bool)
+	for _, policy := range domain.Policies.Contents.Policies {
+		if policy == nil {
+			continue
+		}
+		if !versions.Has(policy.Name) {
+			appendAssertions(rules, policy.Assertions)
+			continue
+		}
 
-			rules[roleName] = append(assertionsForRole, assertion)
+		// the versions of the policy are listed as several policies of the same name
+		if converted[policy.Name] {
+			continue
+		}
+		converted[policy.Name] = true
+		version, ok, err := versions.Select(policy.Name, preview)
+		if err != nil {
+			logger.WithError(err).Warningf("Ambiguous policy versions in domain %s, skipping the policy", domain.Name)
+			continue
+		}
+		if ok {
+			appendAssertions(rules, version.Assertions)
 		}
 	}
 
@@ -91,8 +114,16 @@ func getMembersForRole(domain *zms.DomainData) RoleMembers {
 	return roleMembers
 }
 
-// ConvertAthenzPoliciesIntoRbacModel transforms the given Athenz Domain structure into role-centric policies and members
+// ConvertAthenzPoliciesIntoRbacModel transforms the given Athenz Domain structure into role-centric policies and members,
+// every policy of the domain is considered active
 func ConvertAthenzPoliciesIntoRbacModel(domain *zms.DomainData) Model {
+	return ConvertVersionedPoliciesIntoRbacModel(domain, nil, "")
+}
+
+// ConvertVersionedPoliciesIntoRbacModel transforms the given Athenz Domain structure into role-centric policies and
+// members, skipping the inactive policy versions. The policies which have the preview version use it instead of their
+// active version, an empty preview uses the active versions only.
+func ConvertVersionedPoliciesIntoRbacModel(domain *zms.DomainData, versions adv1.PolicyVersions, preview string) Model {
 	var domainName zms.DomainName
 	if domain != nil {
 		domainName = domain.Name
@@ -101,7 +132,7 @@ func ConvertAthenzPoliciesIntoRbacModel(domain *zms.DomainData) Model {
 		Name:      domainName,
 		Namespace: DomainToNamespace(string(domainName)),
 		Roles:     getRolesForDomain(domain),
-		Rules:     getRulesForDomain(domain),
+		Rules:     getRulesForDomain(domain, versions, preview),
 		Members:   getMembersForRole(domain),
 	}
 }
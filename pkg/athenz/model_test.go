@@ -10,8 +10,15 @@ import (
 	"github.com/ardielle/ardielle-go/rdl"
 	"github.com/stretchr/testify/assert"
 	"github.com/yahoo/athenz/clients/go/zms"
+
+	adv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/apis/athenz/v1"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
 )
 
+func init() {
+	log.InitLogger("", "debug")
+}
+
 func toRDLTimestamp(s string) (rdl.Timestamp, error) {
 	return rdl.TimestampParse(s)
 }
@@ -384,12 +391,78 @@ func TestGetRulesForDomain(t *testing.T) {
 	}
 
 	for _, c := range cases {
-		if got := getRulesForDomain(c.domain); !reflect.DeepEqual(got, c.expected) {
+		if got := getRulesForDomain(c.domain, nil, ""); !reflect.DeepEqual(got, c.expected) {
 			assert.Equal(t, c.expected, got, c.test)
 		}
 	}
 }
 
+func TestGetRulesForDomainVersions(t *testing.T) {
+	allow := zms.ALLOW
+	active, inactive := true, false
+	newAssertions := func(action string) []*zms.Assertion {
+		return []*zms.Assertion{
+			{
+				Role:     "athenz.domain:role.reader",
+				Resource: "athenz.domain:svc.details",
+				Action:   action,
+				Effect:   &allow,
+			},
+		}
+	}
+	newPolicy := func(name, action string) *zms.Policy {
+		return &zms.Policy{
+			Name:       zms.ResourceName(name),
+			Assertions: newAssertions(action),
+		}
+	}
+	domain := &zms.DomainData{
+		Name: "athenz.domain",
+		Policies: &zms.SignedPolicies{
+			Contents: &zms.DomainPolicies{
+				Domain: "athenz.domain",
+				Policies: []*zms.Policy{
+					newPolicy("athenz.domain:policy.reader", "get"),
+					newPolicy("athenz.domain:policy.reader", "post"),
+					newPolicy("athenz.domain:policy.reader", "put"),
+				},
+			},
+		},
+	}
+	// the versions are listed in another order than the policies
+	versions := adv1.PolicyVersions{
+		{Name: "athenz.domain:policy.reader", Version: "2", Active: &inactive, Assertions: newAssertions("put")},
+		{Name: "athenz.domain:policy.reader", Version: "0", Active: &active, Assertions: newAssertions("get")},
+		{Name: "athenz.domain:policy.reader", Version: "1", Active: &inactive, Assertions: newAssertions("post")},
+	}
+	actions := func(rules RoleAssertions) []string {
+		result := make([]string, 0)
+		for _, assertion := range rules["athenz.domain:role.reader"] {
+			result = append(result, assertion.Action)
+		}
+		return result
+	}
+
+	assert.Equal(t, []string{"get", "post", "put"}, actions(getRulesForDomain(domain, nil, "")), "every policy should be active without versions")
+	assert.Equal(t, []string{"get"}, actions(getRulesForDomain(domain, versions, "")), "inactive versions should be skipped")
+	assert.Equal(t, []string{"post"}, actions(getRulesForDomain(domain, versions, "1")), "preview version should replace the active version")
+	assert.Equal(t, []string{"get"}, actions(getRulesForDomain(domain, versions, "3")), "unknown preview version should keep the active version")
+
+	mismatched := adv1.PolicyVersions{{Name: "athenz.domain:policy.other", Active: &inactive}}
+	assert.Equal(t, []string{"get", "post", "put"}, actions(getRulesForDomain(domain, mismatched, "")), "versions of another policy should be ignored")
+
+	inactiveOnly := adv1.PolicyVersions{{Name: "athenz.domain:policy.reader", Version: "1", Active: &inactive, Assertions: newAssertions("post")}}
+	assert.Equal(t, []string{}, actions(getRulesForDomain(domain, inactiveOnly, "")), "policy without an active version should grant no access")
+
+	severalActive := append(adv1.PolicyVersions{}, versions...)
+	severalActive[0].Active = &active
+	assert.Equal(t, []string{}, actions(getRulesForDomain(domain, severalActive, "")), "policy with several active versions should grant no access")
+
+	duplicatePreview := append(adv1.PolicyVersions{}, versions...)
+	duplicatePreview[0].Version = "1"
+	assert.Equal(t, []string{}, actions(getRulesForDomain(domain, duplicatePreview, "1")), "policy with several preview versions should grant no access")
+}
+
 func TestGetMembersForRole(t *testing.T) {
 
 	modified, err := toRDLTimestamp("2018-03-14T19:36:41.003Z")
@@ -0,0 +1,69 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+)
+
+// Logger appends audit records to a file as json lines
+type Logger struct {
+	lock sync.Mutex
+	out  io.Writer
+}
+
+// NewLogger returns a logger appending to the file, which is created if it
+// does not exist and rotated the same way as the log file
+func NewLogger(path string, rotation log.Rotation) (*Logger, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	// the rotating writer only opens the file on the first record
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	return NewWriterLogger(&lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    rotation.MaxSize,
+		MaxBackups: rotation.MaxBackups,
+		MaxAge:     rotation.MaxAge,
+	}), nil
+}
+
+// NewWriterLogger returns a logger writing to the writer
+func NewWriterLogger(out io.Writer) *Logger {
+	return &Logger{
+		out: out,
+	}
+}
+
+// Record writes the record as a single json line, a nil logger discards it
+func (l *Logger) Record(record interface{}) error {
+	if l == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	_, err = l.out.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,51 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package audit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecord(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := NewWriterLogger(buf)
+
+	err := l.Record(map[string]string{"domain": "a"})
+	assert.Nil(t, err, "error should be nil")
+	err = l.Record(map[string]string{"domain": "b"})
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, "{\"domain\":\"a\"}\n{\"domain\":\"b\"}\n", buf.String(), "records should be written as json lines")
+
+	var nilLogger *Logger
+	assert.Nil(t, nilLogger.Record("discarded"), "nil logger should discard records")
+}
+
+func TestNewLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.Nil(t, err, "error should be nil")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "logs", "audit.log")
+	l, err := NewLogger(path, log.DefaultRotation())
+	assert.Nil(t, err, "error should be nil")
+	err = l.Record("first")
+	assert.Nil(t, err, "error should be nil")
+
+	l, err = NewLogger(path, log.DefaultRotation())
+	assert.Nil(t, err, "error should be nil")
+	err = l.Record("second")
+	assert.Nil(t, err, "error should be nil")
+
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, "\"first\"\n\"second\"\n", string(data), "records should be appended")
+}
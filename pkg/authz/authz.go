@@ -0,0 +1,408 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package authz
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/yahoo/athenz/clients/go/zms"
+
+	"istio.io/api/rbac/v1alpha1"
+	"istio.io/istio/pilot/pkg/model"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/common"
+)
+
+// Request is an access of an Athenz principal to a service
+type Request struct {
+	Principal string `json:"principal"`
+	Namespace string `json:"namespace"`
+	Service   string `json:"service"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+}
+
+// Grant is a ServiceRole rule which allows a request, along with the Athenz
+// role, member and assertion it was converted from
+type Grant struct {
+	Role        zms.ResourceName `json:"role"`
+	Member      zms.MemberName   `json:"member,omitempty"`
+	Assertion   *zms.Assertion   `json:"assertion,omitempty"`
+	ServiceRole string           `json:"serviceRole"`
+	Rule        int              `json:"rule"`
+	Subject     string           `json:"subject"`
+}
+
+// Decision is the answer to an access request. A request to a service for
+// which the ClusterRbacConfig does not enforce authorization is allowed
+// without any grant.
+type Decision struct {
+	Request  Request `json:"request"`
+	Enforced bool    `json:"enforced"`
+	Allowed  bool    `json:"allowed"`
+	Grants   []Grant `json:"grants"`
+}
+
+// Access is a principal which is allowed to access a service through a
+// ServiceRole rule
+type Access struct {
+	Principal   string           `json:"principal"`
+	Subject     string           `json:"subject"`
+	Role        zms.ResourceName `json:"role"`
+	ServiceRole string           `json:"serviceRole"`
+	Rule        int              `json:"rule"`
+	Methods     []string         `json:"methods"`
+	Paths       []string         `json:"paths,omitempty"`
+}
+
+// PrincipalList is the answer to a query of the principals allowed to access
+// a service. A service for which the ClusterRbacConfig does not enforce
+// authorization is open to all the principals without any access listed.
+type PrincipalList struct {
+	Namespace     string   `json:"namespace"`
+	Service       string   `json:"service"`
+	Enforced      bool     `json:"enforced"`
+	AllPrincipals bool     `json:"allPrincipals"`
+	Accesses      []Access `json:"accesses"`
+}
+
+// assertionRule is an Athenz assertion along with the ServiceRole rule it
+// converts into
+type assertionRule struct {
+	assertion *zms.Assertion
+	rule      *v1alpha1.AccessRule
+}
+
+// domain holds the Athenz model and the Istio RBAC resources of a namespace
+type domain struct {
+	model           athenz.Model
+	serviceRoles    []model.Config
+	serviceBindings []model.Config
+	assertionRules  map[zms.ResourceName][]assertionRule
+}
+
+// Engine answers access queries from the Athenz model and the Istio RBAC
+// resources of the namespaces added to it
+type Engine struct {
+	domains       map[string]*domain
+	rbacConfig    *v1alpha1.RbacConfig
+	rbacConfigSet bool
+}
+
+// NewEngine returns an engine without any namespace
+func NewEngine() *Engine {
+	return &Engine{
+		domains: make(map[string]*domain),
+	}
+}
+
+// AddDomain adds the Athenz model of a domain and the ServiceRoles and
+// ServiceRoleBindings of its namespace, which are either the converted or the
+// current resources of the cluster
+func (e *Engine) AddDomain(m athenz.Model, configs []model.Config) {
+	d := &domain{
+		model:          m,
+		assertionRules: newAssertionRules(m),
+	}
+	for _, config := range configs {
+		switch config.Type {
+		case model.ServiceRole.Type:
+			d.serviceRoles = append(d.serviceRoles, config)
+		case model.ServiceRoleBinding.Type:
+			d.serviceBindings = append(d.serviceBindings, config)
+		}
+	}
+	e.domains[m.Namespace] = d
+}
+
+// SetClusterRbacConfig sets the ClusterRbacConfig deciding which services
+// have authorization enforced, nil if it does not exist. Authorization is
+// assumed to be enforced for every service until it is set.
+func (e *Engine) SetClusterRbacConfig(rbacConfig *v1alpha1.RbacConfig) {
+	e.rbacConfig = rbacConfig
+	e.rbacConfigSet = true
+}
+
+// newAssertionRules converts the assertions of the model once, so that the
+// assertion granting a request is found without converting them again
+func newAssertionRules(m athenz.Model) map[zms.ResourceName][]assertionRule {
+	rules := make(map[zms.ResourceName][]assertionRule, len(m.Rules))
+	for role, assertions := range m.Rules {
+		roleName, err := common.ParseRoleFQDN(m.Name, string(role))
+		if err != nil {
+			continue
+		}
+		for _, assertion := range assertions {
+			rule, err := common.ParseAssertionRule(m.Name, roleName, assertion)
+			if err != nil {
+				continue
+			}
+			rules[role] = append(rules[role], assertionRule{assertion: assertion, rule: rule})
+		}
+	}
+	return rules
+}
+
+// matchTarget returns true if the namespace or the service is listed on the
+// ClusterRbacConfig target. The services are listed by hostname, so the
+// service matches a <service>.<namespace>.<dns-suffix> hostname.
+func matchTarget(target *v1alpha1.RbacConfig_Target, namespace, service string) bool {
+	if target == nil {
+		return false
+	}
+	for _, ns := range target.Namespaces {
+		if ns == namespace {
+			return true
+		}
+	}
+	prefix := service + "." + namespace + "."
+	for _, host := range target.Services {
+		if host == service || strings.HasPrefix(host, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Enforced returns true if the ClusterRbacConfig enforces authorization for
+// the service, a service without authorization is open to every principal
+func (e *Engine) Enforced(namespace, service string) bool {
+	if !e.rbacConfigSet {
+		return true
+	}
+	if e.rbacConfig == nil {
+		return false
+	}
+
+	switch e.rbacConfig.Mode {
+	case v1alpha1.RbacConfig_ON:
+		return true
+	case v1alpha1.RbacConfig_ON_WITH_INCLUSION:
+		return matchTarget(e.rbacConfig.Inclusion, namespace, service)
+	case v1alpha1.RbacConfig_ON_WITH_EXCLUSION:
+		return !matchTarget(e.rbacConfig.Exclusion, namespace, service)
+	}
+	return false
+}
+
+// matchValue matches a value against an Istio RBAC value, which may be a
+// wildcard or have a wildcard prefix or suffix
+func matchValue(pattern, value string) bool {
+	switch {
+	case pattern == common.WildCardAll:
+		return true
+	case strings.HasPrefix(pattern, common.WildCardAll):
+		return strings.HasSuffix(value, strings.TrimPrefix(pattern, common.WildCardAll))
+	case strings.HasSuffix(pattern, common.WildCardAll):
+		return strings.HasPrefix(value, strings.TrimSuffix(pattern, common.WildCardAll))
+	}
+	return pattern == value
+}
+
+// matchValues returns true if any of the patterns matches the value, an empty
+// list of patterns matches every value
+func matchValues(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matchValue(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchService returns true if the svc constraint of the rule matches the service
+func matchService(rule *v1alpha1.AccessRule, service string) bool {
+	for _, constraint := range rule.Constraints {
+		if constraint.Key == common.ConstraintSvcKey && !matchValues(constraint.Values, service) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchRule returns true if the rule allows the request
+func matchRule(rule *v1alpha1.AccessRule, req Request) bool {
+	return matchService(rule, req.Service) &&
+		matchValues(rule.Methods, strings.ToUpper(req.Method)) &&
+		matchValues(rule.Paths, req.Path)
+}
+
+// SpiffeToPrincipal converts a ServiceRoleBinding subject back into an Athenz
+// principal, e.g. client-domain.frontend/sa/some-app -> client-domain.frontend.some-app
+func SpiffeToPrincipal(subject string) string {
+	if subject == common.WildCardAll {
+		return "user.*"
+	}
+	return strings.Replace(subject, "/sa/", ".", 1)
+}
+
+// bindings returns the subjects bound to a ServiceRole
+func (d *domain) bindings(roleName string) []string {
+	subjects := make([]string, 0)
+	for _, config := range d.serviceBindings {
+		binding, ok := config.Spec.(*v1alpha1.ServiceRoleBinding)
+		if !ok || binding.RoleRef == nil || binding.RoleRef.Name != roleName {
+			continue
+		}
+		for _, subject := range binding.Subjects {
+			subjects = append(subjects, subject.User)
+		}
+	}
+	return subjects
+}
+
+// roleFQDN returns the Athenz role a ServiceRole was converted from
+func (d *domain) roleFQDN(roleName string) zms.ResourceName {
+	return zms.ResourceName(fmt.Sprintf("%s:role.%s", d.model.Name, roleName))
+}
+
+// member returns the member of the Athenz role which matches the principal
+func (d *domain) member(role zms.ResourceName, principal string) zms.MemberName {
+	for _, member := range d.model.Members[role] {
+		if string(member.MemberName) == principal {
+			return member.MemberName
+		}
+	}
+	for _, member := range d.model.Members[role] {
+		if string(member.MemberName) == "user.*" {
+			return member.MemberName
+		}
+	}
+	return ""
+}
+
+// assertion returns the assertion of the Athenz role which allows the request
+func (d *domain) assertion(role zms.ResourceName, req Request) *zms.Assertion {
+	for _, assertionRule := range d.assertionRules[role] {
+		if matchRule(assertionRule.rule, req) {
+			return assertionRule.assertion
+		}
+	}
+	return nil
+}
+
+// Check returns whether the principal of the request is allowed to access the
+// service, with every ServiceRole rule which allows it. A service without
+// authorization enforced by the ClusterRbacConfig allows every request.
+func (e *Engine) Check(req Request) Decision {
+	decision := Decision{
+		Request:  req,
+		Enforced: e.Enforced(req.Namespace, req.Service),
+		Grants:   make([]Grant, 0),
+	}
+	if !decision.Enforced {
+		decision.Allowed = true
+		return decision
+	}
+
+	d, exists := e.domains[req.Namespace]
+	if !exists {
+		return decision
+	}
+
+	subject, err := common.PrincipalToSpiffe(req.Principal)
+	if err != nil {
+		return decision
+	}
+
+	for _, config := range d.serviceRoles {
+		serviceRole, ok := config.Spec.(*v1alpha1.ServiceRole)
+		if !ok {
+			continue
+		}
+
+		bound := ""
+		for _, s := range d.bindings(config.Name) {
+			if s == subject || s == common.WildCardAll {
+				bound = s
+				break
+			}
+		}
+		if bound == "" {
+			continue
+		}
+
+		role := d.roleFQDN(config.Name)
+		for i, rule := range serviceRole.Rules {
+			if !matchRule(rule, req) {
+				continue
+			}
+			decision.Grants = append(decision.Grants, Grant{
+				Role:        role,
+				Member:      d.member(role, req.Principal),
+				Assertion:   d.assertion(role, req),
+				ServiceRole: config.Namespace + "/" + config.Name,
+				Rule:        i,
+				Subject:     bound,
+			})
+		}
+	}
+
+	decision.Allowed = len(decision.Grants) > 0
+	return decision
+}
+
+// Principals returns every principal which is allowed to access the service,
+// with each ServiceRole rule granting the access. A service without
+// authorization enforced by the ClusterRbacConfig is open to all the
+// principals.
+func (e *Engine) Principals(namespace, service string) PrincipalList {
+	list := PrincipalList{
+		Namespace: namespace,
+		Service:   service,
+		Enforced:  e.Enforced(namespace, service),
+		Accesses:  make([]Access, 0),
+	}
+	if !list.Enforced {
+		list.AllPrincipals = true
+		return list
+	}
+
+	d, exists := e.domains[namespace]
+	if !exists {
+		return list
+	}
+
+	accesses := list.Accesses
+
+	for _, config := range d.serviceRoles {
+		serviceRole, ok := config.Spec.(*v1alpha1.ServiceRole)
+		if !ok {
+			continue
+		}
+
+		subjects := d.bindings(config.Name)
+		for i, rule := range serviceRole.Rules {
+			if !matchService(rule, service) {
+				continue
+			}
+			for _, subject := range subjects {
+				accesses = append(accesses, Access{
+					Principal:   SpiffeToPrincipal(subject),
+					Subject:     subject,
+					Role:        d.roleFQDN(config.Name),
+					ServiceRole: config.Namespace + "/" + config.Name,
+					Rule:        i,
+					Methods:     rule.Methods,
+					Paths:       rule.Paths,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(accesses, func(i, j int) bool {
+		if accesses[i].Principal != accesses[j].Principal {
+			return accesses[i].Principal < accesses[j].Principal
+		}
+		return accesses[i].ServiceRole < accesses[j].ServiceRole
+	})
+	list.Accesses = accesses
+	return list
+}
@@ -0,0 +1,278 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package authz
+
+import (
+	"testing"
+
+	"github.com/yahoo/athenz/clients/go/zms"
+
+	"istio.io/api/rbac/v1alpha1"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	rbacv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/v1"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	log.InitLogger("", "debug")
+}
+
+func newModel() athenz.Model {
+	allow := zms.ALLOW
+	return athenz.Model{
+		Name:      "backend.domain",
+		Namespace: "backend-domain",
+		Roles: []zms.ResourceName{
+			"backend.domain:role.reader",
+			"backend.domain:role.public",
+		},
+		Rules: athenz.RoleAssertions{
+			"backend.domain:role.reader": {
+				{
+					Effect:   &allow,
+					Action:   "get",
+					Role:     "backend.domain:role.reader",
+					Resource: "backend.domain:svc.backend:/api/*",
+				},
+				{
+					Effect:   &allow,
+					Action:   "post",
+					Role:     "backend.domain:role.reader",
+					Resource: "backend.domain:svc.backend:/api/search",
+				},
+			},
+			"backend.domain:role.public": {
+				{
+					Effect:   &allow,
+					Action:   "get",
+					Role:     "backend.domain:role.public",
+					Resource: "backend.domain:svc.status",
+				},
+			},
+		},
+		Members: athenz.RoleMembers{
+			"backend.domain:role.reader": {
+				{MemberName: "frontend.domain.frontend"},
+			},
+			"backend.domain:role.public": {
+				{MemberName: "user.*"},
+			},
+		},
+	}
+}
+
+func newEngine() *Engine {
+	m := newModel()
+	e := NewEngine()
+	e.AddDomain(m, rbacv1.NewProvider().ConvertAthenzModelIntoIstioRbac(m))
+	return e
+}
+
+func TestCheck(t *testing.T) {
+	e := newEngine()
+
+	tests := []struct {
+		name      string
+		req       Request
+		allowed   bool
+		role      zms.ResourceName
+		assertion string
+	}{
+		{
+			name:      "should allow a member of the role",
+			req:       Request{Principal: "frontend.domain.frontend", Namespace: "backend-domain", Service: "backend", Method: "GET", Path: "/api/users"},
+			allowed:   true,
+			role:      "backend.domain:role.reader",
+			assertion: "backend.domain:svc.backend:/api/*",
+		},
+		{
+			name:      "should match the method case insensitively",
+			req:       Request{Principal: "frontend.domain.frontend", Namespace: "backend-domain", Service: "backend", Method: "post", Path: "/api/search"},
+			allowed:   true,
+			role:      "backend.domain:role.reader",
+			assertion: "backend.domain:svc.backend:/api/search",
+		},
+		{
+			name:    "should deny a method which is not allowed",
+			req:     Request{Principal: "frontend.domain.frontend", Namespace: "backend-domain", Service: "backend", Method: "DELETE", Path: "/api/users"},
+			allowed: false,
+		},
+		{
+			name:    "should deny a path which is not allowed",
+			req:     Request{Principal: "frontend.domain.frontend", Namespace: "backend-domain", Service: "backend", Method: "GET", Path: "/admin"},
+			allowed: false,
+		},
+		{
+			name:    "should deny a principal which is not a member",
+			req:     Request{Principal: "other.domain.client", Namespace: "backend-domain", Service: "backend", Method: "GET", Path: "/api/users"},
+			allowed: false,
+		},
+		{
+			name:      "should allow any principal through a wildcard member",
+			req:       Request{Principal: "other.domain.client", Namespace: "backend-domain", Service: "status", Method: "GET", Path: "/"},
+			allowed:   true,
+			role:      "backend.domain:role.public",
+			assertion: "backend.domain:svc.status",
+		},
+		{
+			name:    "should deny a namespace without domain",
+			req:     Request{Principal: "frontend.domain.frontend", Namespace: "missing", Service: "backend", Method: "GET", Path: "/api/users"},
+			allowed: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := e.Check(tt.req)
+			assert.True(t, decision.Enforced, "authorization should be enforced without a cluster rbac config set")
+			assert.Equal(t, tt.allowed, decision.Allowed, "decision should be equal")
+			if !tt.allowed {
+				assert.Equal(t, 0, len(decision.Grants), "grants should be empty")
+				return
+			}
+			assert.Equal(t, 1, len(decision.Grants), "one grant should allow the request")
+			assert.Equal(t, tt.role, decision.Grants[0].Role, "role should be equal")
+			assert.NotNil(t, decision.Grants[0].Assertion, "assertion should be found")
+			assert.Equal(t, tt.assertion, decision.Grants[0].Assertion.Resource, "assertion should be equal")
+		})
+	}
+}
+
+func TestCheckEnforced(t *testing.T) {
+	req := Request{Principal: "other.domain.client", Namespace: "backend-domain", Service: "backend", Method: "GET", Path: "/api/users"}
+
+	tests := []struct {
+		name       string
+		rbacConfig *v1alpha1.RbacConfig
+		enforced   bool
+	}{
+		{
+			name:       "should allow a request if the cluster rbac config does not exist",
+			rbacConfig: nil,
+			enforced:   false,
+		},
+		{
+			name: "should allow a request to a service which is not onboarded",
+			rbacConfig: &v1alpha1.RbacConfig{
+				Mode:      v1alpha1.RbacConfig_ON_WITH_INCLUSION,
+				Inclusion: &v1alpha1.RbacConfig_Target{Services: []string{"status.backend-domain.svc.cluster.local"}},
+			},
+			enforced: false,
+		},
+		{
+			name: "should deny a request to an onboarded service",
+			rbacConfig: &v1alpha1.RbacConfig{
+				Mode:      v1alpha1.RbacConfig_ON_WITH_INCLUSION,
+				Inclusion: &v1alpha1.RbacConfig_Target{Services: []string{"backend.backend-domain.svc.cluster.local"}},
+			},
+			enforced: true,
+		},
+		{
+			name: "should deny a request to a service of an onboarded namespace",
+			rbacConfig: &v1alpha1.RbacConfig{
+				Mode:      v1alpha1.RbacConfig_ON_WITH_INCLUSION,
+				Inclusion: &v1alpha1.RbacConfig_Target{Namespaces: []string{"backend-domain"}},
+			},
+			enforced: true,
+		},
+		{
+			name: "should allow a request to an excluded service",
+			rbacConfig: &v1alpha1.RbacConfig{
+				Mode:      v1alpha1.RbacConfig_ON_WITH_EXCLUSION,
+				Exclusion: &v1alpha1.RbacConfig_Target{Services: []string{"backend.backend-domain.svc.cluster.local"}},
+			},
+			enforced: false,
+		},
+		{
+			name: "should deny a request to a service which is not excluded",
+			rbacConfig: &v1alpha1.RbacConfig{
+				Mode: v1alpha1.RbacConfig_ON_WITH_EXCLUSION,
+			},
+			enforced: true,
+		},
+		{
+			name: "should deny a request in ON mode",
+			rbacConfig: &v1alpha1.RbacConfig{
+				Mode: v1alpha1.RbacConfig_ON,
+			},
+			enforced: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEngine()
+			e.SetClusterRbacConfig(tt.rbacConfig)
+			decision := e.Check(req)
+			assert.Equal(t, tt.enforced, decision.Enforced, "enforcement should be equal")
+			assert.Equal(t, !tt.enforced, decision.Allowed, "decision should be equal")
+			assert.Equal(t, 0, len(decision.Grants), "grants should be empty")
+		})
+	}
+}
+
+func TestPrincipals(t *testing.T) {
+	e := newEngine()
+
+	list := e.Principals("backend-domain", "backend")
+	assert.True(t, list.Enforced, "authorization should be enforced without a cluster rbac config set")
+	assert.False(t, list.AllPrincipals, "service should not be open to all the principals")
+	accesses := list.Accesses
+	assert.Equal(t, 2, len(accesses), "each rule of the service should be listed")
+	assert.Equal(t, "frontend.domain.frontend", accesses[0].Principal, "principal should be equal")
+	assert.Equal(t, "frontend.domain/sa/frontend", accesses[0].Subject, "subject should be equal")
+	assert.Equal(t, []string{"GET"}, accesses[0].Methods, "methods should be equal")
+	assert.Equal(t, []string{"/api/*"}, accesses[0].Paths, "paths should be equal")
+
+	accesses = e.Principals("backend-domain", "status").Accesses
+	assert.Equal(t, 1, len(accesses), "wildcard member should be listed")
+	assert.Equal(t, "user.*", accesses[0].Principal, "principal should be equal")
+
+	assert.Equal(t, 0, len(e.Principals("missing", "backend").Accesses), "namespace without domain should have no principals")
+}
+
+func TestPrincipalsEnforcement(t *testing.T) {
+	e := newEngine()
+	e.SetClusterRbacConfig(&v1alpha1.RbacConfig{
+		Mode:      v1alpha1.RbacConfig_ON_WITH_INCLUSION,
+		Inclusion: &v1alpha1.RbacConfig_Target{Services: []string{"backend.backend-domain.svc.cluster.local"}},
+	})
+
+	list := e.Principals("backend-domain", "backend")
+	assert.True(t, list.Enforced, "onboarded service should be enforced")
+	assert.False(t, list.AllPrincipals, "onboarded service should not be open to all the principals")
+	assert.Equal(t, 2, len(list.Accesses), "each rule of the onboarded service should be listed")
+
+	list = e.Principals("backend-domain", "status")
+	assert.False(t, list.Enforced, "service which is not onboarded should not be enforced")
+	assert.True(t, list.AllPrincipals, "service which is not onboarded should be open to all the principals")
+	assert.Equal(t, 0, len(list.Accesses), "no access should be listed for a service which is not enforced")
+
+	e.SetClusterRbacConfig(nil)
+	list = e.Principals("backend-domain", "backend")
+	assert.False(t, list.Enforced, "service should not be enforced without a cluster rbac config")
+	assert.True(t, list.AllPrincipals, "service should be open to all the principals without a cluster rbac config")
+}
+
+func TestMatchValue(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		value    string
+		expected bool
+	}{
+		{pattern: "*", value: "/any", expected: true},
+		{pattern: "/api/*", value: "/api/users", expected: true},
+		{pattern: "/api/*", value: "/admin", expected: false},
+		{pattern: "*.json", value: "/data.json", expected: true},
+		{pattern: "/api", value: "/api", expected: true},
+		{pattern: "/api", value: "/api/users", expected: false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, matchValue(tt.pattern, tt.value), tt.pattern+" "+tt.value)
+	}
+}
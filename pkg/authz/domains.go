@@ -0,0 +1,72 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package authz
+
+import (
+	"io"
+
+	"istio.io/api/rbac/v1alpha1"
+	"istio.io/istio/pilot/pkg/model"
+
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	adv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/apis/athenz/v1"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac"
+)
+
+// ReadAthenzDomains reads the AthenzDomain resources of a YAML or JSON stream,
+// which may hold several YAML documents
+func ReadAthenzDomains(r io.Reader) ([]*adv1.AthenzDomain, error) {
+	domains := make([]*adv1.AthenzDomain, 0)
+	decoder := yaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		athenzDomain := &adv1.AthenzDomain{}
+		err := decoder.Decode(athenzDomain)
+		if err == io.EOF {
+			return domains, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if athenzDomain.Spec.SignedDomain.Domain == nil {
+			continue
+		}
+		domains = append(domains, athenzDomain)
+	}
+}
+
+// NewEngineFromDomains returns an engine for the AthenzDomains. The
+// ServiceRoles, ServiceRoleBindings and ClusterRbacConfig are read from the
+// config store, or the ServiceRoles and ServiceRoleBindings are converted from
+// the domains if the store is nil.
+func NewEngineFromDomains(domains []*adv1.AthenzDomain, provider rbac.Provider, csc model.ConfigStoreCache) *Engine {
+	e := NewEngine()
+	if csc != nil {
+		e.SetClusterRbacConfig(getClusterRbacConfig(csc))
+	}
+	for _, athenzDomain := range domains {
+		m := athenz.ConvertVersionedPoliciesIntoRbacModel(athenzDomain.Spec.SignedDomain.Domain, athenzDomain.Spec.PolicyVersions, "")
+		if csc == nil {
+			e.AddDomain(m, provider.ConvertAthenzModelIntoIstioRbac(m))
+			continue
+		}
+		e.AddDomain(m, provider.GetCurrentIstioRbac(m, csc))
+	}
+	return e
+}
+
+// getClusterRbacConfig returns the ClusterRbacConfig of the config store, or
+// nil if it does not exist
+func getClusterRbacConfig(csc model.ConfigStoreCache) *v1alpha1.RbacConfig {
+	config := csc.Get(model.ClusterRbacConfig.Type, model.DefaultRbacConfigName, "")
+	if config == nil {
+		return nil
+	}
+	rbacConfig, ok := config.Spec.(*v1alpha1.RbacConfig)
+	if !ok {
+		return nil
+	}
+	return rbacConfig
+}
@@ -0,0 +1,66 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package authz
+
+import (
+	"strings"
+	"testing"
+
+	rbacv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/v1"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const domainsYAML = `apiVersion: athenz.io/v1
+kind: AthenzDomain
+metadata:
+  name: backend.domain
+spec:
+  domain:
+    name: backend.domain
+    modified: "2019-05-01T00:00:00.000Z"
+    roles:
+    - name: backend.domain:role.reader
+      modified: "2019-05-01T00:00:00.000Z"
+      roleMembers:
+      - memberName: frontend.domain.frontend
+    policies:
+      contents:
+        domain: backend.domain
+        policies:
+        - name: backend.domain:policy.reader
+          assertions:
+          - role: backend.domain:role.reader
+            resource: backend.domain:svc.backend
+            action: get
+            effect: ALLOW
+      keyId: "0"
+      signature: signature
+  keyId: "0"
+  signature: signature
+---
+apiVersion: athenz.io/v1
+kind: AthenzDomain
+metadata:
+  name: empty.domain
+`
+
+func TestReadAthenzDomains(t *testing.T) {
+	domains, err := ReadAthenzDomains(strings.NewReader(domainsYAML))
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, 1, len(domains), "only domains with a spec should be read")
+
+	e := NewEngineFromDomains(domains, rbacv1.NewProvider(), nil)
+	decision := e.Check(Request{
+		Principal: "frontend.domain.frontend",
+		Namespace: "backend-domain",
+		Service:   "backend",
+		Method:    "GET",
+		Path:      "/",
+	})
+	assert.True(t, decision.Allowed, "access should be allowed by the converted domain")
+
+	_, err = ReadAthenzDomains(strings.NewReader("spec: ["))
+	assert.NotNil(t, err, "invalid yaml should return an error")
+}
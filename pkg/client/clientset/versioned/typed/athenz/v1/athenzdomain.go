@@ -24,6 +24,7 @@ type AthenzDomainsGetter interface {
 type AthenzDomainInterface interface {
 	Create(*v1.AthenzDomain) (*v1.AthenzDomain, error)
 	Update(*v1.AthenzDomain) (*v1.AthenzDomain, error)
+	UpdateStatus(*v1.AthenzDomain) (*v1.AthenzDomain, error)
 	Delete(name string, options *meta_v1.DeleteOptions) error
 	DeleteCollection(options *meta_v1.DeleteOptions, listOptions meta_v1.ListOptions) error
 	Get(name string, options meta_v1.GetOptions) (*v1.AthenzDomain, error)
@@ -107,6 +108,22 @@ func (c *athenzDomains) Update(athenzDomain *v1.AthenzDomain) (result *v1.Athenz
 	return
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+
+func (c *athenzDomains) UpdateStatus(athenzDomain *v1.AthenzDomain) (result *v1.AthenzDomain, err error) {
+	result = &v1.AthenzDomain{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("athenzdomains").
+		Name(athenzDomain.Name).
+		SubResource("status").
+		Body(athenzDomain).
+		Do().
+		Into(result)
+	return
+}
+
 // Delete takes name of the athenzDomain and deletes it. Returns an error if one occurs.
 func (c *athenzDomains) Delete(name string, options *meta_v1.DeleteOptions) error {
 	return c.client.Delete().
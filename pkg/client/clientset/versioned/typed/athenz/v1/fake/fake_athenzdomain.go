@@ -87,6 +87,18 @@ func (c *FakeAthenzDomains) Update(athenzDomain *athenz_v1.AthenzDomain) (result
 	return obj.(*athenz_v1.AthenzDomain), err
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeAthenzDomains) UpdateStatus(athenzDomain *athenz_v1.AthenzDomain) (*athenz_v1.AthenzDomain, error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateSubresourceAction(athenzdomainsResource, "status", c.ns, athenzDomain), &athenz_v1.AthenzDomain{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*athenz_v1.AthenzDomain), err
+}
+
 // Delete takes name of the athenzDomain and deletes it. Returns an error if one occurs.
 func (c *FakeAthenzDomains) Delete(name string, options *v1.DeleteOptions) error {
 	_, err := c.Fake.
@@ -0,0 +1,361 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"istio.io/api/rbac/v1alpha1"
+	"k8s.io/client-go/rest"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/filter"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/limits"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/retry"
+)
+
+// Version is the version of the config file format
+const Version = "v1"
+
+// Duration is a time.Duration written as a duration string, e.g. 1h30m
+type Duration struct {
+	time.Duration
+}
+
+// Set parses a duration string
+func (d *Duration) Set(s string) error {
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = duration
+	return nil
+}
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return fmt.Errorf("duration must be a string such as 30s or 1h, got %s", data)
+	}
+	return d.Set(s)
+}
+
+// MarshalJSON writes the duration as a duration string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// AthenzDomainsConfig configures the sync of the athenz domains
+type AthenzDomainsConfig struct {
+	ResyncInterval Duration `json:"resyncInterval"`
+	SkipUnchanged  bool     `json:"skipUnchanged"`
+	Workers        int      `json:"workers"`
+}
+
+// ClusterRbacConfigConfig configures the onboarding of the services
+type ClusterRbacConfigConfig struct {
+	ResyncInterval Duration `json:"resyncInterval"`
+	Debounce       Duration `json:"debounce"`
+	Mode           string   `json:"mode"`
+	SafeOnboarding bool     `json:"safeOnboarding"`
+}
+
+// ProcessorConfig configures the writes of the istio custom resources
+type ProcessorConfig struct {
+	Workers int `json:"workers"`
+}
+
+// KubeConfig configures the kubernetes and athenz domain clients and the rate of the Istio writes
+type KubeConfig struct {
+	Kubeconfig string  `json:"kubeconfig"`
+	QPS        float64 `json:"qps"`
+	Burst      int     `json:"burst"`
+}
+
+// RetryConfig configures the retries of the failed keys
+type RetryConfig struct {
+	BaseDelay  Duration `json:"baseDelay"`
+	MaxDelay   Duration `json:"maxDelay"`
+	MaxRetries int      `json:"maxRetries"`
+}
+
+// FilterConfig scopes the controller to a subset of the namespaces and athenz
+// domains
+type FilterConfig struct {
+	NamespaceInclude   []string `json:"namespaceInclude"`
+	NamespaceExclude   []string `json:"namespaceExclude"`
+	NamespaceSelector  string   `json:"namespaceSelector"`
+	DomainSelector     string   `json:"domainSelector"`
+	DomainIncludeRegex string   `json:"domainIncludeRegex"`
+	DomainExcludeRegex string   `json:"domainExcludeRegex"`
+}
+
+// LimitsConfig caps the removals applied by a single sync
+type LimitsConfig struct {
+	MaxDeletions       int `json:"maxDeletions"`
+	MaxDeletionPercent int `json:"maxDeletionPercent"`
+	MaxServiceRemovals int `json:"maxServiceRemovals"`
+}
+
+// PreflightConfig configures the retries of the startup checks
+type PreflightConfig struct {
+	BaseDelay  Duration `json:"baseDelay"`
+	MaxDelay   Duration `json:"maxDelay"`
+	MaxRetries int      `json:"maxRetries"`
+}
+
+// LogConfig configures the logger
+type LogConfig struct {
+	File       string `json:"file"`
+	Level      string `json:"level"`
+	Format     string `json:"format"`
+	MaxSize    int    `json:"maxSize"`
+	MaxBackups int    `json:"maxBackups"`
+	MaxAge     int    `json:"maxAge"`
+}
+
+// HTTPConfig configures the http server
+type HTTPConfig struct {
+	Addr           string `json:"addr"`
+	DebugEndpoints bool   `json:"debugEndpoints"`
+	DebugTokenFile string `json:"debugTokenFile"`
+}
+
+// Config holds the settings of the controller
+type Config struct {
+	Version             string                  `json:"version"`
+	DNSSuffix           string                  `json:"dnsSuffix"`
+	ProtectedNamespaces []string                `json:"protectedNamespaces"`
+	ReloadInterval      Duration                `json:"reloadInterval"`
+	ShutdownTimeout     Duration                `json:"shutdownTimeout"`
+	AuditLogFile        string                  `json:"auditLogFile"`
+	AthenzDomains       AthenzDomainsConfig     `json:"athenzDomains"`
+	ClusterRbacConfig   ClusterRbacConfigConfig `json:"clusterRbacConfig"`
+	Processor           ProcessorConfig         `json:"processor"`
+	Kube                KubeConfig              `json:"kube"`
+	Retry               RetryConfig             `json:"retry"`
+	Filter              FilterConfig            `json:"filter"`
+	Limits              LimitsConfig            `json:"limits"`
+	Preflight           PreflightConfig         `json:"preflight"`
+	Log                 LogConfig               `json:"log"`
+	HTTP                HTTPConfig              `json:"http"`
+}
+
+// Default returns the config used for the settings which are not set
+func Default() *Config {
+	rotation := log.DefaultRotation()
+	retryPolicy := retry.DefaultPolicy()
+	return &Config{
+		Version:             Version,
+		DNSSuffix:           "svc.cluster.local",
+		ProtectedNamespaces: []string{"istio-system", "kube-system", "default"},
+		ReloadInterval:      Duration{30 * time.Second},
+		ShutdownTimeout:     Duration{25 * time.Second},
+		AthenzDomains: AthenzDomainsConfig{
+			ResyncInterval: Duration{time.Hour},
+			Workers:        1,
+		},
+		ClusterRbacConfig: ClusterRbacConfigConfig{
+			ResyncInterval: Duration{time.Hour},
+			Debounce:       Duration{time.Second},
+			Mode:           "ON_WITH_INCLUSION",
+		},
+		Processor: ProcessorConfig{
+			Workers: 1,
+		},
+		Kube: KubeConfig{
+			QPS:   float64(rest.DefaultQPS),
+			Burst: rest.DefaultBurst,
+		},
+		Retry: RetryConfig{
+			BaseDelay:  Duration{retryPolicy.BaseDelay},
+			MaxDelay:   Duration{retryPolicy.MaxDelay},
+			MaxRetries: retryPolicy.MaxRetries,
+		},
+		Preflight: PreflightConfig{
+			BaseDelay:  Duration{time.Second},
+			MaxDelay:   Duration{30 * time.Second},
+			MaxRetries: 10,
+		},
+		Log: LogConfig{
+			File:       "/var/log/k8s-athenz-istio-auth/k8s-athenz-istio-auth.log",
+			Level:      "info",
+			Format:     log.FormatText,
+			MaxSize:    rotation.MaxSize,
+			MaxBackups: rotation.MaxBackups,
+			MaxAge:     rotation.MaxAge,
+		},
+		HTTP: HTTPConfig{
+			Addr: ":8080",
+		},
+	}
+}
+
+// RetryPolicy returns the retry policy of the config
+func (c *Config) RetryPolicy() retry.Policy {
+	return retry.Policy{
+		BaseDelay:  c.Retry.BaseDelay.Duration,
+		MaxDelay:   c.Retry.MaxDelay.Duration,
+		MaxRetries: c.Retry.MaxRetries,
+	}
+}
+
+// PreflightPolicy returns the retry policy of the startup checks
+func (c *Config) PreflightPolicy() retry.Policy {
+	return retry.Policy{
+		BaseDelay:  c.Preflight.BaseDelay.Duration,
+		MaxDelay:   c.Preflight.MaxDelay.Duration,
+		MaxRetries: c.Preflight.MaxRetries,
+	}
+}
+
+// ParseMode parses the ClusterRbacConfig mode managed by the controller, OFF is
+// not supported as the controller would then have no reason to exist
+func ParseMode(mode string) (v1alpha1.RbacConfig_Mode, error) {
+	value, exists := v1alpha1.RbacConfig_Mode_value[mode]
+	if !exists || v1alpha1.RbacConfig_Mode(value) == v1alpha1.RbacConfig_OFF {
+		return v1alpha1.RbacConfig_OFF, fmt.Errorf("mode: %s is not one of %s, %s or %s", mode,
+			v1alpha1.RbacConfig_ON_WITH_INCLUSION, v1alpha1.RbacConfig_ON_WITH_EXCLUSION, v1alpha1.RbacConfig_ON)
+	}
+	return v1alpha1.RbacConfig_Mode(value), nil
+}
+
+// CRCMode returns the ClusterRbacConfig mode of the config
+func (c *Config) CRCMode() (v1alpha1.RbacConfig_Mode, error) {
+	return ParseMode(c.ClusterRbacConfig.Mode)
+}
+
+// NamespaceFilter returns the namespace and domain filter of the config
+func (c *Config) NamespaceFilter() (*filter.Filter, error) {
+	f := c.Filter
+	return filter.New(f.NamespaceInclude, f.NamespaceExclude, f.NamespaceSelector, f.DomainSelector, f.DomainIncludeRegex, f.DomainExcludeRegex)
+}
+
+// DeletionLimits returns the limits of the deletions of generated Istio RBAC
+// resources applied by a single sync of a domain
+func (c *Config) DeletionLimits() limits.Limits {
+	return limits.Limits{
+		MaxRemovals:       c.Limits.MaxDeletions,
+		MaxRemovalPercent: c.Limits.MaxDeletionPercent,
+	}
+}
+
+// ServiceRemovalLimits returns the limits of the services removed from the
+// ClusterRbacConfig by a single sync
+func (c *Config) ServiceRemovalLimits() limits.Limits {
+	return limits.Limits{
+		MaxRemovals: c.Limits.MaxServiceRemovals,
+	}
+}
+
+// LogRotation returns the log rotation of the config
+func (c *Config) LogRotation() log.Rotation {
+	return log.Rotation{
+		MaxSize:    c.Log.MaxSize,
+		MaxBackups: c.Log.MaxBackups,
+		MaxAge:     c.Log.MaxAge,
+	}
+}
+
+// Errors holds all the errors found in a config
+type Errors []error
+
+// Error returns the errors separated by semicolons
+func (e Errors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return fmt.Sprintf("%d config error(s): %s", len(e), strings.Join(messages, "; "))
+}
+
+// Validate returns all the invalid settings of the config at once, or nil if
+// the config is valid
+func (c *Config) Validate() error {
+	errs := Errors{}
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Version != Version {
+		add("version: %q is not supported, must be %s", c.Version, Version)
+	}
+	if c.DNSSuffix == "" {
+		add("dnsSuffix: must be set")
+	}
+	if c.ReloadInterval.Duration <= 0 {
+		add("reloadInterval: must be positive")
+	}
+	if c.ShutdownTimeout.Duration <= 0 {
+		add("shutdownTimeout: must be positive")
+	}
+	if c.AthenzDomains.ResyncInterval.Duration <= 0 {
+		add("athenzDomains.resyncInterval: must be positive")
+	}
+	if c.AthenzDomains.Workers < 1 {
+		add("athenzDomains.workers: must be at least 1")
+	}
+	if c.ClusterRbacConfig.ResyncInterval.Duration <= 0 {
+		add("clusterRbacConfig.resyncInterval: must be positive")
+	}
+	if c.ClusterRbacConfig.Debounce.Duration < 0 {
+		add("clusterRbacConfig.debounce: must not be negative")
+	}
+	if _, err := c.CRCMode(); err != nil {
+		add("clusterRbacConfig.mode: %s", err.Error())
+	}
+	if c.Processor.Workers < 1 {
+		add("processor.workers: must be at least 1")
+	}
+	if c.Kube.QPS <= 0 {
+		add("kube.qps: must be positive")
+	}
+	if c.Kube.Burst < 1 {
+		add("kube.burst: must be at least 1")
+	}
+	if err := c.RetryPolicy().Validate(); err != nil {
+		add("retry: %s", err.Error())
+	}
+	if _, err := c.NamespaceFilter(); err != nil {
+		add("filter: %s", err.Error())
+	}
+	if c.Limits.MaxDeletions < 0 {
+		add("limits.maxDeletions: must not be negative")
+	}
+	if c.Limits.MaxDeletionPercent < 0 || c.Limits.MaxDeletionPercent > 100 {
+		add("limits.maxDeletionPercent: must be between 0 and 100")
+	}
+	if c.Limits.MaxServiceRemovals < 0 {
+		add("limits.maxServiceRemovals: must not be negative")
+	}
+	if err := c.PreflightPolicy().Validate(); err != nil {
+		add("preflight: %s", err.Error())
+	}
+	if _, err := logrus.ParseLevel(c.Log.Level); err != nil {
+		add("log.level: %s", err.Error())
+	}
+	if c.Log.Format != log.FormatText && c.Log.Format != log.FormatJSON {
+		add("log.format: %q is not one of %s or %s", c.Log.Format, log.FormatText, log.FormatJSON)
+	}
+	if c.Log.MaxSize < 0 || c.Log.MaxBackups < 0 || c.Log.MaxAge < 0 {
+		add("log: maxSize, maxBackups and maxAge must not be negative")
+	}
+	if c.HTTP.Addr == "" {
+		add("http.addr: must be set")
+	}
+	if c.HTTP.DebugEndpoints && c.HTTP.DebugTokenFile == "" {
+		add("http.debugTokenFile: must be set when http.debugEndpoints is set")
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
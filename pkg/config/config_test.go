@@ -0,0 +1,157 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"istio.io/api/rbac/v1alpha1"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+)
+
+func init() {
+	log.InitLogger("", "debug")
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(c *Config)
+		expected []string
+	}{
+		{
+			name:     "should accept the default config",
+			modify:   func(c *Config) {},
+			expected: nil,
+		},
+		{
+			name: "should report all the invalid settings",
+			modify: func(c *Config) {
+				c.Version = "v0"
+				c.AthenzDomains.Workers = 0
+				c.ClusterRbacConfig.Mode = "OFF"
+				c.Log.Format = "xml"
+				c.Limits.MaxDeletionPercent = 150
+			},
+			expected: []string{
+				`version: "v0" is not supported, must be v1`,
+				"athenzDomains.workers: must be at least 1",
+				"clusterRbacConfig.mode: mode: OFF is not one of ON_WITH_INCLUSION, ON_WITH_EXCLUSION or ON",
+				"limits.maxDeletionPercent: must be between 0 and 100",
+				`log.format: "xml" is not one of text or json`,
+			},
+		},
+		{
+			name: "should report an invalid filter",
+			modify: func(c *Config) {
+				c.Filter.NamespaceSelector = "team in (a"
+			},
+			expected: []string{"filter: error parsing the namespace selector: unable to parse requirement: found '', expected: ',' or ')'"},
+		},
+		{
+			name: "should require a debug token file for the debug endpoints",
+			modify: func(c *Config) {
+				c.HTTP.DebugEndpoints = true
+			},
+			expected: []string{"http.debugTokenFile: must be set when http.debugEndpoints is set"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			tt.modify(c)
+			err := c.Validate()
+			if tt.expected == nil {
+				assert.Nil(t, err, "error should be nil")
+				return
+			}
+			errs, ok := err.(Errors)
+			assert.True(t, ok, "error should hold all the errors")
+			messages := make([]string, 0)
+			for _, e := range errs {
+				messages = append(messages, e.Error())
+			}
+			assert.Equal(t, tt.expected, messages, "errors should be equal")
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := Default()
+	new := Default()
+	new.Log.Level = "debug"
+	new.AthenzDomains.ResyncInterval = Duration{time.Minute}
+	new.ClusterRbacConfig.Mode = "ON"
+
+	expected := []Change{
+		{Setting: "athenzDomains.resyncInterval", Old: "1h0m0s", New: "1m0s", Reloadable: true},
+		{Setting: "clusterRbacConfig.mode", Old: "ON_WITH_INCLUSION", New: "ON", Reloadable: false},
+		{Setting: "log.level", Old: "info", New: "debug", Reloadable: true},
+	}
+	assert.Equal(t, expected, Diff(old, new), "changes should be equal")
+	assert.Equal(t, 0, len(Diff(old, Default())), "equal configs should have no changes")
+}
+
+func TestSettings(t *testing.T) {
+	names := make(map[string]bool)
+	fields := make(map[string]bool)
+	for _, s := range settings {
+		assert.False(t, names[s.name], "flag %s should be unique", s.name)
+		assert.False(t, fields[s.field], "field %s should be unique", s.field)
+		names[s.name] = true
+		fields[s.field] = true
+	}
+	assert.Equal(t, "ATHENZ_ISTIO_AUTH_LOG_LEVEL", setting{name: "log-level"}.envName(), "env name should be equal")
+}
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		expectedMode v1alpha1.RbacConfig_Mode
+		expectedErr  bool
+	}{
+		{
+			name:         "should parse ON_WITH_INCLUSION mode",
+			input:        "ON_WITH_INCLUSION",
+			expectedMode: v1alpha1.RbacConfig_ON_WITH_INCLUSION,
+		},
+		{
+			name:         "should parse ON_WITH_EXCLUSION mode",
+			input:        "ON_WITH_EXCLUSION",
+			expectedMode: v1alpha1.RbacConfig_ON_WITH_EXCLUSION,
+		},
+		{
+			name:         "should parse ON mode",
+			input:        "ON",
+			expectedMode: v1alpha1.RbacConfig_ON,
+		},
+		{
+			name:        "should return error for OFF mode",
+			input:       "OFF",
+			expectedErr: true,
+		},
+		{
+			name:        "should return error for unknown mode",
+			input:       "on_with_inclusion",
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mode, err := ParseMode(tt.input)
+			if tt.expectedErr {
+				assert.NotNil(t, err, "error should not be nil")
+				return
+			}
+			assert.Nil(t, err, "error should be nil")
+			assert.Equal(t, tt.expectedMode, mode, "mode should be equal to expected")
+		})
+	}
+}
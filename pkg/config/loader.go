@@ -0,0 +1,234 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
+)
+
+const configFlag = "config"
+
+var logger = log.WithComponent("config")
+
+// Loader builds the config from, in increasing order of precedence, the
+// defaults, the config file, the environment variables and the flags set on
+// the command line
+type Loader struct {
+	flagSet   *flag.FlagSet
+	path      *string
+	lookupEnv func(string) (string, bool)
+}
+
+// NewLoader registers a flag for each setting and for the config file on the
+// flag set, which must be parsed before the config is loaded
+func NewLoader(flagSet *flag.FlagSet) *Loader {
+	defaults := Default()
+	for _, s := range settings {
+		flagSet.Var(s.value(defaults), s.name, s.usage)
+	}
+	path := flagSet.String(configFlag, "", fmt.Sprintf("(optional) path of the YAML config file, also read from %sCONFIG", EnvPrefix))
+	return &Loader{
+		flagSet:   flagSet,
+		path:      path,
+		lookupEnv: os.LookupEnv,
+	}
+}
+
+// Path returns the path of the config file, empty if there is none
+func (l *Loader) Path() string {
+	if *l.path != "" {
+		return *l.path
+	}
+	path, _ := l.lookupEnv(EnvPrefix + "CONFIG")
+	return path
+}
+
+// Load builds and validates the config, all the errors found in the config
+// file, the environment variables and the settings are returned at once
+func (l *Loader) Load() (*Config, error) {
+	c := Default()
+	errs := Errors{}
+
+	if path := l.Path(); path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		errs = append(errs, c.decode(data)...)
+	}
+
+	for _, s := range settings {
+		value, exists := l.lookupEnv(s.envName())
+		if !exists {
+			continue
+		}
+		err := s.value(c).Set(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid value %q: %s", s.envName(), value, err.Error()))
+		}
+	}
+
+	l.flagSet.Visit(func(f *flag.Flag) {
+		s, exists := lookupSetting(f.Name)
+		if !exists {
+			return
+		}
+		// the flag already parsed the value into the defaults
+		s.value(c).Set(f.Value.String())
+	})
+
+	if err := c.Validate(); err != nil {
+		errs = append(errs, err.(Errors)...)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return c, nil
+}
+
+// decode sets the fields of the YAML config file on the config and returns
+// the unknown fields and the invalid values of the file
+func (c *Config) decode(data []byte) Errors {
+	jsonData, err := yaml.ToJSON(data)
+	if err != nil {
+		return Errors{fmt.Errorf("error parsing the config file: %s", err.Error())}
+	}
+
+	fields := make(map[string]interface{})
+	err = json.Unmarshal(jsonData, &fields)
+	if err != nil {
+		return Errors{fmt.Errorf("the config file must be a YAML object: %s", err.Error())}
+	}
+
+	errs := Errors{}
+	if _, exists := fields["version"]; !exists {
+		errs = append(errs, fmt.Errorf("version: must be set to %s", Version))
+	}
+	for _, field := range unknownFields(fields, "") {
+		errs = append(errs, fmt.Errorf("%s: unknown field", field))
+	}
+
+	// each value is decoded on its own to report all the invalid ones
+	for _, s := range settings {
+		value, exists := lookupField(fields, s.field)
+		if !exists {
+			continue
+		}
+		raw, _ := json.Marshal(value)
+		err := json.Unmarshal(raw, s.value(c))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid value %s: %s", s.field, raw, err.Error()))
+		}
+	}
+	if version, ok := fields["version"].(string); ok {
+		c.Version = version
+	} else if _, exists := fields["version"]; exists {
+		errs = append(errs, fmt.Errorf("version: must be a string"))
+	}
+	return errs
+}
+
+// lookupField returns the value of a dotted field of the decoded config file
+func lookupField(fields map[string]interface{}, field string) (interface{}, bool) {
+	parts := strings.Split(field, ".")
+	for _, part := range parts[:len(parts)-1] {
+		nested, ok := fields[part].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		fields = nested
+	}
+	value, exists := fields[parts[len(parts)-1]]
+	return value, exists
+}
+
+// unknownFields returns the sorted dotted fields of the decoded config file
+// which are not a setting
+func unknownFields(fields map[string]interface{}, prefix string) []string {
+	unknown := make([]string, 0)
+	for key, value := range fields {
+		field := prefix + key
+		if field == "version" {
+			continue
+		}
+		if _, known := lookupSettingField(field); known {
+			continue
+		}
+		nested, ok := value.(map[string]interface{})
+		if ok && isGroup(field) {
+			unknown = append(unknown, unknownFields(nested, field+".")...)
+			continue
+		}
+		unknown = append(unknown, field)
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// lookupSettingField returns the setting of a dotted field
+func lookupSettingField(field string) (setting, bool) {
+	for _, s := range settings {
+		if s.field == field {
+			return s, true
+		}
+	}
+	return setting{}, false
+}
+
+// isGroup returns true if the dotted field holds settings
+func isGroup(field string) bool {
+	for _, s := range settings {
+		if strings.HasPrefix(s.field, field+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch reloads the config at the reload interval of the current config and
+// calls onChange with the changed settings. A config which fails to load is
+// logged and ignored, the previous config stays in effect.
+func (l *Loader) Watch(current *Config, stopCh <-chan struct{}, onChange func(c *Config, changes []Change)) {
+	for {
+		select {
+		case <-time.After(current.ReloadInterval.Duration):
+			c, err := l.Load()
+			if err != nil {
+				logger.WithError(err).Errorf("Error reloading the config file %s, keeping the current config", l.Path())
+				metrics.ConfigReloads.WithLabelValues(metrics.ResultFailure).Inc()
+				continue
+			}
+			metrics.ConfigReloads.WithLabelValues(metrics.ResultSuccess).Inc()
+
+			changes := Diff(current, c)
+			if len(changes) == 0 {
+				continue
+			}
+			for _, change := range changes {
+				if change.Reloadable {
+					logger.Infof("Setting %s changed from %s to %s", change.Setting, change.Old, change.New)
+					continue
+				}
+				logger.Warningf("Setting %s changed from %s to %s, it requires a restart to take effect", change.Setting, change.Old, change.New)
+			}
+			onChange(c, changes)
+			current = c
+		case <-stopCh:
+			logger.Infof("Stopping config reload...")
+			return
+		}
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestLoader returns a loader of the config file with the environment
+// variables and parsed command line arguments
+func newTestLoader(t *testing.T, file string, env map[string]string, args ...string) *Loader {
+	flagSet := flag.NewFlagSet("test", flag.ContinueOnError)
+	l := NewLoader(flagSet)
+	l.lookupEnv = func(name string) (string, bool) {
+		value, exists := env[name]
+		return value, exists
+	}
+	if file != "" {
+		dir, err := ioutil.TempDir("", "config")
+		assert.Nil(t, err, "error should be nil")
+		path := filepath.Join(dir, "config.yaml")
+		assert.Nil(t, ioutil.WriteFile(path, []byte(file), 0644), "error should be nil")
+		args = append(args, "-config", path)
+	}
+	assert.Nil(t, flagSet.Parse(args), "error should be nil")
+	return l
+}
+
+func TestLoad(t *testing.T) {
+	file := `
+version: v1
+dnsSuffix: svc.example.com
+athenzDomains:
+  resyncInterval: 30m
+  workers: 4
+log:
+  level: warning
+`
+	env := map[string]string{
+		"ATHENZ_ISTIO_AUTH_LOG_LEVEL": "debug",
+		"ATHENZ_ISTIO_AUTH_WORKERS":   "8",
+	}
+	l := newTestLoader(t, file, env, "-workers", "2", "-ad-resync-skip-unchanged")
+	defer os.RemoveAll(filepath.Dir(l.Path()))
+
+	c, err := l.Load()
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, "svc.example.com", c.DNSSuffix, "file should override the default")
+	assert.Equal(t, 30*time.Minute, c.AthenzDomains.ResyncInterval.Duration, "file should override the default")
+	assert.Equal(t, "debug", c.Log.Level, "env should override the file")
+	assert.Equal(t, 2, c.AthenzDomains.Workers, "flag should override the env")
+	assert.True(t, c.AthenzDomains.SkipUnchanged, "bool flag should be set")
+	assert.Equal(t, time.Hour, c.ClusterRbacConfig.ResyncInterval.Duration, "default should be kept")
+}
+
+func TestLoadFilter(t *testing.T) {
+	file := `
+version: v1
+filter:
+  namespaceExclude:
+  - kube-system
+  - istio-system
+  domainIncludeRegex: ^team\.
+`
+	l := newTestLoader(t, file, nil, "-namespace-include", "team-a, team-b")
+	defer os.RemoveAll(filepath.Dir(l.Path()))
+
+	c, err := l.Load()
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, []string{"team-a", "team-b"}, c.Filter.NamespaceInclude, "flag should be split on commas")
+	assert.Equal(t, []string{"kube-system", "istio-system"}, c.Filter.NamespaceExclude, "file should set the list")
+	assert.Equal(t, `^team\.`, c.Filter.DomainIncludeRegex, "file should set the regex")
+
+	f, err := c.NamespaceFilter()
+	assert.Nil(t, err, "error should be nil")
+	assert.True(t, f.NamespaceName("team-a"), "included namespace should match")
+	assert.False(t, f.NamespaceName("team-c"), "namespace which is not included should not match")
+}
+
+func TestLoadErrors(t *testing.T) {
+	file := `
+version: v1
+dnsSuffix: 5
+athenzDomains:
+  resyncInterval: 5
+  worker: 4
+logs:
+  level: debug
+`
+	env := map[string]string{
+		"ATHENZ_ISTIO_AUTH_KUBE_BURST": "many",
+	}
+	l := newTestLoader(t, file, env, "-processor-workers", "0")
+	defer os.RemoveAll(filepath.Dir(l.Path()))
+
+	c, err := l.Load()
+	assert.Nil(t, c, "config should be nil")
+	errs, ok := err.(Errors)
+	assert.True(t, ok, "error should hold all the errors")
+	messages := make([]string, 0)
+	for _, e := range errs {
+		messages = append(messages, e.Error())
+	}
+	assert.Equal(t, []string{
+		"athenzDomains.worker: unknown field",
+		"logs: unknown field",
+		"dnsSuffix: invalid value 5: json: cannot unmarshal number into Go value of type config.stringValue",
+		"athenzDomains.resyncInterval: invalid value 5: duration must be a string such as 30s or 1h, got 5",
+		`ATHENZ_ISTIO_AUTH_KUBE_BURST: invalid value "many": strconv.Atoi: parsing "many": invalid syntax`,
+		"processor.workers: must be at least 1",
+	}, messages, "errors should be equal")
+}
+
+func TestLoadWithoutVersion(t *testing.T) {
+	l := newTestLoader(t, "dnsSuffix: svc.example.com\n", nil)
+	defer os.RemoveAll(filepath.Dir(l.Path()))
+
+	_, err := l.Load()
+	assert.Equal(t, "1 config error(s): version: must be set to v1", err.Error(), "error should be equal")
+}
+
+func TestLoadWithoutFile(t *testing.T) {
+	l := newTestLoader(t, "", nil)
+	c, err := l.Load()
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, Default(), c, "config should be the default")
+}
+
+func TestLoadProtectedNamespaces(t *testing.T) {
+	l := newTestLoader(t, "", nil)
+	c, err := l.Load()
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, []string{"istio-system", "kube-system", "default"}, c.ProtectedNamespaces, "system namespaces should be protected by default")
+
+	l = newTestLoader(t, "", map[string]string{"ATHENZ_ISTIO_AUTH_PROTECTED_NAMESPACES": ""})
+	c, err = l.Load()
+	assert.Nil(t, err, "error should be nil")
+	assert.Empty(t, c.ProtectedNamespaces, "empty env should protect no namespace")
+}
+
+func TestWatch(t *testing.T) {
+	l := newTestLoader(t, "version: v1\nreloadInterval: 10ms\n", nil)
+	defer os.RemoveAll(filepath.Dir(l.Path()))
+
+	current, err := l.Load()
+	assert.Nil(t, err, "error should be nil")
+
+	changesCh := make(chan []Change, 1)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go l.Watch(current, stopCh, func(c *Config, changes []Change) {
+		changesCh <- changes
+	})
+
+	// an invalid config is ignored
+	assert.Nil(t, ioutil.WriteFile(l.Path(), []byte("version: v1\nreloadInterval: 10ms\nlog:\n  level: loud\n"), 0644), "error should be nil")
+	time.Sleep(50 * time.Millisecond)
+	assert.Nil(t, ioutil.WriteFile(l.Path(), []byte("version: v1\nreloadInterval: 10ms\nlog:\n  level: debug\nkube:\n  burst: 50\n"), 0644), "error should be nil")
+
+	select {
+	case changes := <-changesCh:
+		assert.Equal(t, []Change{
+			{Setting: "kube.burst", Old: "10", New: "50", Reloadable: false},
+			{Setting: "log.level", Old: "info", New: "debug", Reloadable: true},
+		}, changes, "changes should be equal")
+	case <-time.After(time.Second):
+		t.Error("config change should be reported")
+	}
+}
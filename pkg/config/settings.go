@@ -0,0 +1,381 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package config
+
+import (
+	"flag"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix is the prefix of the environment variables overriding the
+// settings, e.g. ATHENZ_ISTIO_AUTH_LOG_LEVEL overrides log-level
+const EnvPrefix = "ATHENZ_ISTIO_AUTH_"
+
+type stringValue string
+
+func (v *stringValue) Set(s string) error {
+	*v = stringValue(s)
+	return nil
+}
+
+func (v *stringValue) String() string { return string(*v) }
+
+// stringSliceValue is a comma separated list
+type stringSliceValue []string
+
+func (v *stringSliceValue) Set(s string) error {
+	*v = nil
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			*v = append(*v, item)
+		}
+	}
+	return nil
+}
+
+func (v *stringSliceValue) String() string { return strings.Join(*v, ",") }
+
+type intValue int
+
+func (v *intValue) Set(s string) error {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*v = intValue(i)
+	return nil
+}
+
+func (v *intValue) String() string { return strconv.Itoa(int(*v)) }
+
+type floatValue float64
+
+func (v *floatValue) Set(s string) error {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	*v = floatValue(f)
+	return nil
+}
+
+func (v *floatValue) String() string { return strconv.FormatFloat(float64(*v), 'g', -1, 64) }
+
+type boolValue bool
+
+func (v *boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*v = boolValue(b)
+	return nil
+}
+
+func (v *boolValue) String() string { return strconv.FormatBool(bool(*v)) }
+
+func (v *boolValue) IsBoolFlag() bool { return true }
+
+// setting is a config field which can be set by a flag and an environment
+// variable. Reloadable settings take effect when the config file is reloaded,
+// the others require a restart.
+type setting struct {
+	name       string
+	field      string
+	usage      string
+	reloadable bool
+	value      func(c *Config) flag.Value
+}
+
+// envName returns the environment variable of the setting
+func (s setting) envName() string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(s.name, "-", "_", -1))
+}
+
+var settings = []setting{
+	{
+		name:  "dns-suffix",
+		field: "dnsSuffix",
+		usage: "dns suffix used for service role target services",
+		value: func(c *Config) flag.Value { return (*stringValue)(&c.DNSSuffix) },
+	},
+	{
+		name:  "protected-namespaces",
+		field: "protectedNamespaces",
+		usage: "comma separated namespaces the controller never writes Istio RBAC resources to nor onboards",
+		value: func(c *Config) flag.Value { return (*stringSliceValue)(&c.ProtectedNamespaces) },
+	},
+	{
+		name:       "config-reload-interval",
+		field:      "reloadInterval",
+		usage:      "interval at which the config file is checked for changes",
+		reloadable: true,
+		value:      func(c *Config) flag.Value { return &c.ReloadInterval },
+	},
+	{
+		name:  "shutdown-timeout",
+		field: "shutdownTimeout",
+		usage: "deadline of the drain of the in-flight syncs and writes on shutdown, the process exits with status 1 if it is exceeded",
+		value: func(c *Config) flag.Value { return &c.ShutdownTimeout },
+	},
+	{
+		name:  "audit-log-file",
+		field: "auditLogFile",
+		usage: "file the applied access changes of the athenz domains are appended to as json lines, rotated as the log file, disabled if empty",
+		value: func(c *Config) flag.Value { return (*stringValue)(&c.AuditLogFile) },
+	},
+	{
+		name:       "ad-resync-interval",
+		field:      "athenzDomains.resyncInterval",
+		usage:      "athenz domain resync interval",
+		reloadable: true,
+		value:      func(c *Config) flag.Value { return &c.AthenzDomains.ResyncInterval },
+	},
+	{
+		name:       "ad-resync-skip-unchanged",
+		field:      "athenzDomains.skipUnchanged",
+		usage:      "skip the athenz domains which did not change since their last successful sync on resync",
+		reloadable: true,
+		value:      func(c *Config) flag.Value { return (*boolValue)(&c.AthenzDomains.SkipUnchanged) },
+	},
+	{
+		name:  "workers",
+		field: "athenzDomains.workers",
+		usage: "number of workers syncing athenz domains",
+		value: func(c *Config) flag.Value { return (*intValue)(&c.AthenzDomains.Workers) },
+	},
+	{
+		name:       "crc-resync-interval",
+		field:      "clusterRbacConfig.resyncInterval",
+		usage:      "cluster rbac config resync interval",
+		reloadable: true,
+		value:      func(c *Config) flag.Value { return &c.ClusterRbacConfig.ResyncInterval },
+	},
+	{
+		name:       "crc-debounce",
+		field:      "clusterRbacConfig.debounce",
+		usage:      "window during which service and namespace events are batched into a single cluster rbac config sync",
+		reloadable: true,
+		value:      func(c *Config) flag.Value { return &c.ClusterRbacConfig.Debounce },
+	},
+	{
+		name:  "crc-mode",
+		field: "clusterRbacConfig.mode",
+		usage: "cluster rbac config mode, one of ON_WITH_INCLUSION, ON_WITH_EXCLUSION or ON",
+		value: func(c *Config) flag.Value { return (*stringValue)(&c.ClusterRbacConfig.Mode) },
+	},
+	{
+		name:  "safe-onboarding",
+		field: "clusterRbacConfig.safeOnboarding",
+		usage: "only onboard services once their athenz domain has a policy for them",
+		value: func(c *Config) flag.Value { return (*boolValue)(&c.ClusterRbacConfig.SafeOnboarding) },
+	},
+	{
+		name:  "processor-workers",
+		field: "processor.workers",
+		usage: "number of workers writing istio custom resources",
+		value: func(c *Config) flag.Value { return (*intValue)(&c.Processor.Workers) },
+	},
+	{
+		name:  "kubeconfig",
+		field: "kube.kubeconfig",
+		usage: "(optional) absolute path to the kubeconfig file",
+		value: func(c *Config) flag.Value { return (*stringValue)(&c.Kube.Kubeconfig) },
+	},
+	{
+		name:  "kube-qps",
+		field: "kube.qps",
+		usage: "queries per second of the kubernetes and athenz domain clients and of the Istio writes",
+		value: func(c *Config) flag.Value { return (*floatValue)(&c.Kube.QPS) },
+	},
+	{
+		name:  "kube-burst",
+		field: "kube.burst",
+		usage: "burst of the kubernetes and athenz domain clients and of the Istio writes",
+		value: func(c *Config) flag.Value { return (*intValue)(&c.Kube.Burst) },
+	},
+	{
+		name:  "retry-base-delay",
+		field: "retry.baseDelay",
+		usage: "initial backoff of a failed key",
+		value: func(c *Config) flag.Value { return &c.Retry.BaseDelay },
+	},
+	{
+		name:  "retry-max-delay",
+		field: "retry.maxDelay",
+		usage: "maximum backoff of a failed key",
+		value: func(c *Config) flag.Value { return &c.Retry.MaxDelay },
+	},
+	{
+		name:  "retry-max-retries",
+		field: "retry.maxRetries",
+		usage: "number of retries of a failed key before it is reported as a dead letter",
+		value: func(c *Config) flag.Value { return (*intValue)(&c.Retry.MaxRetries) },
+	},
+	{
+		name:  "namespace-include",
+		field: "filter.namespaceInclude",
+		usage: "comma separated namespaces the controller is scoped to, all of them if empty",
+		value: func(c *Config) flag.Value { return (*stringSliceValue)(&c.Filter.NamespaceInclude) },
+	},
+	{
+		name:  "namespace-exclude",
+		field: "filter.namespaceExclude",
+		usage: "comma separated namespaces ignored by the controller, e.g. kube-system",
+		value: func(c *Config) flag.Value { return (*stringSliceValue)(&c.Filter.NamespaceExclude) },
+	},
+	{
+		name:  "namespace-selector",
+		field: "filter.namespaceSelector",
+		usage: "label selector of the namespaces the controller is scoped to",
+		value: func(c *Config) flag.Value { return (*stringValue)(&c.Filter.NamespaceSelector) },
+	},
+	{
+		name:  "domain-selector",
+		field: "filter.domainSelector",
+		usage: "label selector of the athenz domains the controller is scoped to",
+		value: func(c *Config) flag.Value { return (*stringValue)(&c.Filter.DomainSelector) },
+	},
+	{
+		name:  "domain-include-regex",
+		field: "filter.domainIncludeRegex",
+		usage: "regex the athenz domain names must match, all of them match if empty",
+		value: func(c *Config) flag.Value { return (*stringValue)(&c.Filter.DomainIncludeRegex) },
+	},
+	{
+		name:  "domain-exclude-regex",
+		field: "filter.domainExcludeRegex",
+		usage: "regex of the athenz domain names ignored by the controller",
+		value: func(c *Config) flag.Value { return (*stringValue)(&c.Filter.DomainExcludeRegex) },
+	},
+	{
+		name:  "max-deletions",
+		field: "limits.maxDeletions",
+		usage: "maximum number of generated Istio RBAC resources deleted by a single sync of a domain before the change is held for approval, unlimited if 0",
+		value: func(c *Config) flag.Value { return (*intValue)(&c.Limits.MaxDeletions) },
+	},
+	{
+		name:  "max-deletion-percent",
+		field: "limits.maxDeletionPercent",
+		usage: "maximum percentage of the generated Istio RBAC resources of a domain deleted by a single sync before the change is held for approval, unlimited if 0",
+		value: func(c *Config) flag.Value { return (*intValue)(&c.Limits.MaxDeletionPercent) },
+	},
+	{
+		name:  "crc-max-service-removals",
+		field: "limits.maxServiceRemovals",
+		usage: "maximum number of namespaces and services removed from the cluster rbac config inclusion list, or exclusion list in ON_WITH_EXCLUSION mode, by a single sync before the change is held for approval, unlimited if 0",
+		value: func(c *Config) flag.Value { return (*intValue)(&c.Limits.MaxServiceRemovals) },
+	},
+	{
+		name:  "preflight-base-delay",
+		field: "preflight.baseDelay",
+		usage: "initial backoff of the startup checks of the api server, custom resource definitions and permissions",
+		value: func(c *Config) flag.Value { return &c.Preflight.BaseDelay },
+	},
+	{
+		name:  "preflight-max-delay",
+		field: "preflight.maxDelay",
+		usage: "maximum backoff of the startup checks",
+		value: func(c *Config) flag.Value { return &c.Preflight.MaxDelay },
+	},
+	{
+		name:  "preflight-max-retries",
+		field: "preflight.maxRetries",
+		usage: "number of retries of the failed startup checks before the controller exits",
+		value: func(c *Config) flag.Value { return (*intValue)(&c.Preflight.MaxRetries) },
+	},
+	{
+		name:  "log-file",
+		field: "log.file",
+		usage: "log file location, logs are only written to stdout if empty",
+		value: func(c *Config) flag.Value { return (*stringValue)(&c.Log.File) },
+	},
+	{
+		name:       "log-level",
+		field:      "log.level",
+		usage:      "logging level",
+		reloadable: true,
+		value:      func(c *Config) flag.Value { return (*stringValue)(&c.Log.Level) },
+	},
+	{
+		name:  "log-format",
+		field: "log.format",
+		usage: "format of the log lines, one of text or json",
+		value: func(c *Config) flag.Value { return (*stringValue)(&c.Log.Format) },
+	},
+	{
+		name:  "log-max-size",
+		field: "log.maxSize",
+		usage: "size in megabytes of the log file before it is rotated",
+		value: func(c *Config) flag.Value { return (*intValue)(&c.Log.MaxSize) },
+	},
+	{
+		name:  "log-max-backups",
+		field: "log.maxBackups",
+		usage: "number of rotated log files to keep, all of them are kept if 0",
+		value: func(c *Config) flag.Value { return (*intValue)(&c.Log.MaxBackups) },
+	},
+	{
+		name:  "log-max-age",
+		field: "log.maxAge",
+		usage: "number of days to keep the rotated log files, they are kept regardless of their age if 0",
+		value: func(c *Config) flag.Value { return (*intValue)(&c.Log.MaxAge) },
+	},
+	{
+		name:  "http-addr",
+		field: "http.addr",
+		usage: "address of the http server serving metrics",
+		value: func(c *Config) flag.Value { return (*stringValue)(&c.HTTP.Addr) },
+	},
+	{
+		name:  "debug-endpoints",
+		field: "http.debugEndpoints",
+		usage: "serve the state of the athenz domains on /debug/domains, the dead letters on /debug/deadletters and the log level on /debug/loglevel",
+		value: func(c *Config) flag.Value { return (*boolValue)(&c.HTTP.DebugEndpoints) },
+	},
+	{
+		name:  "debug-token-file",
+		field: "http.debugTokenFile",
+		usage: "file holding the bearer token required by the debug endpoints",
+		value: func(c *Config) flag.Value { return (*stringValue)(&c.HTTP.DebugTokenFile) },
+	},
+}
+
+// lookupSetting returns the setting of a flag name
+func lookupSetting(name string) (setting, bool) {
+	for _, s := range settings {
+		if s.name == name {
+			return s, true
+		}
+	}
+	return setting{}, false
+}
+
+// Change is a setting which differs between two configs
+type Change struct {
+	Setting    string
+	Old        string
+	New        string
+	Reloadable bool
+}
+
+// Diff returns the settings which differ between two configs
+func Diff(old, new *Config) []Change {
+	changes := make([]Change, 0)
+	for _, s := range settings {
+		oldValue, newValue := s.value(old).String(), s.value(new).String()
+		if oldValue == newValue {
+			continue
+		}
+		changes = append(changes, Change{
+			Setting:    s.field,
+			Old:        oldValue,
+			New:        newValue,
+			Reloadable: s.reloadable,
+		})
+	}
+	return changes
+}
@@ -0,0 +1,192 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/yahoo/athenz/clients/go/zms"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	adv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/apis/athenz/v1"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/processor"
+)
+
+const (
+	accessChangedReason   = "AccessChanged"
+	accessPreviewedReason = "AccessPreviewed"
+)
+
+// ConfigChange is a change of the desired Istio RBAC resources of a domain
+type ConfigChange struct {
+	Operation string `json:"operation"`
+	Key       string `json:"key"`
+}
+
+// AccessChange is the audit record of the Istio RBAC changes applied for a
+// new version of an AthenzDomain. The diff is relative to the last version
+// applied since the controller started, or to the old version of the first
+// update received since then, it is nil if there is neither. The record of a
+// preview holds the previewed policy version, its diff is relative to the
+// active version and its changes are the ones a dry-run would apply.
+type AccessChange struct {
+	Time               time.Time         `json:"time"`
+	Preview            string            `json:"preview,omitempty"`
+	Domain             zms.DomainName    `json:"domain"`
+	Namespace          string            `json:"namespace"`
+	OldResourceVersion string            `json:"oldResourceVersion,omitempty"`
+	ResourceVersion    string            `json:"resourceVersion"`
+	Diff               *athenz.ModelDiff `json:"diff,omitempty"`
+	Changes            []ConfigChange    `json:"changes"`
+}
+
+// appliedState is the version and model of a domain whose Istio RBAC
+// resources were last applied
+type appliedState struct {
+	resourceVersion string
+	model           athenz.Model
+}
+
+// newDomainReference returns the object reference used to record events on an
+// AthenzDomain
+func newDomainReference(athenzDomain *adv1.AthenzDomain) *v1.ObjectReference {
+	return &v1.ObjectReference{
+		Kind:            "AthenzDomain",
+		APIVersion:      adv1.SchemeGroupVersion.String(),
+		Namespace:       athenzDomain.Namespace,
+		Name:            athenzDomain.Name,
+		UID:             athenzDomain.UID,
+		ResourceVersion: athenzDomain.ResourceVersion,
+	}
+}
+
+// getApplied returns the last applied state of a domain
+func (c *Controller) getApplied(key string) (appliedState, bool) {
+	c.appliedLock.Lock()
+	defer c.appliedLock.Unlock()
+	state, exists := c.applied[key]
+	return state, exists
+}
+
+// recordApplied records the version and model of a domain whose Istio RBAC
+// resources are in sync
+func (c *Controller) recordApplied(key, resourceVersion string, domainRBAC athenz.Model) {
+	c.appliedLock.Lock()
+	defer c.appliedLock.Unlock()
+	c.applied[key] = appliedState{
+		resourceVersion: resourceVersion,
+		model:           domainRBAC,
+	}
+}
+
+// recordPreviousVersion records the old version of an updated AthenzDomain as
+// the last applied state of the domain if none was recorded since the
+// controller started, so that the first change after a restart is diffed
+// against the version it replaces. Resyncs do not change the version.
+func (c *Controller) recordPreviousVersion(oldObj, obj interface{}) {
+	oldDomain, ok := oldObj.(*adv1.AthenzDomain)
+	if !ok {
+		return
+	}
+	athenzDomain, ok := obj.(*adv1.AthenzDomain)
+	if !ok || athenzDomain.ResourceVersion == oldDomain.ResourceVersion {
+		return
+	}
+	key, err := cache.MetaNamespaceKeyFunc(athenzDomain)
+	if err != nil {
+		return
+	}
+	if _, exists := c.getApplied(key); exists {
+		return
+	}
+
+	oldModel := athenz.ConvertVersionedPoliciesIntoRbacModel(oldDomain.Spec.SignedDomain.Domain, oldDomain.Spec.PolicyVersions, "")
+	c.appliedLock.Lock()
+	defer c.appliedLock.Unlock()
+	if _, exists := c.applied[key]; !exists {
+		c.applied[key] = appliedState{
+			resourceVersion: oldDomain.ResourceVersion,
+			model:           oldModel,
+		}
+	}
+}
+
+// forgetApplied removes the last applied state of a deleted domain
+func (c *Controller) forgetApplied(key string) {
+	c.appliedLock.Lock()
+	defer c.appliedLock.Unlock()
+	delete(c.applied, key)
+}
+
+// newAccessChange returns the audit record of the changes applied for a
+// version of a domain
+func newAccessChange(athenzDomain *adv1.AthenzDomain, domainRBAC athenz.Model, last appliedState, lastExists bool, applied []*processor.Item) AccessChange {
+	change := AccessChange{
+		Time:            time.Now(),
+		Domain:          domainRBAC.Name,
+		Namespace:       domainRBAC.Namespace,
+		ResourceVersion: athenzDomain.ResourceVersion,
+		Changes:         make([]ConfigChange, 0, len(applied)),
+	}
+	if lastExists {
+		diff := athenz.DiffModels(last.model, domainRBAC)
+		change.OldResourceVersion = last.resourceVersion
+		change.Diff = &diff
+	}
+	for _, item := range applied {
+		change.Changes = append(change.Changes, ConfigChange{
+			Operation: item.Operation.String(),
+			Key:       item.Resource.Key(),
+		})
+	}
+	return change
+}
+
+// auditBatch returns the callback of the batch of a domain sync, which logs,
+// records an event and writes an audit record for the changes the processor
+// applied. The domain is recorded as applied once the whole batch is.
+func (c *Controller) auditBatch(key string, athenzDomain *adv1.AthenzDomain, domainRBAC athenz.Model) func([]*processor.Item, error) {
+	last, lastExists := c.getApplied(key)
+	return func(applied []*processor.Item, err error) {
+		if err == nil {
+			c.recordApplied(key, athenzDomain.ResourceVersion, domainRBAC)
+		}
+
+		change := newAccessChange(athenzDomain, domainRBAC, last, lastExists, applied)
+		message := fmt.Sprintf("%d Istio RBAC changes applied", len(change.Changes))
+		if change.Diff != nil {
+			message = fmt.Sprintf("access changed: %s, %s", change.Diff, message)
+		}
+		c.recordAccessChange(athenzDomain, change, accessChangedReason, message)
+	}
+}
+
+// recordAccessChange logs an access change, records an event with the reason
+// and message on the AthenzDomain and writes the audit record
+func (c *Controller) recordAccessChange(athenzDomain *adv1.AthenzDomain, change AccessChange, reason, message string) {
+	changeLogger := logger.WithNamespace(change.Namespace).WithDomain(string(change.Domain))
+	record, err := json.Marshal(change)
+	if err != nil {
+		changeLogger.WithError(err).Errorf("Error encoding the access change")
+	}
+	if change.Preview != "" {
+		changeLogger.Infof("Access previewed: %s", record)
+	} else {
+		changeLogger.Infof("Access changed: %s", record)
+	}
+
+	if c.recorder != nil {
+		c.recorder.Event(newDomainReference(athenzDomain), v1.EventTypeNormal, reason, message)
+	}
+
+	err = c.auditLog.Record(change)
+	if err != nil {
+		changeLogger.WithError(err).Errorf("Error writing the audit record")
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/yahoo/athenz/clients/go/zms"
+
+	"istio.io/api/rbac/v1alpha1"
+
+	"k8s.io/client-go/tools/record"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/audit"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/onboarding"
+	rbacv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/v1"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/retry"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditBatch(t *testing.T) {
+	buf := &bytes.Buffer{}
+	recorder := record.NewFakeRecorder(10)
+	c := &Controller{
+		rbacProvider: rbacv1.NewProvider(),
+		recorder:     recorder,
+		auditLog:     audit.NewWriterLogger(buf),
+		applied:      make(map[string]appliedState),
+	}
+	key := "test-namespace/test.namespace"
+
+	oldDomain := ad.DeepCopy()
+	oldDomain.ResourceVersion = "1"
+	oldDomain.Spec.SignedDomain.Domain = newCacheDomain()
+	oldModel := athenz.ConvertAthenzPoliciesIntoRbacModel(oldDomain.Spec.SignedDomain.Domain)
+
+	// the changes applied without a previously applied version have no diff
+	items := computeChangeList(nil, c.rbacProvider.ConvertAthenzModelIntoIstioRbac(oldModel), nil)
+	c.auditBatch(key, oldDomain, oldModel)(items, nil)
+	assert.Contains(t, <-recorder.Events, "2 Istio RBAC changes applied", "event should count the applied changes")
+	var change AccessChange
+	err := json.Unmarshal(buf.Bytes(), &change)
+	assert.Nil(t, err, "audit record should be valid json")
+	assert.Nil(t, change.Diff, "diff should be nil without a previously applied version")
+	assert.Equal(t, 2, len(change.Changes), "changes should be equal")
+	buf.Reset()
+
+	newDomain := oldDomain.DeepCopy()
+	newDomain.ResourceVersion = "3"
+	role := newDomain.Spec.SignedDomain.Domain.Roles[0]
+	role.RoleMembers = append(role.RoleMembers, &zms.RoleMember{MemberName: "user.other"})
+	newModel := athenz.ConvertAthenzPoliciesIntoRbacModel(newDomain.Spec.SignedDomain.Domain)
+	items = computeChangeList(c.rbacProvider.ConvertAthenzModelIntoIstioRbac(oldModel), c.rbacProvider.ConvertAthenzModelIntoIstioRbac(newModel), nil)
+
+	// a partially applied batch is audited without being recorded as applied
+	c.auditBatch(key, newDomain, newModel)(items[:0], errors.New("update failed"))
+	assert.Contains(t, <-recorder.Events, "members +1 -0, assertions +0 -0, 0 Istio RBAC changes applied", "event should summarize the change")
+	state, _ := c.getApplied(key)
+	assert.Equal(t, "1", state.resourceVersion, "applied version should not change on a failure")
+	buf.Reset()
+
+	c.auditBatch(key, newDomain, newModel)(items, nil)
+	assert.Contains(t, <-recorder.Events, "members +1 -0, assertions +0 -0, 1 Istio RBAC changes applied", "event should summarize the change")
+	state, _ = c.getApplied(key)
+	assert.Equal(t, "3", state.resourceVersion, "applied version should be equal")
+
+	change = AccessChange{}
+	err = json.Unmarshal(buf.Bytes(), &change)
+	assert.Nil(t, err, "audit record should be valid json")
+	assert.Equal(t, zms.DomainName("test.namespace"), change.Domain, "domain should be equal")
+	assert.Equal(t, "1", change.OldResourceVersion, "old resource version should be equal")
+	assert.Equal(t, "3", change.ResourceVersion, "resource version should be equal")
+	assert.NotNil(t, change.Diff, "diff should be set")
+	assert.Equal(t, []athenz.MemberChange{{Role: "test.namespace:role.client", Member: "user.other"}}, change.Diff.AddedMembers, "added members should be equal")
+	assert.Equal(t, []ConfigChange{{Operation: "update", Key: "service-role-binding/test-namespace/client"}}, change.Changes, "changes should be equal")
+}
+
+func TestRecordPreviousVersion(t *testing.T) {
+	buf := &bytes.Buffer{}
+	c := &Controller{
+		rbacProvider: rbacv1.NewProvider(),
+		auditLog:     audit.NewWriterLogger(buf),
+		applied:      make(map[string]appliedState),
+	}
+	key := "test-namespace/test.namespace"
+
+	oldDomain := ad.DeepCopy()
+	oldDomain.ResourceVersion = "1"
+	oldDomain.Spec.SignedDomain.Domain = newCacheDomain()
+	c.recordPreviousVersion(oldDomain, oldDomain.DeepCopy())
+	_, exists := c.getApplied(key)
+	assert.False(t, exists, "resync should not record the previous version")
+
+	newDomain := oldDomain.DeepCopy()
+	newDomain.ResourceVersion = "2"
+	role := newDomain.Spec.SignedDomain.Domain.Roles[0]
+	role.RoleMembers = append(role.RoleMembers, &zms.RoleMember{MemberName: "user.other"})
+	c.recordPreviousVersion(oldDomain, newDomain)
+	state, exists := c.getApplied(key)
+	assert.True(t, exists, "previous version should be recorded after a restart")
+	assert.Equal(t, "1", state.resourceVersion, "previous resource version should be equal")
+
+	// the first change after a restart is diffed against the previous version
+	newModel := athenz.ConvertAthenzPoliciesIntoRbacModel(newDomain.Spec.SignedDomain.Domain)
+	c.auditBatch(key, newDomain, newModel)(nil, nil)
+	var change AccessChange
+	err := json.Unmarshal(buf.Bytes(), &change)
+	assert.Nil(t, err, "audit record should be valid json")
+	assert.Equal(t, "1", change.OldResourceVersion, "old resource version should be equal")
+	assert.NotNil(t, change.Diff, "diff should be set")
+	assert.Equal(t, []athenz.MemberChange{{Role: "test.namespace:role.client", Member: "user.other"}}, change.Diff.AddedMembers, "added members should be equal")
+
+	// an applied version is not replaced by the old version of an update
+	newerDomain := newDomain.DeepCopy()
+	newerDomain.ResourceVersion = "3"
+	c.recordPreviousVersion(oldDomain, newerDomain)
+	state, _ = c.getApplied(key)
+	assert.Equal(t, "2", state.resourceVersion, "applied version should not change")
+}
+
+func TestSyncAuditsAppliedBatch(t *testing.T) {
+	key := "test-namespace/test.namespace"
+	buf := &bytes.Buffer{}
+	c := newDebugController(t)
+	c.crcController = onboarding.NewController(c.configStoreCache, c.namespaceIndexInformer, c.namespaceIndexInformer, c.processor, retry.NewDeadLetters(), nil, onboarding.Options{
+		DNSSuffix:      "svc.cluster.local",
+		Mode:           v1alpha1.RbacConfig_ON_WITH_INCLUSION,
+		ResyncInterval: time.Hour,
+		Debounce:       time.Second,
+		RetryPolicy:    retry.DefaultPolicy(),
+	})
+	c.auditLog = audit.NewWriterLogger(buf)
+	c.synced = make(map[string]syncState)
+
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		c.processor.Run(stopCh)
+		close(done)
+	}()
+	err := c.sync(key)
+	assert.Nil(t, err, "sync should return nil")
+	close(stopCh)
+	<-done
+
+	var change AccessChange
+	err = json.Unmarshal(buf.Bytes(), &change)
+	assert.Nil(t, err, "audit record should be valid json")
+	assert.Nil(t, change.Diff, "diff should be nil for the first applied version")
+	assert.Equal(t, []ConfigChange{
+		{Operation: "add", Key: "service-role/test-namespace/client"},
+		{Operation: "add", Key: "service-role-binding/test-namespace/client"},
+	}, change.Changes, "changes should be the applied ones in order")
+	_, exists := c.getApplied(key)
+	assert.True(t, exists, "domain should be recorded as applied")
+
+	// a converged sync writes no record
+	buf.Reset()
+	err = c.sync(key)
+	assert.Nil(t, err, "sync should return nil")
+	assert.Equal(t, 0, buf.Len(), "no record should be written without changes")
+}
@@ -0,0 +1,141 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package controller
+
+import (
+	"encoding/json"
+	"hash/fnv"
+
+	"github.com/yahoo/athenz/clients/go/zms"
+
+	"istio.io/istio/pilot/pkg/model"
+
+	adv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/apis/athenz/v1"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
+)
+
+// desiredState is the converted model and desired configs of a domain, for the
+// domain content and controller configuration they were computed from
+type desiredState struct {
+	hash       uint64
+	generation uint64
+	model      athenz.Model
+	configs    []model.Config
+}
+
+// domainHash returns a stable hash of the parts of the domain used by the
+// conversion: its name, modified timestamp, roles, policies and policy versions
+func domainHash(domain *zms.DomainData, versions adv1.PolicyVersions) (uint64, error) {
+	h := fnv.New64a()
+	if domain == nil {
+		return h.Sum64(), nil
+	}
+
+	h.Write([]byte(domain.Name))
+	h.Write([]byte(domain.Modified.String()))
+	for _, part := range []interface{}{domain.Roles, domain.Policies, versions} {
+		data, err := json.Marshal(part)
+		if err != nil {
+			return 0, err
+		}
+		h.Write(data)
+	}
+	return h.Sum64(), nil
+}
+
+// convertDomain converts a domain into its Athenz model and its desired Istio
+// RBAC configs, the inactive policy versions are skipped
+func (c *Controller) convertDomain(domain *zms.DomainData, versions adv1.PolicyVersions) (athenz.Model, []model.Config) {
+	domainRBAC := athenz.ConvertVersionedPoliciesIntoRbacModel(domain, versions, "")
+	return domainRBAC, c.rbacProvider.ConvertAthenzModelIntoIstioRbac(domainRBAC)
+}
+
+// cachedDesiredState returns a copy of the cached desired state of a domain if
+// it was computed for the hash and the current controller configuration,
+// along with the current generation of the configuration
+func (c *Controller) cachedDesiredState(key string, hash uint64) (athenz.Model, []model.Config, uint64, bool) {
+	c.desiredLock.Lock()
+	state, exists := c.desired[key]
+	generation := c.generation
+	c.desiredLock.Unlock()
+
+	if !exists || state.hash != hash || state.generation != generation {
+		return athenz.Model{}, nil, generation, false
+	}
+	configs := make([]model.Config, len(state.configs))
+	copy(configs, state.configs)
+	return state.model, configs, generation, true
+}
+
+// getDesiredState returns the Athenz model and the desired Istio RBAC configs
+// of a domain, the inactive policy versions are skipped. They are only
+// converted again if the domain content or the controller configuration
+// changed since the last conversion.
+func (c *Controller) getDesiredState(key string, domain *zms.DomainData, versions adv1.PolicyVersions) (athenz.Model, []model.Config) {
+	hash, err := domainHash(domain, versions)
+	if err != nil {
+		domainLogger(key).WithError(err).Warningf("Error hashing the athenz domain, converting it")
+	}
+
+	var generation uint64
+	if err == nil {
+		var cachedRBAC athenz.Model
+		var cachedCRs []model.Config
+		var hit bool
+		cachedRBAC, cachedCRs, generation, hit = c.cachedDesiredState(key, hash)
+		if hit {
+			metrics.DesiredStateCache.WithLabelValues(metrics.ResultHit).Inc()
+			return cachedRBAC, cachedCRs
+		}
+	}
+
+	metrics.DesiredStateCache.WithLabelValues(metrics.ResultMiss).Inc()
+	domainRBAC, desiredCRs := c.convertDomain(domain, versions)
+	if err != nil {
+		return domainRBAC, desiredCRs
+	}
+
+	configs := make([]model.Config, len(desiredCRs))
+	copy(configs, desiredCRs)
+	c.desiredLock.Lock()
+	c.desired[key] = desiredState{
+		hash:       hash,
+		generation: generation,
+		model:      domainRBAC,
+		configs:    configs,
+	}
+	c.desiredLock.Unlock()
+	return domainRBAC, desiredCRs
+}
+
+// peekDesiredState returns the Athenz model and the desired Istio RBAC configs
+// of a domain like getDesiredState, but neither stores the conversion nor
+// counts the cache lookup, for the read-only debug endpoints
+func (c *Controller) peekDesiredState(key string, domain *zms.DomainData, versions adv1.PolicyVersions) (athenz.Model, []model.Config) {
+	hash, err := domainHash(domain, versions)
+	if err == nil {
+		if cachedRBAC, cachedCRs, _, hit := c.cachedDesiredState(key, hash); hit {
+			return cachedRBAC, cachedCRs
+		}
+	}
+	return c.convertDomain(domain, versions)
+}
+
+// forgetDesiredState removes the cached desired state of a domain
+func (c *Controller) forgetDesiredState(key string) {
+	c.desiredLock.Lock()
+	defer c.desiredLock.Unlock()
+	delete(c.desired, key)
+}
+
+// invalidateDesiredState invalidates the cached desired state of all the
+// domains, it must be called whenever the controller configuration used by
+// the conversion changes
+func (c *Controller) invalidateDesiredState() {
+	c.desiredLock.Lock()
+	defer c.desiredLock.Unlock()
+	c.generation++
+	c.desired = make(map[string]desiredState)
+}
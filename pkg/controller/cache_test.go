@@ -0,0 +1,158 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ardielle/ardielle-go/rdl"
+	"github.com/yahoo/athenz/clients/go/zms"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"istio.io/istio/pilot/pkg/model"
+
+	adv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/apis/athenz/v1"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	rbacv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/v1"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingProvider counts the conversions of the wrapped provider
+type countingProvider struct {
+	conversions int
+}
+
+func (p *countingProvider) ConvertAthenzModelIntoIstioRbac(m athenz.Model) []model.Config {
+	p.conversions++
+	return rbacv1.NewProvider().ConvertAthenzModelIntoIstioRbac(m)
+}
+
+func (p *countingProvider) GetCurrentIstioRbac(m athenz.Model, csc model.ConfigStoreCache) []model.Config {
+	return rbacv1.NewProvider().GetCurrentIstioRbac(m, csc)
+}
+
+func newCacheDomain() *zms.DomainData {
+	allow := zms.ALLOW
+	return &zms.DomainData{
+		Name:     "test.namespace",
+		Modified: rdl.NewTimestamp(time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)),
+		Roles: []*zms.Role{
+			{
+				Name: "test.namespace:role.client",
+				RoleMembers: []*zms.RoleMember{
+					{
+						MemberName: "user.name",
+					},
+				},
+			},
+		},
+		Policies: &zms.SignedPolicies{
+			Contents: &zms.DomainPolicies{
+				Domain: "test.namespace",
+				Policies: []*zms.Policy{
+					{
+						Name: "test.namespace:policy.client",
+						Assertions: []*zms.Assertion{
+							{
+								Role:     "test.namespace:role.client",
+								Resource: "test.namespace:svc.my-service",
+								Action:   "get",
+								Effect:   &allow,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestDomainHash(t *testing.T) {
+	domain := newCacheDomain()
+	hash, err := domainHash(domain, nil)
+	assert.Nil(t, err, "error should be nil")
+
+	same, err := domainHash(newCacheDomain(), nil)
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, hash, same, "hash should be stable")
+
+	changed := newCacheDomain()
+	changed.Policies.Contents.Policies[0].Assertions[0].Action = "post"
+	changedHash, err := domainHash(changed, nil)
+	assert.Nil(t, err, "error should be nil")
+	assert.NotEqual(t, hash, changedHash, "hash should change with the policies")
+
+	modified := newCacheDomain()
+	modified.Modified = rdl.NewTimestamp(modified.Modified.Add(time.Second))
+	modifiedHash, err := domainHash(modified, nil)
+	assert.Nil(t, err, "error should be nil")
+	assert.NotEqual(t, hash, modifiedHash, "hash should change with the modified timestamp")
+
+	inactive := false
+	versionedHash, err := domainHash(newCacheDomain(), adv1.PolicyVersions{{Name: "test.namespace:policy.client", Active: &inactive}})
+	assert.Nil(t, err, "error should be nil")
+	assert.NotEqual(t, hash, versionedHash, "hash should change with the policy versions")
+}
+
+func TestGetDesiredState(t *testing.T) {
+	provider := &countingProvider{}
+	c := &Controller{
+		rbacProvider: provider,
+		desired:      make(map[string]desiredState),
+	}
+	key := "test-namespace/test.namespace"
+
+	domainRBAC, desiredCRs := c.getDesiredState(key, newCacheDomain(), nil)
+	assert.Equal(t, 1, provider.conversions, "domain should be converted on the first sync")
+	assert.Equal(t, "test-namespace", domainRBAC.Namespace, "namespace should be equal")
+	assert.Equal(t, 2, len(desiredCRs), "desired configs should be converted")
+
+	desiredCRs[0].Name = "modified"
+	_, cachedCRs := c.getDesiredState(key, newCacheDomain(), nil)
+	assert.Equal(t, 1, provider.conversions, "unchanged domain should not be converted again")
+	assert.NotEqual(t, "modified", cachedCRs[0].Name, "cached configs should not be modified by the caller")
+
+	changed := newCacheDomain()
+	changed.Roles[0].RoleMembers = append(changed.Roles[0].RoleMembers, &zms.RoleMember{MemberName: "user.other"})
+	c.getDesiredState(key, changed, nil)
+	assert.Equal(t, 2, provider.conversions, "changed domain should be converted again")
+
+	c.invalidateDesiredState()
+	c.getDesiredState(key, changed, nil)
+	assert.Equal(t, 3, provider.conversions, "domain should be converted again after an invalidation")
+
+	c.forgetDesiredState(key)
+	assert.Equal(t, 0, len(c.desired), "cached state should be removed")
+}
+
+func TestPeekDesiredState(t *testing.T) {
+	provider := &countingProvider{}
+	c := &Controller{
+		rbacProvider: provider,
+		desired:      make(map[string]desiredState),
+	}
+	key := "test-namespace/test.namespace"
+	hits := testutil.ToFloat64(metrics.DesiredStateCache.WithLabelValues(metrics.ResultHit))
+	misses := testutil.ToFloat64(metrics.DesiredStateCache.WithLabelValues(metrics.ResultMiss))
+
+	domainRBAC, desiredCRs := c.peekDesiredState(key, newCacheDomain(), nil)
+	assert.Equal(t, 1, provider.conversions, "domain should be converted without a cached state")
+	assert.Equal(t, "test-namespace", domainRBAC.Namespace, "namespace should be equal")
+	assert.Equal(t, 2, len(desiredCRs), "desired configs should be converted")
+	assert.Equal(t, 0, len(c.desired), "conversion should not be stored")
+	assert.Equal(t, hits, testutil.ToFloat64(metrics.DesiredStateCache.WithLabelValues(metrics.ResultHit)), "peek should not count a cache hit")
+	assert.Equal(t, misses, testutil.ToFloat64(metrics.DesiredStateCache.WithLabelValues(metrics.ResultMiss)), "peek should not count a cache miss")
+
+	c.getDesiredState(key, newCacheDomain(), nil)
+	hits = testutil.ToFloat64(metrics.DesiredStateCache.WithLabelValues(metrics.ResultHit))
+	_, cachedCRs := c.peekDesiredState(key, newCacheDomain(), nil)
+	assert.Equal(t, 2, provider.conversions, "cached state should be returned")
+	assert.Equal(t, hits, testutil.ToFloat64(metrics.DesiredStateCache.WithLabelValues(metrics.ResultHit)), "peek should not count a cache hit")
+	cachedCRs[0].Name = "modified"
+	assert.NotEqual(t, "modified", c.desired[key].configs[0].Name, "cached configs should not be modified by the caller")
+}
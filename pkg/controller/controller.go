@@ -5,48 +5,91 @@ package controller
 
 import (
 	"errors"
-	"fmt"
+	"sync"
 	"time"
 
 	"github.com/gogo/protobuf/proto"
 
+	"istio.io/api/rbac/v1alpha1"
 	"istio.io/istio/pilot/pkg/config/kube/crd"
 	"istio.io/istio/pilot/pkg/model"
 	"istio.io/istio/pilot/pkg/serviceregistry/kube"
 
 	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/fields"
-	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
 	"k8s.io/client-go/util/workqueue"
 
 	adv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/apis/athenz/v1"
-	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
-	m "github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/audit"
 	adClientset "github.com/yahoo/k8s-athenz-istio-auth/pkg/client/clientset/versioned"
 	adInformer "github.com/yahoo/k8s-athenz-istio-auth/pkg/client/informers/externalversions/athenz/v1"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/filter"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/onboarding"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/processor"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/common"
 	rbacv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/v1"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/limits"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/retry"
 )
 
-const (
-	queueNumRetries = 3
-	logPrefix       = "[controller]"
-)
+const eventComponent = "k8s-athenz-istio-auth"
+
+var logger = log.WithComponent("controller")
+
+// domainLogger returns a logger for the namespace and athenz domain of a queue key
+func domainLogger(key string) *log.Logger {
+	namespace, domain, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return logger.WithResource(key)
+	}
+	return logger.WithNamespace(namespace).WithDomain(domain)
+}
 
 type Controller struct {
-	configStoreCache     model.ConfigStoreCache
-	crcController        *onboarding.Controller
-	processor            *processor.Controller
-	serviceIndexInformer cache.SharedIndexInformer
-	adIndexInformer      cache.SharedIndexInformer
-	rbacProvider         rbac.Provider
-	queue                workqueue.RateLimitingInterface
-	adResyncInterval     time.Duration
+	configStoreCache       model.ConfigStoreCache
+	crcController          *onboarding.Controller
+	processor              *processor.Controller
+	serviceIndexInformer   cache.SharedIndexInformer
+	namespaceIndexInformer cache.SharedIndexInformer
+	adIndexInformer        cache.SharedIndexInformer
+	adClient               adClientset.Interface
+	rawClient              rest.Interface
+	rbacProvider           rbac.Provider
+	queue                  workqueue.RateLimitingInterface
+	adResyncInterval       time.Duration
+	workers                int
+	retryPolicy            retry.Policy
+	deadLetters            *retry.DeadLetters
+	skipUnchanged          bool
+	settingsLock           sync.RWMutex
+	synced                 map[string]syncState
+	syncedLock             sync.Mutex
+	desired                map[string]desiredState
+	generation             uint64
+	desiredLock            sync.Mutex
+	syncErrors             map[string]SyncError
+	syncErrorsLock         sync.Mutex
+	recorder               record.EventRecorder
+	auditLog               *audit.Logger
+	filter                 *filter.Filter
+	protected              filter.Protected
+	deletionLimits         limits.Limits
+	knownGood              map[string]knownGoodState
+	invalid                map[string]string
+	knownGoodLock          sync.Mutex
+	applied                map[string]appliedState
+	previewed              map[string]string
+	appliedLock            sync.Mutex
 }
 
 // convertSliceToKeyedMap converts the input model.Config slice into a map with (type/namespace/name) formatted key
@@ -121,34 +164,92 @@ func computeChangeList(current []model.Config, desired []model.Config, errHandle
 }
 
 // getErrHandler returns a error handler func that re-adds the athenz domain back to queue
+// until its retries are exhausted, the domain changes are then recomputed from the current state
 // this explicit func definition takes in the key to avoid data race while accessing key
 func (c *Controller) getErrHandler(key string) processor.OnErrorFunc {
 	return func(err error, item *processor.Item) error {
 		if err != nil {
 			if item != nil {
-				log.Errorf("%s Error performing %s on %s: %s", logPrefix, item.Operation, item.Resource.Key(), err)
+				domainLogger(key).WithResource(item.Resource.Key()).WithOperation(item.Operation).WithError(err).Errorf("Error performing the change")
 			}
-			c.queue.AddRateLimited(key)
+			c.recordSyncError(key, err)
+			if c.queue.NumRequeues(key) < c.retryPolicy.MaxRetries {
+				metrics.Retries.WithLabelValues(metrics.ControllerDomain).Inc()
+				c.queue.AddRateLimited(key)
+				return nil
+			}
+			c.deadLetters.Add(metrics.ControllerDomain, key, err, c.queue.NumRequeues(key))
+			c.queue.Forget(key)
 		}
 		return nil
 	}
 }
 
+// getServiceRoleTargets returns the services targeted by the constraints of the
+// given ServiceRole resources
+func getServiceRoleTargets(configs []model.Config) []string {
+	targets := make([]string, 0)
+	for _, config := range configs {
+		serviceRole, ok := config.Spec.(*v1alpha1.ServiceRole)
+		if !ok {
+			continue
+		}
+
+		for _, rule := range serviceRole.Rules {
+			for _, constraint := range rule.Constraints {
+				if constraint.Key == common.ConstraintSvcKey {
+					targets = append(targets, constraint.Values...)
+				}
+			}
+		}
+	}
+	return targets
+}
+
 // sync will be ran for each key in the queue and will be responsible for the following:
 // 1. Get the Athenz Domain from the cache for the queue key
-// 2. Convert to Athenz Model to group domain members and policies by role
-// 3. Convert Athenz Model to Service Role and Service Role Binding objects
-// 4. Create / Update / Delete Service Role and Service Role Binding objects
+// 2. Refuse the domain if another Athenz Domain owns its target namespace
+// 3. Convert to Athenz Model to group domain members and policies by role
+// 4. Convert Athenz Model to Service Role and Service Role Binding objects,
+//    the conversion is cached until the domain content changes
+// 5. Keep the last known good state of the domain if it is invalid or its
+//    transition is suspicious, skip it if no good state is known
+// 6. Refuse the domain if its namespace is protected
+// 7. Record the services targeted by the Service Roles for safe onboarding
+// 8. Report the Service Role and Service Role Binding objects edited outside
+//    of the controller
+// 9. Skip the namespace if its reconciliation is paused
+// 10. Dry-run and audit the changes of the policy version previewed on the
+//     namespace, if any
+// 11. Hold the changes if their deletions exceed the limits and were not
+//     approved on the namespace
+// 12. Create / Update / Delete Service Role and Service Role Binding objects as
+//     a single ordered batch, whose applied changes are audited
 func (c *Controller) sync(key string) error {
+	metrics.Syncs.WithLabelValues(metrics.ControllerDomain).Inc()
 	athenzDomainRaw, exists, err := c.adIndexInformer.GetIndexer().GetByKey(key)
 	if err != nil {
 		return err
 	}
 
 	if !exists {
+		namespace, err := c.targetNamespace(key)
+		if owner, found := c.indexOwner(targetNamespaceIndex, namespace); err == nil && found {
+			// another athenz domain targets the namespace and takes it over
+			c.queue.Add(owner)
+		} else if err == nil {
+			c.crcController.DeletePolicyTargets(namespace)
+		}
+		c.forgetSynced(key)
+		c.forgetDesiredState(key)
+		c.forgetKnownGood(key)
+		c.forgetApplied(key)
+		c.forgetPreviewed(key)
+
 		// TODO, add the non existing athenz domain to the istio custom resource
 		// processing controller to delete them
-		return fmt.Errorf("athenz domain %s does not exist in cache", key)
+		domainLogger(key).Debugf("Athenz domain does not exist in cache, its state was cleaned up")
+		return nil
 	}
 
 	athenzDomain, ok := athenzDomainRaw.(*adv1.AthenzDomain)
@@ -156,17 +257,65 @@ func (c *Controller) sync(key string) error {
 		return errors.New("athenz domain cast failed")
 	}
 
+	// the namespace labels may have changed since the domain was queued
+	if !c.domainInScope(athenzDomain) {
+		domainLogger(key).Infof("Domain is outside of the scope of the filter, skipping it")
+		return nil
+	}
+
+	if owner, duplicate := c.duplicateOf(key, athenzDomain); duplicate {
+		c.reportDuplicate(key, athenzDomain, owner)
+		return nil
+	}
+	c.updateDomainStatus(athenzDomain, duplicateTargetReason, "")
+
 	signedDomain := athenzDomain.Spec.SignedDomain
-	domainRBAC := m.ConvertAthenzPoliciesIntoRbacModel(signedDomain.Domain)
-	desiredCRs := c.rbacProvider.ConvertAthenzModelIntoIstioRbac(domainRBAC)
+	domainRBAC, desiredCRs := c.getDesiredState(key, signedDomain.Domain, athenzDomain.Spec.PolicyVersions)
+	domainRBAC, desiredCRs, known, err := c.knownGoodState(key, signedDomain, domainRBAC, desiredCRs)
+	if err != nil {
+		c.reportInvalid(key, athenzDomain, err, known)
+		if !known {
+			return nil
+		}
+	} else {
+		c.recordKnownGood(key, signedDomain, domainRBAC, desiredCRs)
+	}
+
+	if c.protected.Contains(domainRBAC.Namespace) {
+		c.reportProtected(key, athenzDomain, domainRBAC.Namespace)
+		return nil
+	}
+	c.updateDomainStatus(athenzDomain, filter.ProtectedNamespaceReason, "")
+
 	currentCRs := c.rbacProvider.GetCurrentIstioRbac(domainRBAC, c.configStoreCache)
 	errHandler := c.getErrHandler(key)
+	c.crcController.UpdatePolicyTargets(domainRBAC.Namespace, getServiceRoleTargets(desiredCRs))
+	c.detectDrift(currentCRs, desiredCRs)
+
+	if c.isPaused(domainRBAC.Namespace) {
+		domainLogger(key).Warningf("Reconciliation is paused for the namespace, skipping the domain")
+		metrics.PausedSyncs.Inc()
+		return nil
+	}
+	c.dryRunPreview(key, athenzDomain, domainRBAC, currentCRs)
 
 	changeList := computeChangeList(currentCRs, desiredCRs, errHandler)
-	for _, item := range changeList {
-		c.processor.ProcessConfigChange(item)
+	if len(changeList) == 0 {
+		// the domain converged, a previous failed batch no longer matters
+		c.processor.ClearStatus(key)
+		c.recordSynced(key, athenzDomain.ResourceVersion, domainRBAC.Namespace, currentCRs)
+		c.recordApplied(key, athenzDomain.ResourceVersion, domainRBAC)
+		return nil
+	}
+
+	if c.holdDeletions(key, athenzDomain, domainRBAC.Namespace, changeList, len(currentCRs)) {
+		return nil
 	}
 
+	batch := processor.NewBatch(key, changeList)
+	batch.OnApplied = c.auditBatch(key, athenzDomain, domainRBAC)
+	c.processor.ProcessBatch(batch)
+
 	return nil
 }
 
@@ -178,38 +327,89 @@ func (c *Controller) sync(key string) error {
 // 3. Onboarding controller responsible for creating / updating / deleting the
 //    cluster rbac config object based on a service label
 // 4. Service shared index informer
-// 5. Athenz Domain shared index informer
-func NewController(dnsSuffix string, istioClient *crd.Client, k8sClient kubernetes.Interface, adClient adClientset.Interface, adResyncInterval, crcResyncInterval time.Duration) *Controller {
-	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+// 5. Namespace shared index informer
+// 6. Athenz Domain shared index informer, indexed by the athenz domain of the
+//    spec and by the namespace its Istio RBAC resources are written to
+// 7. Event recorder for the drift of the generated resources and the access
+//    changes of the athenz domains
+// The options are built from the config with NewOptions. The service informer
+// only watches the namespaces matched by the filter and the Athenz Domain
+// informer the domains matched by its domain selector, a nil filter matches
+// all of them. No Istio RBAC resource is written to the protected namespaces
+// and none of their services is onboarded. The deletions of a domain sync and
+// the services removed from the cluster rbac config by a sync are held once
+// they exceed the limits, zero limits are disabled.
+func NewController(istioClient *crd.Client, k8sClient kubernetes.Interface, adClient adClientset.Interface, opts Options) *Controller {
+	queue := workqueue.NewRateLimitingQueue(opts.RetryPolicy.RateLimiter())
+	deadLetters := retry.NewDeadLetters()
 	configStoreCache := crd.NewController(istioClient, kube.ControllerOptions{})
 
-	serviceListWatch := cache.NewListWatchFromClient(k8sClient.CoreV1().RESTClient(), "services", v1.NamespaceAll, fields.Everything())
-	serviceIndexInformer := cache.NewSharedIndexInformer(serviceListWatch, &v1.Service{}, 0, nil)
-	processor := processor.NewController(configStoreCache)
-	crcController := onboarding.NewController(configStoreCache, dnsSuffix, serviceIndexInformer, crcResyncInterval, processor)
-	adIndexInformer := adInformer.NewAthenzDomainInformer(adClient, v1.NamespaceAll, 0, cache.Indexers{})
+	serviceListWatch := cache.NewListWatchFromClient(k8sClient.CoreV1().RESTClient(), "services", opts.Filter.WatchNamespace(), opts.Filter.FieldSelector())
+	serviceIndexInformer := cache.NewSharedIndexInformer(serviceListWatch, &v1.Service{}, 0, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	namespaceListWatch := cache.NewListWatchFromClient(k8sClient.CoreV1().RESTClient(), "namespaces", v1.NamespaceAll, fields.Everything())
+	namespaceIndexInformer := cache.NewSharedIndexInformer(namespaceListWatch, &v1.Namespace{}, 0, nil)
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: k8sClient.CoreV1().Events(v1.NamespaceAll)})
+	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: eventComponent})
+
+	var writeLimiter flowcontrol.RateLimiter
+	if opts.WriteQPS > 0 {
+		writeLimiter = flowcontrol.NewTokenBucketRateLimiter(opts.WriteQPS, opts.WriteBurst)
+	}
+	processor := processor.NewController(configStoreCache, opts.ProcessorWorkers, opts.RetryPolicy, deadLetters, writeLimiter)
+	crcController := onboarding.NewController(configStoreCache, serviceIndexInformer, namespaceIndexInformer, processor, deadLetters, recorder, opts.Onboarding)
+	adIndexInformer := adInformer.NewFilteredAthenzDomainInformer(adClient, v1.NamespaceAll, 0, adIndexers(), opts.Filter.TweakDomainListOptions)
 
 	c := &Controller{
-		serviceIndexInformer: serviceIndexInformer,
-		adIndexInformer:      adIndexInformer,
-		configStoreCache:     configStoreCache,
-		crcController:        crcController,
-		processor:            processor,
-		rbacProvider:         rbacv1.NewProvider(),
-		queue:                queue,
-		adResyncInterval:     adResyncInterval,
+		serviceIndexInformer:   serviceIndexInformer,
+		namespaceIndexInformer: namespaceIndexInformer,
+		adIndexInformer:        adIndexInformer,
+		adClient:               adClient,
+		rawClient:              k8sClient.Discovery().RESTClient(),
+		configStoreCache:       configStoreCache,
+		crcController:          crcController,
+		processor:              processor,
+		rbacProvider:           rbacv1.NewProvider(),
+		queue:                  queue,
+		adResyncInterval:       opts.ADResyncInterval,
+		workers:                opts.Workers,
+		retryPolicy:            opts.RetryPolicy,
+		deadLetters:            deadLetters,
+		skipUnchanged:          opts.SkipUnchanged,
+		synced:                 make(map[string]syncState),
+		desired:                make(map[string]desiredState),
+		syncErrors:             make(map[string]SyncError),
+		recorder:               recorder,
+		auditLog:               opts.AuditLog,
+		filter:                 opts.Filter,
+		protected:              opts.Protected,
+		deletionLimits:         opts.DeletionLimits,
+		knownGood:              make(map[string]knownGoodState),
+		invalid:                make(map[string]string),
+		applied:                make(map[string]appliedState),
+		previewed:              make(map[string]string),
 	}
 
 	configStoreCache.RegisterEventHandler(model.ServiceRole.Type, c.processConfigEvent)
 	configStoreCache.RegisterEventHandler(model.ServiceRoleBinding.Type, c.processConfigEvent)
 	configStoreCache.RegisterEventHandler(model.ClusterRbacConfig.Type, crcController.EventHandler)
+	configStoreCache.RegisterEventHandler(model.ServiceEntry.Type, crcController.ServiceEntryEventHandler)
+
+	namespaceIndexInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: c.approvalChanged,
+	})
 
 	adIndexInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
-			c.processEvent(cache.MetaNamespaceKeyFunc, obj)
+			if c.domainInScope(obj) {
+				c.processEvent(cache.MetaNamespaceKeyFunc, obj)
+			}
 		},
-		UpdateFunc: func(_, obj interface{}) {
-			c.processEvent(cache.MetaNamespaceKeyFunc, obj)
+		UpdateFunc: func(oldObj, obj interface{}) {
+			if c.domainInScope(obj) {
+				c.recordPreviousVersion(oldObj, obj)
+				c.processEvent(cache.MetaNamespaceKeyFunc, obj)
+			}
 		},
 		DeleteFunc: func(obj interface{}) {
 			c.processEvent(cache.DeletionHandlingMetaNamespaceKeyFunc, obj)
@@ -227,40 +427,96 @@ func (c *Controller) processEvent(fn cache.KeyFunc, obj interface{}) {
 		c.queue.Add(key)
 		return
 	}
-	log.Errorf("%s processEvent(): Error calling key func: %s", logPrefix, err.Error())
+	logger.WithError(err).Errorf("processEvent(): Error calling key func")
 }
 
-// processEvent is responsible for adding the key of the item to the queue
+// processConfigEvent is responsible for adding the key of the athenz domain
+// owning the namespace of the item to the queue, the events caused by the
+// writes of the processor and the events of the domains outside of the scope
+// of the filter are ignored
 func (c *Controller) processConfigEvent(config model.Config, e model.Event) {
-	domain := athenz.NamespaceToDomain(config.Namespace)
-	key := config.Namespace + "/" + domain
+	if c.processor.IsOwnWrite(config, e) {
+		metrics.ConfigEvents.WithLabelValues(config.Type, metrics.ResultSuppressed).Inc()
+		return
+	}
+
+	key := c.namespaceKey(config.Namespace)
+	if !c.configInScope(key) {
+		metrics.ConfigEvents.WithLabelValues(config.Type, metrics.ResultFiltered).Inc()
+		return
+	}
+
+	metrics.ConfigEvents.WithLabelValues(config.Type, metrics.ResultEnqueued).Inc()
 	c.queue.Add(key)
 }
 
 // Run starts the main controller loop running sync at every poll interval. It
 // also starts the following controller dependencies:
 // 1. Service informer
-// 2. Istio custom resource informer
-// 3. Athenz Domain informer
+// 2. Namespace informer
+// 3. Istio custom resource informer
+// 4. Athenz Domain informer
+// Once stopCh is closed, it shuts down in the following order and returns:
+// 1. The domain and cluster rbac config queues stop accepting keys
+// 2. The in-flight syncs finish, the keys left on the queues are skipped
+// 3. The processor writes the changes queued by the syncs and stops
 func (c *Controller) Run(stopCh <-chan struct{}) {
 	go c.serviceIndexInformer.Run(stopCh)
+	go c.namespaceIndexInformer.Run(stopCh)
 	go c.configStoreCache.Run(stopCh)
 	go c.adIndexInformer.Run(stopCh)
 
-	if !cache.WaitForCacheSync(stopCh, c.configStoreCache.HasSynced, c.serviceIndexInformer.HasSynced, c.adIndexInformer.HasSynced) {
-		log.Panicf("%s Run(): Timed out waiting for namespace cache to sync.", logPrefix)
+	// the cache sync only fails once stopCh is closed
+	if !cache.WaitForCacheSync(stopCh, c.configStoreCache.HasSynced, c.serviceIndexInformer.HasSynced, c.namespaceIndexInformer.HasSynced, c.adIndexInformer.HasSynced) {
+		logger.Warningf("Run(): Stopped before the caches synced")
+		c.queue.ShutDown()
+		return
 	}
 
-	// crc controller must wait for service informer to sync before starting
-	go c.processor.Run(stopCh)
-	go c.crcController.Run(stopCh)
+	// the processor is stopped after the controllers, which queue changes to it
+	processorStopCh := make(chan struct{})
+	processorDone := make(chan struct{})
+	go func() {
+		defer close(processorDone)
+		c.processor.Run(processorStopCh)
+	}()
+
+	// crc controller must wait for service and namespace informers to sync before starting
+	crcDone := make(chan struct{})
+	go func() {
+		defer close(crcDone)
+		c.crcController.Run(stopCh)
+	}()
 	go c.resync(stopCh)
 
-	defer c.queue.ShutDown()
-	wait.Until(c.runWorker, 0, stopCh)
+	// the queue never hands the same domain key to two workers
+	var workers sync.WaitGroup
+	for i := 0; i < c.workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			c.runWorker()
+		}()
+	}
+	<-stopCh
+
+	logger.Infof("Run(): Stopping, waiting for the in-flight syncs...")
+	c.queue.ShutDown()
+	workers.Wait()
+	<-crcDone
+	close(processorStopCh)
+	<-processorDone
+	logger.Infof("Run(): Stopped")
+}
+
+// Drained returns true if the processor wrote all the changes queued before
+// the controller stopped, it must be called once Run returned
+func (c *Controller) Drained() bool {
+	return c.processor.PendingKeys() == 0
 }
 
-// runWorker calls processNextItem to process events of the work queue
+// runWorker calls processNextItem to process events of the work queue until
+// the queue shuts down
 func (c *Controller) runWorker() {
 	for c.processNextItem() {
 	}
@@ -275,43 +531,44 @@ func (c *Controller) processNextItem() bool {
 	}
 
 	defer c.queue.Done(keyRaw)
+	if c.queue.ShuttingDown() {
+		return false
+	}
 	key, ok := keyRaw.(string)
 	if !ok {
-		log.Errorf("%s processNextItem(): String cast failed for key %v", logPrefix, key)
+		logger.Errorf("processNextItem(): String cast failed for key %v", keyRaw)
 		return true
 	}
 
-	log.Infof("%s processNextItem(): Processing key: %s", logPrefix, key)
+	domainLogger(key).Infof("processNextItem(): Processing key")
 	err := c.sync(key)
 	if err != nil {
-		log.Errorf("%s processNextItem(): Error syncing athenz state for key %s: %s", logPrefix, keyRaw, err)
-		if c.queue.NumRequeues(keyRaw) < queueNumRetries {
-			log.Infof("%s processNextItem(): Retrying key %s due to sync error", logPrefix, keyRaw)
+		domainLogger(key).WithError(err).Errorf("processNextItem(): Error syncing athenz state")
+		c.recordSyncError(key, err)
+		if c.queue.NumRequeues(keyRaw) < c.retryPolicy.MaxRetries {
+			domainLogger(key).Infof("processNextItem(): Retrying key due to sync error")
+			metrics.Retries.WithLabelValues(metrics.ControllerDomain).Inc()
 			c.queue.AddRateLimited(keyRaw)
 			return true
 		}
+		c.deadLetters.Add(metrics.ControllerDomain, key, err, c.queue.NumRequeues(keyRaw))
+		c.queue.Forget(keyRaw)
+		return true
+	}
+
+	// the backoff of the key is kept until the batch of the domain succeeds,
+	// the processor reports its failures to the error handler of the domain
+	if status, exists := c.processor.BatchStatus(key); exists && status.Err != nil {
+		return true
 	}
 
 	c.queue.Forget(keyRaw)
+	c.deadLetters.Remove(metrics.ControllerDomain, key)
+	c.recordSyncError(key, nil)
 	return true
 }
 
-// resync will run as a periodic resync at a given interval, it will take all
-// the current athenz domains in the cache and put them onto the queue
-func (c *Controller) resync(stopCh <-chan struct{}) {
-	t := time.NewTicker(c.adResyncInterval)
-	defer t.Stop()
-	for {
-		select {
-		case <-t.C:
-			log.Infof("%s Running resync for athenz domains...", logPrefix)
-			adListRaw := c.adIndexInformer.GetIndexer().List()
-			for _, adRaw := range adListRaw {
-				c.processEvent(cache.MetaNamespaceKeyFunc, adRaw)
-			}
-		case <-stopCh:
-			log.Infof("%s Stopping athenz domain resync...", logPrefix)
-			return
-		}
-	}
+// DeadLetters returns the keys which exhausted their retries
+func (c *Controller) DeadLetters() *retry.DeadLetters {
+	return c.deadLetters
 }
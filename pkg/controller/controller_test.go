@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"istio.io/api/rbac/v1alpha1"
+	"istio.io/istio/pilot/pkg/config/memory"
 	"istio.io/istio/pilot/pkg/model"
 
 	"k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -18,6 +19,7 @@ import (
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/processor"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/common"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/retry"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -49,7 +51,9 @@ func TestProcessEvent(t *testing.T) {
 
 func TestProcessConfigEvent(t *testing.T) {
 	c := &Controller{
-		queue: workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		queue:           workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		processor:       processor.NewController(memory.NewController(memory.Make(model.ConfigDescriptor{model.ServiceRole})), 1, retry.DefaultPolicy(), retry.NewDeadLetters(), nil),
+		adIndexInformer: cache.NewSharedIndexInformer(nil, &adv1.AthenzDomain{}, 0, adIndexers()),
 	}
 
 	config := model.Config{
@@ -68,6 +72,38 @@ func TestProcessConfigEvent(t *testing.T) {
 	assert.Equal(t, "test-namespace/test.namespace", item, "key should be equal")
 }
 
+func TestProcessConfigEventOwnWrite(t *testing.T) {
+	configStoreCache := memory.NewController(memory.Make(model.ConfigDescriptor{model.ServiceRole}))
+	c := &Controller{
+		queue:           workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		processor:       processor.NewController(configStoreCache, 1, retry.DefaultPolicy(), retry.NewDeadLetters(), nil),
+		adIndexInformer: cache.NewSharedIndexInformer(nil, &adv1.AthenzDomain{}, 0, adIndexers()),
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go c.processor.Run(stopCh)
+
+	c.processor.ProcessConfigChange(&processor.Item{
+		Operation: model.EventAdd,
+		Resource:  newSr("test-namespace", "test-role"),
+	})
+	var written *model.Config
+	for i := 0; i < 50 && written == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+		written = configStoreCache.Get(model.ServiceRole.Type, "test-role", "test-namespace")
+	}
+	assert.NotNil(t, written, "processor should create the ServiceRole")
+
+	c.processConfigEvent(*written, model.EventAdd)
+	assert.Equal(t, 0, c.queue.Len(), "queue length should be 0 for the echo of an own write")
+
+	external := *written
+	external.ResourceVersion = "external"
+	c.processConfigEvent(external, model.EventUpdate)
+	assert.Equal(t, 1, c.queue.Len(), "queue length should be 1 for an external edit")
+}
+
 func newSr(ns, role string) model.Config {
 	srSpec := &v1alpha1.ServiceRole{
 		Rules: []*v1alpha1.AccessRule{
@@ -393,6 +429,36 @@ func TestComputeChangeList(t *testing.T) {
 	}
 }
 
+func TestGetServiceRoleTargets(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []model.Config
+		expected []string
+	}{
+		{
+			name:     "should return empty list for empty input",
+			input:    []model.Config{},
+			expected: []string{},
+		},
+		{
+			name: "should return the services of the ServiceRole constraints",
+			input: []model.Config{
+				newSr("test-ns", "svc-role"),
+				newSrb("test-ns", "svc-role"),
+				updatedSr("test-ns", "another-role"),
+			},
+			expected: []string{"test-svc", "test-svc", "test-svc"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := getServiceRoleTargets(tt.input)
+			assert.Equal(t, tt.expected, actual, "service role targets should be equal to expected")
+		})
+	}
+}
+
 func TestResync(t *testing.T) {
 	fakeClientset := fake.NewSimpleClientset()
 	adIndexInformer := adInformer.NewAthenzDomainInformer(fakeClientset, v1.NamespaceAll, 0, cache.Indexers{})
@@ -406,7 +472,8 @@ func TestResync(t *testing.T) {
 
 	stopCh := make(chan struct{})
 	go c.resync(stopCh)
-	time.Sleep(time.Second * 2)
+	// the first resync runs within the jittered interval and adds the domain at its offset within the interval
+	time.Sleep(time.Second * 3)
 	close(stopCh)
 
 	assert.Equal(t, 1, c.queue.Len(), "queue length should be 1")
@@ -0,0 +1,186 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package controller
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"istio.io/istio/pilot/pkg/model"
+
+	adv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/apis/athenz/v1"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+)
+
+// SyncError is the last error which occurred while syncing a domain
+type SyncError struct {
+	Error string    `json:"error"`
+	Time  time.Time `json:"time"`
+}
+
+// PendingChange is a change which the next sync of a domain would apply
+type PendingChange struct {
+	Operation string       `json:"operation"`
+	Key       string       `json:"key"`
+	Resource  model.Config `json:"resource"`
+}
+
+// DomainState is the conversion and sync state of a domain
+type DomainState struct {
+	Key           string          `json:"key"`
+	Model         athenz.Model    `json:"model"`
+	Desired       []model.Config  `json:"desired"`
+	Current       []model.Config  `json:"current"`
+	Pending       []PendingChange `json:"pending"`
+	Paused        bool            `json:"paused"`
+	Protected     bool            `json:"protected"`
+	Held          string          `json:"held,omitempty"`
+	Invalid       string          `json:"invalid,omitempty"`
+	LastSyncError *SyncError      `json:"lastSyncError,omitempty"`
+	LastBatch     string          `json:"lastBatch,omitempty"`
+	DuplicateOf   string          `json:"duplicateOf,omitempty"`
+	Preview       *PreviewState   `json:"preview,omitempty"`
+}
+
+// recordSyncError records the last error of a domain, a nil error clears it
+func (c *Controller) recordSyncError(key string, err error) {
+	c.syncErrorsLock.Lock()
+	defer c.syncErrorsLock.Unlock()
+	if err == nil {
+		delete(c.syncErrors, key)
+		return
+	}
+	c.syncErrors[key] = SyncError{
+		Error: err.Error(),
+		Time:  time.Now(),
+	}
+}
+
+// lastSyncError returns the last error of a domain, if its last sync failed
+func (c *Controller) lastSyncError(key string) *SyncError {
+	c.syncErrorsLock.Lock()
+	defer c.syncErrorsLock.Unlock()
+	syncErr, exists := c.syncErrors[key]
+	if !exists {
+		return nil
+	}
+	return &syncErr
+}
+
+// debugKey returns the queue key of the domain of the request, or of the
+// domain owning the namespace of the request
+func (c *Controller) debugKey(r *http.Request) (string, error) {
+	if domain := r.URL.Query().Get("domain"); domain != "" {
+		return c.domainKey(domain), nil
+	}
+	if namespace := r.URL.Query().Get("namespace"); namespace != "" {
+		return c.namespaceKey(namespace), nil
+	}
+	return "", errors.New("domain or namespace query parameter is required")
+}
+
+// DomainState returns the conversion and sync state of a domain without
+// changing it
+func (c *Controller) DomainState(key string) (*DomainState, bool, error) {
+	athenzDomainRaw, exists, err := c.adIndexInformer.GetIndexer().GetByKey(key)
+	if err != nil || !exists {
+		return nil, exists, err
+	}
+
+	athenzDomain, ok := athenzDomainRaw.(*adv1.AthenzDomain)
+	if !ok {
+		return nil, false, errors.New("athenz domain cast failed")
+	}
+
+	domainRBAC, desiredCRs := c.peekDesiredState(key, athenzDomain.Spec.SignedDomain.Domain, athenzDomain.Spec.PolicyVersions)
+	goodRBAC, goodCRs, known, invalidErr := c.knownGoodState(key, athenzDomain.Spec.SignedDomain, domainRBAC, desiredCRs)
+	if known {
+		domainRBAC, desiredCRs = goodRBAC, goodCRs
+	}
+	currentCRs := c.rbacProvider.GetCurrentIstioRbac(domainRBAC, c.configStoreCache)
+	state := &DomainState{
+		Key:           key,
+		Model:         domainRBAC,
+		Desired:       desiredCRs,
+		Current:       currentCRs,
+		Pending:       make([]PendingChange, 0),
+		Paused:        c.isPaused(domainRBAC.Namespace),
+		Protected:     c.protected.Contains(domainRBAC.Namespace),
+		LastSyncError: c.lastSyncError(key),
+		Preview:       c.previewState(athenzDomain, domainRBAC.Namespace, currentCRs),
+	}
+	if invalidErr != nil {
+		state.Invalid = invalidErr.Error()
+	}
+	if owner, duplicate := c.duplicateOf(key, athenzDomain); duplicate {
+		// the sync refuses a domain whose target namespace is owned by another
+		state.DuplicateOf = owner
+		return state, true, nil
+	}
+	if !known {
+		// the sync skips a domain without a known good state
+		return state, true, nil
+	}
+
+	changeList := computeChangeList(currentCRs, desiredCRs, nil)
+	state.Held, _ = c.heldDeletions(domainRBAC.Namespace, changeList, len(currentCRs))
+	for _, item := range changeList {
+		state.Pending = append(state.Pending, PendingChange{
+			Operation: item.Operation.String(),
+			Key:       item.Resource.Key(),
+			Resource:  item.Resource,
+		})
+	}
+
+	if status, exists := c.processor.BatchStatus(key); exists {
+		state.LastBatch = status.String()
+	}
+
+	return state, true, nil
+}
+
+// RequireToken returns a handler which only passes the requests carrying the
+// token as a bearer token to the handler
+func RequireToken(token string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(auth), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// DebugHandler returns a handler writing the state of the domain or namespace
+// of the request as json, the requests must carry the token as a bearer token
+func (c *Controller) DebugHandler(token string) http.Handler {
+	return RequireToken(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, err := c.debugKey(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		state, exists, err := c.DomainState(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			http.Error(w, "athenz domain "+key+" does not exist in cache", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(state)
+		if err != nil {
+			domainLogger(key).WithError(err).Errorf("Error writing the state of the domain")
+		}
+	}))
+}
@@ -0,0 +1,138 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"istio.io/istio/pilot/pkg/config/memory"
+	"istio.io/istio/pilot/pkg/model"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	adv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/apis/athenz/v1"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/processor"
+	rbacv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/v1"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/retry"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newDebugController(t *testing.T) *Controller {
+	configStoreCache := memory.NewController(memory.Make(model.ConfigDescriptor{model.ServiceRole, model.ServiceRoleBinding}))
+	c := &Controller{
+		configStoreCache:       configStoreCache,
+		processor:              processor.NewController(configStoreCache, 1, retry.DefaultPolicy(), retry.NewDeadLetters(), nil),
+		adIndexInformer:        cache.NewSharedIndexInformer(nil, &adv1.AthenzDomain{}, 0, adIndexers()),
+		namespaceIndexInformer: cache.NewSharedIndexInformer(nil, &v1.Namespace{}, 0, cache.Indexers{}),
+		rbacProvider:           rbacv1.NewProvider(),
+		desired:                make(map[string]desiredState),
+		knownGood:              make(map[string]knownGoodState),
+		applied:                make(map[string]appliedState),
+		previewed:              make(map[string]string),
+		invalid:                make(map[string]string),
+		syncErrors:             make(map[string]SyncError),
+	}
+
+	athenzDomain := ad.DeepCopy()
+	athenzDomain.Spec.SignedDomain.Domain = newCacheDomain()
+	err := c.adIndexInformer.GetIndexer().Add(athenzDomain)
+	assert.Nil(t, err, "adding the athenz domain should return nil")
+	return c
+}
+
+func TestDomainState(t *testing.T) {
+	c := newDebugController(t)
+	c.recordSyncError("test-namespace/test.namespace", errors.New("sync failed"))
+
+	state, exists, err := c.DomainState("test-namespace/test.namespace")
+	assert.Nil(t, err, "error should be nil")
+	assert.True(t, exists, "domain should exist")
+	assert.Equal(t, "test-namespace", state.Model.Namespace, "model namespace should be equal")
+	assert.Equal(t, 2, len(state.Desired), "desired configs should be equal")
+	assert.Equal(t, 0, len(state.Current), "current configs should be empty")
+	assert.Equal(t, 2, len(state.Pending), "pending changes should create the desired configs")
+	assert.Equal(t, "add", state.Pending[0].Operation, "pending operation should be equal")
+	assert.Equal(t, "sync failed", state.LastSyncError.Error, "last sync error should be equal")
+
+	c.recordSyncError("test-namespace/test.namespace", nil)
+	state, _, _ = c.DomainState("test-namespace/test.namespace")
+	assert.Nil(t, state.LastSyncError, "last sync error should be cleared")
+
+	_, exists, err = c.DomainState("missing-namespace/missing.namespace")
+	assert.Nil(t, err, "error should be nil")
+	assert.False(t, exists, "domain should not exist")
+}
+
+func TestDebugHandler(t *testing.T) {
+	c := newDebugController(t)
+	handler := c.DebugHandler("secret")
+
+	tests := []struct {
+		name     string
+		url      string
+		token    string
+		expected int
+	}{
+		{
+			name:     "should reject requests without token",
+			url:      "/debug/domains?domain=test.namespace",
+			expected: http.StatusUnauthorized,
+		},
+		{
+			name:     "should reject requests with a wrong token",
+			url:      "/debug/domains?domain=test.namespace",
+			token:    "wrong",
+			expected: http.StatusUnauthorized,
+		},
+		{
+			name:     "should require a domain or namespace",
+			url:      "/debug/domains",
+			token:    "secret",
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "should return not found for a missing domain",
+			url:      "/debug/domains?domain=missing.namespace",
+			token:    "secret",
+			expected: http.StatusNotFound,
+		},
+		{
+			name:     "should return the state of a domain",
+			url:      "/debug/domains?domain=test.namespace",
+			token:    "secret",
+			expected: http.StatusOK,
+		},
+		{
+			name:     "should return the state of a namespace",
+			url:      "/debug/domains?namespace=test-namespace",
+			token:    "secret",
+			expected: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tt.expected, w.Code, "status code should be equal")
+
+			if tt.expected == http.StatusOK {
+				state := make(map[string]interface{})
+				err := json.Unmarshal(w.Body.Bytes(), &state)
+				assert.Nil(t, err, "response should be valid json")
+				assert.Equal(t, "test-namespace/test.namespace", state["key"], "key should be equal")
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+
+	"istio.io/istio/pilot/pkg/config/kube/crd"
+	"istio.io/istio/pilot/pkg/model"
+
+	"k8s.io/api/core/v1"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
+)
+
+const (
+	pauseAnnotation       = "authz.istio.io/pause-reconciliation"
+	lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+	driftReason           = "DriftDetected"
+	unknownChanger        = "unknown"
+)
+
+// toJSONValue converts a spec into its generic json representation
+func toJSONValue(spec proto.Message) (interface{}, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	err = json.Unmarshal(data, &value)
+	return value, err
+}
+
+// collectDiff appends the paths of the fields which differ between the two
+// json values to the fields list
+func collectDiff(path string, current, desired interface{}, fields *[]string) {
+	switch currentValue := current.(type) {
+	case map[string]interface{}:
+		desiredValue, ok := desired.(map[string]interface{})
+		if !ok {
+			*fields = append(*fields, path)
+			return
+		}
+
+		keys := make(map[string]bool)
+		for key := range currentValue {
+			keys[key] = true
+		}
+		for key := range desiredValue {
+			keys[key] = true
+		}
+		for key := range keys {
+			collectDiff(path+"."+key, currentValue[key], desiredValue[key], fields)
+		}
+	case []interface{}:
+		desiredValue, ok := desired.([]interface{})
+		if !ok || len(currentValue) != len(desiredValue) {
+			*fields = append(*fields, path)
+			return
+		}
+
+		for i := range currentValue {
+			collectDiff(fmt.Sprintf("%s[%d]", path, i), currentValue[i], desiredValue[i], fields)
+		}
+	default:
+		if !reflect.DeepEqual(current, desired) {
+			*fields = append(*fields, path)
+		}
+	}
+}
+
+// diffFields returns the sorted paths of the fields which differ between the
+// current and the desired spec, for example spec.rules[0].methods
+func diffFields(current, desired proto.Message) ([]string, error) {
+	currentValue, err := toJSONValue(current)
+	if err != nil {
+		return nil, err
+	}
+	desiredValue, err := toJSONValue(desired)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make([]string, 0)
+	collectDiff("spec", currentValue, desiredValue, &fields)
+	sort.Strings(fields)
+	return fields, nil
+}
+
+// managedFieldsEntry is an entry of the metadata.managedFields of a resource
+type managedFieldsEntry struct {
+	Manager string    `json:"manager"`
+	Time    time.Time `json:"time"`
+}
+
+// lastManager returns the manager of the most recent entry of the managed
+// fields of a raw resource, or an empty string if it has none
+func lastManager(data []byte) (string, error) {
+	var resource struct {
+		Metadata struct {
+			ManagedFields []managedFieldsEntry `json:"managedFields"`
+		} `json:"metadata"`
+	}
+	err := json.Unmarshal(data, &resource)
+	if err != nil {
+		return "", err
+	}
+
+	var last managedFieldsEntry
+	for _, entry := range resource.Metadata.ManagedFields {
+		if entry.Manager != "" && !entry.Time.Before(last.Time) {
+			last = entry
+		}
+	}
+	return last.Manager, nil
+}
+
+// managerOf returns the manager which last changed the resource according to
+// its managed fields. The vendored apimachinery ObjectMeta and the Istio
+// config store drop the managed fields, so the raw resource is fetched.
+func (c *Controller) managerOf(config model.Config) string {
+	if c.rawClient == nil {
+		return ""
+	}
+	schema, exists := model.IstioConfigTypes.GetByType(config.Type)
+	if !exists {
+		return ""
+	}
+
+	data, err := c.rawClient.Get().
+		AbsPath("/apis", crd.ResourceGroup(&schema), schema.Version, "namespaces", config.Namespace, crd.ResourceName(schema.Plural), config.Name).
+		DoRaw()
+	if err == nil {
+		var manager string
+		manager, err = lastManager(data)
+		if err == nil {
+			return manager
+		}
+	}
+	logger.WithNamespace(config.Namespace).WithResource(config.Key()).WithError(err).Debugf("Error reading the managed fields")
+	return ""
+}
+
+// changedBy returns who or what last changed the resource, the manager of its
+// most recent managed fields entry when the api server tracks them, and
+// kubectl if the kubectl last applied annotation is set otherwise
+func (c *Controller) changedBy(config model.Config) string {
+	if manager := c.managerOf(config); manager != "" {
+		return manager
+	}
+	if _, exists := config.Annotations[lastAppliedAnnotation]; exists {
+		return "kubectl"
+	}
+	return unknownChanger
+}
+
+// newObjectReference returns the object reference used to record events on an
+// Istio custom resource
+func newObjectReference(config model.Config) *v1.ObjectReference {
+	return &v1.ObjectReference{
+		Kind:            crd.KebabCaseToCamelCase(config.Type),
+		APIVersion:      config.Group + "/" + config.Version,
+		Namespace:       config.Namespace,
+		Name:            config.Name,
+		ResourceVersion: config.ResourceVersion,
+	}
+}
+
+// reportDrift logs, counts and records an event for a generated resource which
+// was edited outside of the controller
+func (c *Controller) reportDrift(current, desired model.Config) {
+	resourceLogger := logger.WithNamespace(current.Namespace).WithResource(current.Key())
+	fields, err := diffFields(current.Spec, desired.Spec)
+	if err != nil {
+		resourceLogger.WithError(err).Errorf("Error computing the drifted fields")
+	}
+
+	message := fmt.Sprintf("%s was edited outside of the controller by %s, drifted fields: %s",
+		current.Key(), c.changedBy(current), strings.Join(fields, ", "))
+	resourceLogger.Warningf("%s", message)
+	metrics.DriftDetected.WithLabelValues(current.Type).Inc()
+	if c.recorder != nil {
+		c.recorder.Event(newObjectReference(current), v1.EventTypeWarning, driftReason, message)
+	}
+}
+
+// detectDrift reports the current resources which differ from the desired
+// ones although the processor wrote them. A resource version different from
+// the one of the last write means the resource was edited by someone else.
+// Resources not written since the controller started cannot be told apart from
+// outdated ones and are not reported.
+func (c *Controller) detectDrift(current, desired []model.Config) {
+	desiredMap := convertSliceToKeyedMap(desired)
+	for _, currConfig := range current {
+		desiredConfig, exists := desiredMap[currConfig.Key()]
+		if !exists || equal(currConfig, desiredConfig) {
+			continue
+		}
+
+		revision, written := c.processor.LastWrite(currConfig.Key())
+		if !written || revision == currConfig.ResourceVersion {
+			continue
+		}
+		c.reportDrift(currConfig, desiredConfig)
+	}
+}
+
+// isPaused returns true if reconciliation is paused on the namespace with the
+// pause annotation, as a break-glass for manual edits of the generated resources
+func (c *Controller) isPaused(namespace string) bool {
+	namespaceRaw, exists, err := c.namespaceIndexInformer.GetIndexer().GetByKey(namespace)
+	if err != nil || !exists {
+		return false
+	}
+
+	ns, ok := namespaceRaw.(*v1.Namespace)
+	if !ok {
+		logger.WithNamespace(namespace).Errorf("Could not cast to namespace object")
+		return false
+	}
+	return ns.Annotations[pauseAnnotation] == "true"
+}
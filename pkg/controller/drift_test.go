@@ -0,0 +1,182 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package controller
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/api/rbac/v1alpha1"
+	"istio.io/istio/pilot/pkg/config/memory"
+	"istio.io/istio/pilot/pkg/model"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	restfake "k8s.io/client-go/rest/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/processor"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/retry"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  model.Config
+		desired  model.Config
+		expected []string
+	}{
+		{
+			name:     "should return no fields for equal specs",
+			current:  newSr("test-ns", "test-role"),
+			desired:  newSr("test-ns", "test-role"),
+			expected: []string{},
+		},
+		{
+			name:     "should return the list if its length differs",
+			current:  updatedSr("test-ns", "test-role"),
+			desired:  newSr("test-ns", "test-role"),
+			expected: []string{"spec.rules"},
+		},
+		{
+			name:    "should return the nested fields which differ",
+			current: newSr("test-ns", "test-role"),
+			desired: func() model.Config {
+				sr := newSr("test-ns", "test-role")
+				spec := sr.Spec.(*v1alpha1.ServiceRole)
+				spec.Rules[0].Methods = []string{"POST"}
+				spec.Rules[0].Paths = []string{"/api"}
+				return sr
+			}(),
+			expected: []string{"spec.rules[0].methods[0]", "spec.rules[0].paths"},
+		},
+		{
+			name:     "should return the fields of service role bindings",
+			current:  updatedSrb("test-ns", "test-role"),
+			desired:  newSrb("test-ns", "test-role"),
+			expected: []string{"spec.subjects"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, err := diffFields(tt.current.Spec, tt.desired.Spec)
+			assert.Nil(t, err, "error should be nil")
+			assert.Equal(t, tt.expected, fields, "fields should be equal")
+		})
+	}
+}
+
+func TestLastManager(t *testing.T) {
+	manager, err := lastManager([]byte(`{"metadata":{"managedFields":[
+		{"manager":"k8s-athenz-istio-auth","operation":"Update","time":"2019-06-01T10:00:00Z"},
+		{"manager":"kubectl-edit","operation":"Update","time":"2019-06-02T10:00:00Z"},
+		{"manager":"helm","operation":"Update","time":"2019-06-01T12:00:00Z"}]}}`))
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, "kubectl-edit", manager, "manager of the most recent entry should be returned")
+
+	manager, err = lastManager([]byte(`{"metadata":{"name":"test-role"}}`))
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, "", manager, "manager should be empty without managed fields")
+
+	_, err = lastManager([]byte(`{`))
+	assert.NotNil(t, err, "error should not be nil for invalid json")
+}
+
+func TestChangedBy(t *testing.T) {
+	c := &Controller{}
+	sr := newSr("test-ns", "test-role")
+	assert.Equal(t, unknownChanger, c.changedBy(sr), "changer should be unknown without hints")
+
+	sr.Annotations = map[string]string{lastAppliedAnnotation: "{}"}
+	assert.Equal(t, "kubectl", c.changedBy(sr), "changer should be kubectl if the last applied annotation is set")
+
+	var path string
+	body := `{"metadata":{"managedFields":[{"manager":"kubectl-edit","time":"2019-06-02T10:00:00Z"}]}}`
+	c.rawClient = &restfake.RESTClient{
+		NegotiatedSerializer: scheme.Codecs,
+		Client: restfake.CreateHTTPClient(func(req *http.Request) (*http.Response, error) {
+			path = req.URL.Path
+			return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+		}),
+	}
+	assert.Equal(t, "kubectl-edit", c.changedBy(sr), "changer should be the manager of the managed fields")
+	assert.Equal(t, "/apis/rbac.istio.io/v1alpha1/namespaces/test-ns/serviceroles/test-role", path, "path should be equal")
+
+	body = `{"metadata":{"name":"test-role"}}`
+	assert.Equal(t, "kubectl", c.changedBy(sr), "changer should fall back to the annotation without managed fields")
+}
+
+func TestDetectDrift(t *testing.T) {
+	configStoreCache := memory.NewController(memory.Make(model.ConfigDescriptor{model.ServiceRole}))
+	recorder := record.NewFakeRecorder(10)
+	c := &Controller{
+		processor: processor.NewController(configStoreCache, 1, retry.DefaultPolicy(), retry.NewDeadLetters(), nil),
+		recorder:  recorder,
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go c.processor.Run(stopCh)
+
+	c.processor.ProcessConfigChange(&processor.Item{
+		Operation: model.EventAdd,
+		Resource:  newSr("test-ns", "test-role"),
+	})
+	var written *model.Config
+	for i := 0; i < 50 && written == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+		written = configStoreCache.Get(model.ServiceRole.Type, "test-role", "test-ns")
+	}
+	assert.NotNil(t, written, "processor should create the ServiceRole")
+
+	// the desired state changed, the resource was not edited
+	c.detectDrift([]model.Config{*written}, []model.Config{updatedSr("test-ns", "test-role")})
+	assert.Equal(t, 0, len(recorder.Events), "no event should be recorded for an outdated resource")
+
+	edited := updatedSr("test-ns", "test-role")
+	edited.ConfigMeta = written.ConfigMeta
+	_, err := configStoreCache.Update(edited)
+	assert.Nil(t, err, "update should return nil")
+	current := configStoreCache.Get(model.ServiceRole.Type, "test-role", "test-ns")
+
+	c.detectDrift([]model.Config{*current}, []model.Config{newSr("test-ns", "test-role")})
+	assert.Equal(t, 1, len(recorder.Events), "an event should be recorded for an edited resource")
+	assert.Contains(t, <-recorder.Events, "spec.rules", "event should list the drifted fields")
+
+	c.detectDrift([]model.Config{*current}, []model.Config{*current})
+	assert.Equal(t, 0, len(recorder.Events), "no event should be recorded for an edit matching the desired state")
+}
+
+func TestIsPaused(t *testing.T) {
+	namespaceIndexInformer := cache.NewSharedIndexInformer(nil, &v1.Namespace{}, 0, cache.Indexers{})
+	c := &Controller{
+		namespaceIndexInformer: namespaceIndexInformer,
+	}
+
+	err := namespaceIndexInformer.GetIndexer().Add(&v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "paused-ns",
+			Annotations: map[string]string{pauseAnnotation: "true"},
+		},
+	})
+	assert.Nil(t, err, "adding the namespace should return nil")
+	err = namespaceIndexInformer.GetIndexer().Add(&v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test-ns",
+		},
+	})
+	assert.Nil(t, err, "adding the namespace should return nil")
+
+	assert.True(t, c.isPaused("paused-ns"), "namespace with the pause annotation should be paused")
+	assert.False(t, c.isPaused("test-ns"), "namespace without the pause annotation should not be paused")
+	assert.False(t, c.isPaused("missing-ns"), "missing namespace should not be paused")
+}
@@ -0,0 +1,178 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package controller
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/yahoo/athenz/clients/go/zms"
+
+	"istio.io/istio/pilot/pkg/model"
+
+	"k8s.io/api/core/v1"
+
+	adv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/apis/athenz/v1"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
+)
+
+const (
+	allowEmptyAnnotation = "authz.istio.io/allow-empty-domain"
+	invalidDomainReason  = "InvalidDomain"
+)
+
+// knownGoodState is the last desired state of a domain computed from a valid
+// version of the domain
+type knownGoodState struct {
+	model   athenz.Model
+	configs []model.Config
+	signed  bool
+}
+
+// isSigned returns true if the domain or its policies carry a signature
+func isSigned(signedDomain zms.SignedDomain) bool {
+	if signedDomain.Signature != "" {
+		return true
+	}
+	domain := signedDomain.Domain
+	return domain != nil && domain.Policies != nil && domain.Policies.Signature != ""
+}
+
+// validateDomain returns an error if the signed domain is invalid or if its
+// transition from the last known good state is suspicious:
+// 1. The domain or its policies are missing
+// 2. The domain has no roles where it had some
+// 3. The domain has no policy assertions where it had some
+// 4. The domain is no longer signed where it was
+// Without a known good state, e.g. after a restart, an empty domain is
+// instead checked against the current Istio RBAC resources of its namespace.
+// The emptiness checks are skipped if emptying the domain is allowed.
+func validateDomain(signedDomain zms.SignedDomain, domainRBAC athenz.Model, good *knownGoodState, current int, allowEmpty bool) error {
+	domain := signedDomain.Domain
+	if domain == nil {
+		return errors.New("the domain is missing")
+	}
+	if domain.Policies == nil || domain.Policies.Contents == nil {
+		return errors.New("the domain policies are missing")
+	}
+	if good == nil {
+		if allowEmpty || current == 0 {
+			return nil
+		}
+		if len(domainRBAC.Roles) == 0 {
+			return fmt.Errorf("the domain has no roles where %d Istio RBAC resources exist in its namespace", current)
+		}
+		if len(domainRBAC.Rules) == 0 {
+			return fmt.Errorf("the domain has no policy assertions where %d Istio RBAC resources exist in its namespace", current)
+		}
+		return nil
+	}
+
+	if !allowEmpty && len(domainRBAC.Roles) == 0 && len(good.model.Roles) > 0 {
+		return fmt.Errorf("the domain has no roles where it had %d", len(good.model.Roles))
+	}
+	if !allowEmpty && len(domainRBAC.Rules) == 0 && len(good.model.Rules) > 0 {
+		return fmt.Errorf("the domain has no policy assertions where %d roles had some", len(good.model.Rules))
+	}
+	if good.signed && !isSigned(signedDomain) {
+		return errors.New("the domain signature is missing where it was signed")
+	}
+	return nil
+}
+
+// knownGoodState returns the desired state to sync a domain to and whether
+// one is known. It is the converted state of a valid domain, or else the last
+// known good state of the domain along with the validation error.
+func (c *Controller) knownGoodState(key string, signedDomain zms.SignedDomain, domainRBAC athenz.Model, desiredCRs []model.Config) (athenz.Model, []model.Config, bool, error) {
+	c.knownGoodLock.Lock()
+	good, exists := c.knownGood[key]
+	c.knownGoodLock.Unlock()
+
+	var last *knownGoodState
+	current := 0
+	if exists {
+		last = &good
+	} else if signedDomain.Domain != nil && (len(domainRBAC.Roles) == 0 || len(domainRBAC.Rules) == 0) {
+		// only an empty domain is checked against the current resources
+		current = len(c.rbacProvider.GetCurrentIstioRbac(domainRBAC, c.configStoreCache))
+	}
+	err := validateDomain(signedDomain, domainRBAC, last, current, c.allowsEmptyDomain(domainRBAC.Namespace))
+	if err == nil {
+		return domainRBAC, desiredCRs, true, nil
+	}
+	if !exists {
+		return athenz.Model{}, nil, false, err
+	}
+
+	configs := make([]model.Config, len(good.configs))
+	copy(configs, good.configs)
+	return good.model, configs, true, err
+}
+
+// allowsEmptyDomain returns true if emptying the domain of the namespace is
+// allowed with the allow empty annotation
+func (c *Controller) allowsEmptyDomain(namespace string) bool {
+	namespaceRaw, exists, err := c.namespaceIndexInformer.GetIndexer().GetByKey(namespace)
+	if err != nil || !exists {
+		return false
+	}
+
+	ns, ok := namespaceRaw.(*v1.Namespace)
+	if !ok {
+		logger.WithNamespace(namespace).Errorf("Could not cast to namespace object")
+		return false
+	}
+	return ns.Annotations[allowEmptyAnnotation] == "true"
+}
+
+// recordKnownGood records the desired state of a valid domain as its last
+// known good state and clears its invalid condition
+func (c *Controller) recordKnownGood(key string, signedDomain zms.SignedDomain, domainRBAC athenz.Model, desiredCRs []model.Config) {
+	configs := make([]model.Config, len(desiredCRs))
+	copy(configs, desiredCRs)
+
+	c.knownGoodLock.Lock()
+	defer c.knownGoodLock.Unlock()
+	c.knownGood[key] = knownGoodState{
+		model:   domainRBAC,
+		configs: configs,
+		signed:  isSigned(signedDomain),
+	}
+	if _, exists := c.invalid[key]; exists {
+		domainLogger(key).Infof("Domain is valid again, syncing it")
+		delete(c.invalid, key)
+		metrics.InvalidDomains.Set(float64(len(c.invalid)))
+	}
+}
+
+// reportInvalid logs and records the invalid condition of a domain, an event
+// is recorded on the athenz domain whenever the reason changes
+func (c *Controller) reportInvalid(key string, athenzDomain *adv1.AthenzDomain, err error, known bool) {
+	if known {
+		domainLogger(key).WithError(err).Warningf("Domain is invalid, keeping its last known good state")
+	} else {
+		domainLogger(key).WithError(err).Warningf("Domain is invalid and has no known good state, skipping it")
+	}
+
+	c.knownGoodLock.Lock()
+	previous := c.invalid[key]
+	c.invalid[key] = err.Error()
+	metrics.InvalidDomains.Set(float64(len(c.invalid)))
+	c.knownGoodLock.Unlock()
+
+	if c.recorder != nil && previous != err.Error() {
+		c.recorder.Event(newDomainReference(athenzDomain), v1.EventTypeWarning, invalidDomainReason, err.Error())
+	}
+}
+
+// forgetKnownGood removes the last known good state and the invalid condition
+// of a deleted domain
+func (c *Controller) forgetKnownGood(key string) {
+	c.knownGoodLock.Lock()
+	defer c.knownGoodLock.Unlock()
+	delete(c.knownGood, key)
+	delete(c.invalid, key)
+	metrics.InvalidDomains.Set(float64(len(c.invalid)))
+}
@@ -0,0 +1,242 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yahoo/athenz/clients/go/zms"
+
+	"istio.io/api/rbac/v1alpha1"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	adv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/apis/athenz/v1"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/onboarding"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/retry"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateDomain(t *testing.T) {
+	good := &knownGoodState{
+		model:  athenz.ConvertAthenzPoliciesIntoRbacModel(newCacheDomain()),
+		signed: true,
+	}
+
+	tests := []struct {
+		name         string
+		signedDomain func() zms.SignedDomain
+		good         *knownGoodState
+		current      int
+		allowEmpty   bool
+		expected     string
+	}{
+		{
+			name:         "should accept a valid domain without a known good state",
+			signedDomain: func() zms.SignedDomain { return zms.SignedDomain{Domain: newCacheDomain()} },
+		},
+		{
+			name:         "should accept a valid signed domain",
+			signedDomain: func() zms.SignedDomain { return zms.SignedDomain{Domain: newCacheDomain(), Signature: "signature"} },
+			good:         good,
+		},
+		{
+			name:         "should reject a missing domain",
+			signedDomain: func() zms.SignedDomain { return zms.SignedDomain{} },
+			expected:     "the domain is missing",
+		},
+		{
+			name: "should reject missing policies",
+			signedDomain: func() zms.SignedDomain {
+				domain := newCacheDomain()
+				domain.Policies.Contents = nil
+				return zms.SignedDomain{Domain: domain}
+			},
+			expected: "the domain policies are missing",
+		},
+		{
+			name: "should reject a domain which lost all its roles",
+			signedDomain: func() zms.SignedDomain {
+				domain := newCacheDomain()
+				domain.Roles = nil
+				return zms.SignedDomain{Domain: domain, Signature: "signature"}
+			},
+			good:     good,
+			expected: "the domain has no roles where it had 1",
+		},
+		{
+			name: "should reject a domain which lost all its assertions",
+			signedDomain: func() zms.SignedDomain {
+				domain := newCacheDomain()
+				domain.Policies.Contents.Policies = nil
+				return zms.SignedDomain{Domain: domain, Signature: "signature"}
+			},
+			good:     good,
+			expected: "the domain has no policy assertions where 1 roles had some",
+		},
+		{
+			name: "should reject an empty domain without a known good state where resources exist",
+			signedDomain: func() zms.SignedDomain {
+				domain := newCacheDomain()
+				domain.Roles = nil
+				return zms.SignedDomain{Domain: domain}
+			},
+			current:  2,
+			expected: "the domain has no roles where 2 Istio RBAC resources exist in its namespace",
+		},
+		{
+			name: "should reject a domain without assertions nor known good state where resources exist",
+			signedDomain: func() zms.SignedDomain {
+				domain := newCacheDomain()
+				domain.Policies.Contents.Policies = nil
+				return zms.SignedDomain{Domain: domain}
+			},
+			current:  2,
+			expected: "the domain has no policy assertions where 2 Istio RBAC resources exist in its namespace",
+		},
+		{
+			name: "should accept an empty domain without a known good state nor resources",
+			signedDomain: func() zms.SignedDomain {
+				domain := newCacheDomain()
+				domain.Roles = nil
+				return zms.SignedDomain{Domain: domain}
+			},
+		},
+		{
+			name: "should accept an empty domain without a known good state if allowed",
+			signedDomain: func() zms.SignedDomain {
+				domain := newCacheDomain()
+				domain.Roles = nil
+				return zms.SignedDomain{Domain: domain}
+			},
+			current:    2,
+			allowEmpty: true,
+		},
+		{
+			name: "should accept a domain which lost all its roles if allowed",
+			signedDomain: func() zms.SignedDomain {
+				domain := newCacheDomain()
+				domain.Roles = nil
+				return zms.SignedDomain{Domain: domain, Signature: "signature"}
+			},
+			good:       good,
+			allowEmpty: true,
+		},
+		{
+			name:         "should reject a domain which lost its signature",
+			signedDomain: func() zms.SignedDomain { return zms.SignedDomain{Domain: newCacheDomain()} },
+			good:         good,
+			expected:     "the domain signature is missing where it was signed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signedDomain := tt.signedDomain()
+			err := validateDomain(signedDomain, athenz.ConvertAthenzPoliciesIntoRbacModel(signedDomain.Domain), tt.good, tt.current, tt.allowEmpty)
+			if tt.expected == "" {
+				assert.Nil(t, err, "error should be nil")
+				return
+			}
+			assert.Equal(t, tt.expected, err.Error(), "error should be equal")
+		})
+	}
+}
+
+func TestSyncInvalidDomain(t *testing.T) {
+	key := "test-namespace/test.namespace"
+	c := newDebugController(t)
+	c.crcController = onboarding.NewController(c.configStoreCache, c.namespaceIndexInformer, c.namespaceIndexInformer, c.processor, retry.NewDeadLetters(), nil, onboarding.Options{
+		DNSSuffix:      "svc.cluster.local",
+		Mode:           v1alpha1.RbacConfig_ON_WITH_INCLUSION,
+		ResyncInterval: time.Hour,
+		Debounce:       time.Second,
+		RetryPolicy:    retry.DefaultPolicy(),
+	})
+	recorder := record.NewFakeRecorder(2)
+	c.recorder = recorder
+	athenzDomainRaw, _, err := c.adIndexInformer.GetIndexer().GetByKey(key)
+	assert.Nil(t, err, "error should be nil")
+	athenzDomain := athenzDomainRaw.(*adv1.AthenzDomain)
+
+	missing := athenzDomain.DeepCopy()
+	missing.Spec.SignedDomain.Domain = nil
+	assert.Nil(t, c.adIndexInformer.GetIndexer().Update(missing), "updating the athenz domain should return nil")
+	err = c.sync(key)
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, "Warning InvalidDomain the domain is missing", <-recorder.Events, "event should be equal")
+	_, exists := c.processor.BatchStatus(key)
+	assert.False(t, exists, "no batch should be processed for an invalid domain without a known good state")
+
+	assert.Nil(t, c.adIndexInformer.GetIndexer().Update(athenzDomain), "updating the athenz domain should return nil")
+	err = c.sync(key)
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, 0, len(c.invalid), "valid domain should clear the invalid condition")
+	goodRBAC, goodCRs := c.getDesiredState(key, athenzDomain.Spec.SignedDomain.Domain, nil)
+
+	emptied := athenzDomain.DeepCopy()
+	emptied.Spec.SignedDomain.Domain.Roles = nil
+	assert.Nil(t, c.adIndexInformer.GetIndexer().Update(emptied), "updating the athenz domain should return nil")
+	err = c.sync(key)
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, "Warning InvalidDomain the domain has no roles where it had 1", <-recorder.Events, "event should be equal")
+
+	domainRBAC, desiredCRs, known, err := c.knownGoodState(key, emptied.Spec.SignedDomain, athenz.Model{}, nil)
+	assert.True(t, known, "known good state should exist")
+	assert.NotNil(t, err, "error should not be nil")
+	assert.Equal(t, goodRBAC, domainRBAC, "model should be the known good one")
+	assert.Equal(t, goodCRs, desiredCRs, "desired configs should be the known good ones")
+
+	state, _, err := c.DomainState(key)
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, "the domain has no roles where it had 1", state.Invalid, "invalid reason should be equal")
+	assert.Equal(t, goodCRs, state.Desired, "desired configs should be the known good ones")
+
+	err = c.sync(key)
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, 0, len(recorder.Events), "event should only be recorded when the reason changes")
+}
+
+func TestSyncEmptiedDomainAfterRestart(t *testing.T) {
+	key := "test-namespace/test.namespace"
+	c := newDebugController(t)
+	c.crcController = onboarding.NewController(c.configStoreCache, c.namespaceIndexInformer, c.namespaceIndexInformer, c.processor, retry.NewDeadLetters(), nil, onboarding.Options{
+		DNSSuffix:      "svc.cluster.local",
+		Mode:           v1alpha1.RbacConfig_ON_WITH_INCLUSION,
+		ResyncInterval: time.Hour,
+		Debounce:       time.Second,
+		RetryPolicy:    retry.DefaultPolicy(),
+	})
+	recorder := record.NewFakeRecorder(1)
+	c.recorder = recorder
+	athenzDomainRaw, _, err := c.adIndexInformer.GetIndexer().GetByKey(key)
+	assert.Nil(t, err, "error should be nil")
+	athenzDomain := athenzDomainRaw.(*adv1.AthenzDomain)
+
+	_, desiredCRs := c.getDesiredState(key, athenzDomain.Spec.SignedDomain.Domain, nil)
+	for _, config := range desiredCRs {
+		_, err := c.configStoreCache.Create(config)
+		assert.Nil(t, err, "creating the config should return nil")
+	}
+
+	emptied := athenzDomain.DeepCopy()
+	emptied.Spec.SignedDomain.Domain.Roles = nil
+	assert.Nil(t, c.adIndexInformer.GetIndexer().Update(emptied), "updating the athenz domain should return nil")
+	err = c.sync(key)
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, "Warning InvalidDomain the domain has no roles where 2 Istio RBAC resources exist in its namespace", <-recorder.Events, "event should be equal")
+	_, exists := c.processor.BatchStatus(key)
+	assert.False(t, exists, "no batch should be processed for an emptied domain without a known good state")
+
+	ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-namespace", Annotations: map[string]string{allowEmptyAnnotation: "true"}}}
+	assert.Nil(t, c.namespaceIndexInformer.GetIndexer().Add(ns), "adding the namespace should return nil")
+	_, _, known, err := c.knownGoodState(key, emptied.Spec.SignedDomain, athenz.ConvertAthenzPoliciesIntoRbacModel(emptied.Spec.SignedDomain.Domain), nil)
+	assert.Nil(t, err, "error should be nil if emptying the domain is allowed")
+	assert.True(t, known, "state should be known")
+}
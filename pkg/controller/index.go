@@ -0,0 +1,175 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package controller
+
+import (
+	"errors"
+	"fmt"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	adv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/apis/athenz/v1"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+)
+
+const (
+	domainIndex           = "domain"
+	targetNamespaceIndex  = "targetNamespace"
+	duplicateTargetReason = "DuplicateTarget"
+)
+
+// adIndexers returns the indexers of the Athenz Domain informer, keyed by the
+// name of the athenz domain held by the spec and by the namespace its Istio
+// RBAC resources are written to, so that the name and namespace of the
+// AthenzDomain resource do not matter
+func adIndexers() cache.Indexers {
+	return cache.Indexers{
+		domainIndex:          domainIndexFunc,
+		targetNamespaceIndex: targetNamespaceIndexFunc,
+	}
+}
+
+// specDomainName returns the name of the athenz domain held by the spec of an
+// AthenzDomain, empty if the spec holds no domain
+func specDomainName(obj interface{}) (string, error) {
+	athenzDomain, ok := obj.(*adv1.AthenzDomain)
+	if !ok {
+		return "", errors.New("athenz domain cast failed")
+	}
+	if athenzDomain.Spec.SignedDomain.Domain == nil {
+		return "", nil
+	}
+	return string(athenzDomain.Spec.SignedDomain.Domain.Name), nil
+}
+
+// domainIndexFunc indexes an AthenzDomain by the name of its athenz domain
+func domainIndexFunc(obj interface{}) ([]string, error) {
+	domain, err := specDomainName(obj)
+	if err != nil || domain == "" {
+		return nil, err
+	}
+	return []string{domain}, nil
+}
+
+// targetNamespaceIndexFunc indexes an AthenzDomain by the namespace its Istio
+// RBAC resources are written to
+func targetNamespaceIndexFunc(obj interface{}) ([]string, error) {
+	domain, err := specDomainName(obj)
+	if err != nil || domain == "" {
+		return nil, err
+	}
+	return []string{athenz.DomainToNamespace(domain)}, nil
+}
+
+// indexOwner returns the queue key of the AthenzDomain owning the value of an
+// index, and whether any AthenzDomain matches it. When several AthenzDomains
+// match, the oldest one owns the value, the one with the lowest key for equal
+// creation timestamps, so that duplicates are rejected deterministically.
+func (c *Controller) indexOwner(index, value string) (string, bool) {
+	objs, err := c.adIndexInformer.GetIndexer().ByIndex(index, value)
+	if err != nil {
+		logger.WithError(err).Errorf("Error looking up the athenz domains of %s %s", index, value)
+		return "", false
+	}
+
+	var owner *adv1.AthenzDomain
+	ownerKey := ""
+	for _, obj := range objs {
+		athenzDomain, ok := obj.(*adv1.AthenzDomain)
+		if !ok {
+			continue
+		}
+		key, err := cache.MetaNamespaceKeyFunc(athenzDomain)
+		if err != nil {
+			logger.WithError(err).Errorf("indexOwner(): Error calling key func")
+			continue
+		}
+		if owner == nil || athenzDomain.CreationTimestamp.Before(&owner.CreationTimestamp) ||
+			(athenzDomain.CreationTimestamp.Equal(&owner.CreationTimestamp) && key < ownerKey) {
+			owner, ownerKey = athenzDomain, key
+		}
+	}
+	return ownerKey, owner != nil
+}
+
+// namespaceKey returns the queue key of the AthenzDomain owning the namespace
+// among those whose Istio RBAC resources are written to it. If there are none,
+// the key of the AthenzDomain named after the domain in the namespace is
+// returned, so that the sync of a missing domain still runs.
+func (c *Controller) namespaceKey(namespace string) string {
+	if key, found := c.indexOwner(targetNamespaceIndex, namespace); found {
+		return key
+	}
+	return namespace + "/" + athenz.NamespaceToDomain(namespace)
+}
+
+// domainKey returns the queue key of the AthenzDomain owning the athenz domain
+// among those holding it, or of the AthenzDomain named after it in its
+// namespace if none does
+func (c *Controller) domainKey(domain string) string {
+	if key, found := c.indexOwner(domainIndex, domain); found {
+		return key
+	}
+	return athenz.DomainToNamespace(domain) + "/" + domain
+}
+
+// duplicateOf returns the queue key of the AthenzDomain owning the target
+// namespace of an AthenzDomain, and whether it is another one
+func (c *Controller) duplicateOf(key string, athenzDomain *adv1.AthenzDomain) (string, bool) {
+	domain, err := specDomainName(athenzDomain)
+	if err != nil || domain == "" {
+		return "", false
+	}
+	owner, found := c.indexOwner(targetNamespaceIndex, athenz.DomainToNamespace(domain))
+	return owner, found && owner != key
+}
+
+// reportDuplicate logs, records an event and writes the status of an athenz
+// domain whose target namespace is owned by another athenz domain
+func (c *Controller) reportDuplicate(key string, athenzDomain *adv1.AthenzDomain, owner string) {
+	message := fmt.Sprintf("the target namespace is already owned by the athenz domain %s", owner)
+	domainLogger(key).Warningf("Refusing to sync the domain: %s", message)
+	if c.recorder != nil {
+		c.recorder.Event(newDomainReference(athenzDomain), v1.EventTypeWarning, duplicateTargetReason, message)
+	}
+	c.updateDomainStatus(athenzDomain, duplicateTargetReason, message)
+}
+
+// keyTarget returns the athenz domain of a queue key and the namespace its
+// Istio RBAC resources are written to, from the AthenzDomain in the informer
+// cache, else from the cached desired state of the key or else from the key
+func (c *Controller) keyTarget(key string) (string, string, error) {
+	obj, exists, err := c.adIndexInformer.GetIndexer().GetByKey(key)
+	if err == nil && exists {
+		if domain, err := specDomainName(obj); err == nil && domain != "" {
+			return domain, athenz.DomainToNamespace(domain), nil
+		}
+	}
+
+	c.desiredLock.Lock()
+	state, exists := c.desired[key]
+	c.desiredLock.Unlock()
+	if exists && state.model.Name != "" {
+		return string(state.model.Name), state.model.Namespace, nil
+	}
+
+	namespace, domain, err := cache.SplitMetaNamespaceKey(key)
+	return domain, namespace, err
+}
+
+// targetNamespace returns the namespace the Istio RBAC resources of a queue
+// key were last written to, from its cached desired state if any or else from
+// the key
+func (c *Controller) targetNamespace(key string) (string, error) {
+	c.desiredLock.Lock()
+	state, exists := c.desired[key]
+	c.desiredLock.Unlock()
+	if exists && state.model.Namespace != "" {
+		return state.model.Namespace, nil
+	}
+
+	namespace, _, err := cache.SplitMetaNamespaceKey(key)
+	return namespace, err
+}
@@ -0,0 +1,190 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package controller
+
+import (
+	"testing"
+
+	"istio.io/istio/pilot/pkg/config/memory"
+	"istio.io/istio/pilot/pkg/model"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	adv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/apis/athenz/v1"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/client/clientset/versioned/fake"
+	rbacv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/v1"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newIndexedController returns a controller holding an AthenzDomain named
+// custom in the athenz-domains namespace, for the test.namespace domain
+func newIndexedController(t *testing.T) *Controller {
+	c := newScopedController(t, nil)
+	athenzDomain := &adv1.AthenzDomain{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "custom",
+			Namespace: "athenz-domains",
+		},
+	}
+	athenzDomain.Spec.SignedDomain.Domain = newCacheDomain()
+	assert.Nil(t, c.adIndexInformer.GetIndexer().Add(athenzDomain), "adding the athenz domain should return nil")
+	return c
+}
+
+func TestIndexKeys(t *testing.T) {
+	c := newIndexedController(t)
+
+	tests := []struct {
+		name     string
+		keys     func() []string
+		expected []string
+	}{
+		{
+			name:     "should look up an athenz domain by the name of its domain",
+			keys:     func() []string { return []string{c.domainKey("test.namespace")} },
+			expected: []string{"athenz-domains/custom"},
+		},
+		{
+			name:     "should look up an athenz domain by its target namespace",
+			keys:     func() []string { return []string{c.namespaceKey("test-namespace")} },
+			expected: []string{"athenz-domains/custom"},
+		},
+		{
+			name:     "should fall back to the naming convention for an unknown domain",
+			keys:     func() []string { return []string{c.domainKey("other.namespace")} },
+			expected: []string{"other-namespace/other.namespace"},
+		},
+		{
+			name:     "should fall back to the naming convention for an unknown namespace",
+			keys:     func() []string { return []string{c.namespaceKey("other-namespace")} },
+			expected: []string{"other-namespace/other.namespace"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.keys(), "keys should be equal")
+		})
+	}
+}
+
+func TestIndexOwner(t *testing.T) {
+	c := newIndexedController(t)
+	newer := &adv1.AthenzDomain{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "newer",
+			Namespace:         "athenz-domains",
+			CreationTimestamp: metav1.Now(),
+		},
+	}
+	newer.Spec.SignedDomain.Domain = newCacheDomain()
+	assert.Nil(t, c.adIndexInformer.GetIndexer().Add(newer), "adding the athenz domain should return nil")
+	assert.Equal(t, "athenz-domains/custom", c.namespaceKey("test-namespace"), "oldest athenz domain should own the namespace")
+	assert.Equal(t, "athenz-domains/custom", c.domainKey("test.namespace"), "oldest athenz domain should own the domain")
+
+	owner, duplicate := c.duplicateOf("athenz-domains/newer", newer)
+	assert.True(t, duplicate, "newer athenz domain should be a duplicate")
+	assert.Equal(t, "athenz-domains/custom", owner, "owner should be equal")
+	_, duplicate = c.duplicateOf("test-namespace/test.namespace", ad)
+	assert.False(t, duplicate, "athenz domain without a domain should not be a duplicate")
+
+	same := newer.DeepCopy()
+	same.Name = "a-custom"
+	same.CreationTimestamp = metav1.Time{}
+	assert.Nil(t, c.adIndexInformer.GetIndexer().Add(same), "adding the athenz domain should return nil")
+	assert.Equal(t, "athenz-domains/a-custom", c.namespaceKey("test-namespace"), "lowest key should own the namespace for equal creation timestamps")
+}
+
+func TestSyncDuplicate(t *testing.T) {
+	c := newIndexedController(t)
+	c.rbacProvider = rbacv1.NewProvider()
+	c.configStoreCache = memory.NewController(memory.Make(model.ConfigDescriptor{model.ServiceRole, model.ServiceRoleBinding}))
+	recorder := record.NewFakeRecorder(1)
+	c.recorder = recorder
+	duplicate := &adv1.AthenzDomain{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "duplicate",
+			Namespace:         "athenz-domains",
+			CreationTimestamp: metav1.Now(),
+		},
+	}
+	duplicate.Spec.SignedDomain.Domain = newCacheDomain()
+	assert.Nil(t, c.adIndexInformer.GetIndexer().Add(duplicate), "adding the athenz domain should return nil")
+	adClient := fake.NewSimpleClientset()
+	_, err := adClient.AthenzV1().AthenzDomains("athenz-domains").Create(duplicate.DeepCopy())
+	assert.Nil(t, err, "creating the athenz domain should return nil")
+	c.adClient = adClient
+
+	err = c.sync("athenz-domains/duplicate")
+	assert.Nil(t, err, "error should be nil")
+	_, exists := c.processor.BatchStatus("athenz-domains/duplicate")
+	assert.False(t, exists, "no batch should be processed for a duplicate athenz domain")
+	assert.Equal(t, "Warning DuplicateTarget the target namespace is already owned by the athenz domain athenz-domains/custom", <-recorder.Events, "event should be equal")
+	updated, err := adClient.AthenzV1().AthenzDomains("athenz-domains").Get("duplicate", metav1.GetOptions{})
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, "DuplicateTarget: the target namespace is already owned by the athenz domain athenz-domains/custom", updated.Status.Message, "status should report the duplicate")
+
+	state, _, err := c.DomainState("athenz-domains/duplicate")
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, "athenz-domains/custom", state.DuplicateOf, "duplicate owner should be equal")
+
+	owner, _, _ := c.adIndexInformer.GetIndexer().GetByKey("athenz-domains/custom")
+	assert.Nil(t, c.adIndexInformer.GetIndexer().Delete(owner), "deleting the athenz domain should return nil")
+	c.getDesiredState("athenz-domains/custom", newCacheDomain(), nil)
+	err = c.sync("athenz-domains/custom")
+	assert.Nil(t, err, "error should be nil for a deleted athenz domain so that it is not retried")
+	assert.Equal(t, 1, c.queue.Len(), "remaining athenz domain should be queued to take the namespace over")
+	item, _ := c.queue.Get()
+	assert.Equal(t, "athenz-domains/duplicate", item, "key should be the one of the remaining athenz domain")
+}
+
+func TestIndexFuncs(t *testing.T) {
+	athenzDomain := ad.DeepCopy()
+	values, err := domainIndexFunc(athenzDomain)
+	assert.Nil(t, err, "error should be nil")
+	assert.Nil(t, values, "athenz domain without a domain should not be indexed")
+
+	athenzDomain.Spec.SignedDomain.Domain = newCacheDomain()
+	values, err = domainIndexFunc(athenzDomain)
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, []string{"test.namespace"}, values, "domain index should be equal")
+	values, err = targetNamespaceIndexFunc(athenzDomain)
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, []string{"test-namespace"}, values, "target namespace index should be equal")
+
+	_, err = domainIndexFunc("not an athenz domain")
+	assert.NotNil(t, err, "error should not be nil")
+}
+
+func TestProcessConfigEventIndexed(t *testing.T) {
+	c := newIndexedController(t)
+	config := model.Config{
+		ConfigMeta: model.ConfigMeta{
+			Type:      model.ServiceRole.Type,
+			Name:      "test",
+			Namespace: "test-namespace",
+		},
+	}
+
+	c.processConfigEvent(config, model.EventAdd)
+	assert.Equal(t, 1, c.queue.Len(), "queue length should be 1")
+	item, _ := c.queue.Get()
+	assert.Equal(t, "athenz-domains/custom", item, "key should be the one of the athenz domain targeting the namespace")
+}
+
+func TestTargetNamespace(t *testing.T) {
+	c := newIndexedController(t)
+	namespace, err := c.targetNamespace("athenz-domains/custom")
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, "athenz-domains", namespace, "namespace should be the one of the key without a desired state")
+
+	c.rbacProvider = rbacv1.NewProvider()
+	c.getDesiredState("athenz-domains/custom", newCacheDomain(), nil)
+	namespace, err = c.targetNamespace("athenz-domains/custom")
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, athenz.DomainToNamespace("test.namespace"), namespace, "namespace should be the one of the desired state")
+}
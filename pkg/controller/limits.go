@@ -0,0 +1,109 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package controller
+
+import (
+	"fmt"
+
+	"istio.io/istio/pilot/pkg/model"
+
+	"k8s.io/api/core/v1"
+
+	adv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/apis/athenz/v1"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/processor"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/limits"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
+)
+
+const (
+	approveDeletionsAnnotation = "authz.istio.io/approve-deletions"
+	deletionsHeldReason        = "DeletionsHeld"
+)
+
+// deletionKeys returns the keys of the resources deleted by a change list
+func deletionKeys(changeList []*processor.Item) []string {
+	keys := make([]string, 0)
+	for _, item := range changeList {
+		if item.Operation == model.EventDelete {
+			keys = append(keys, item.Resource.Key())
+		}
+	}
+	return keys
+}
+
+// approvedDeletions returns the deletion approval token set on the namespace
+func (c *Controller) approvedDeletions(namespace string) string {
+	namespaceRaw, exists, err := c.namespaceIndexInformer.GetIndexer().GetByKey(namespace)
+	if err != nil || !exists {
+		return ""
+	}
+
+	ns, ok := namespaceRaw.(*v1.Namespace)
+	if !ok {
+		logger.WithNamespace(namespace).Errorf("Could not cast to namespace object")
+		return ""
+	}
+	return ns.Annotations[approveDeletionsAnnotation]
+}
+
+// heldDeletions returns the approval token and the exceeded limit if the
+// deletions of the change list exceed the limits and were not approved with
+// the token on the namespace, or an empty token otherwise
+func (c *Controller) heldDeletions(namespace string, changeList []*processor.Item, total int) (string, error) {
+	keys := deletionKeys(changeList)
+	err := c.deletionLimits.Check(len(keys), total)
+	if err == nil {
+		return "", nil
+	}
+
+	token := limits.Token(keys)
+	if c.approvedDeletions(namespace) == token {
+		return "", err
+	}
+	return token, err
+}
+
+// holdDeletions returns true if the deletions of the change list are held.
+// The held change is logged, counted and recorded as an event on the athenz
+// domain with the token approving it.
+func (c *Controller) holdDeletions(key string, athenzDomain *adv1.AthenzDomain, namespace string, changeList []*processor.Item, total int) bool {
+	token, err := c.heldDeletions(namespace, changeList, total)
+	if err == nil {
+		return false
+	}
+	if token == "" {
+		domainLogger(key).WithError(err).Infof("Deletions were approved on the namespace, applying them")
+		return false
+	}
+
+	message := fmt.Sprintf("%s, annotate the namespace with %s=%s to apply them", err.Error(), approveDeletionsAnnotation, token)
+	domainLogger(key).Warningf("Holding the sync of the domain: %s", message)
+	metrics.HeldChanges.WithLabelValues(metrics.ControllerDomain).Inc()
+	if c.recorder != nil {
+		c.recorder.Event(newDomainReference(athenzDomain), v1.EventTypeWarning, deletionsHeldReason, message)
+	}
+	return true
+}
+
+// approvalChanged queues the athenz domain owning a namespace whose deletion
+// approval token, allow empty or preview annotation changed
+func (c *Controller) approvalChanged(oldObj, newObj interface{}) {
+	oldNs, ok := oldObj.(*v1.Namespace)
+	if !ok {
+		return
+	}
+	newNs, ok := newObj.(*v1.Namespace)
+	if !ok {
+		return
+	}
+
+	if oldNs.Annotations[approveDeletionsAnnotation] == newNs.Annotations[approveDeletionsAnnotation] &&
+		oldNs.Annotations[allowEmptyAnnotation] == newNs.Annotations[allowEmptyAnnotation] &&
+		oldNs.Annotations[previewVersionAnnotation] == newNs.Annotations[previewVersionAnnotation] {
+		return
+	}
+	if key := c.namespaceKey(newNs.Name); c.configInScope(key) {
+		c.queue.Add(key)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package controller
+
+import (
+	"testing"
+
+	"istio.io/istio/pilot/pkg/model"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/processor"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/limits"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHoldDeletions(t *testing.T) {
+	changeList := []*processor.Item{
+		{Operation: model.EventAdd, Resource: newSr("test-namespace", "added-role")},
+		{Operation: model.EventDelete, Resource: newSr("test-namespace", "deleted-role")},
+		{Operation: model.EventDelete, Resource: newSrb("test-namespace", "deleted-role")},
+	}
+	token := limits.Token(deletionKeys(changeList))
+
+	tests := []struct {
+		name          string
+		limits        limits.Limits
+		approval      string
+		expectedHeld  bool
+		expectedEvent string
+	}{
+		{
+			name:   "should not hold deletions without limits",
+			limits: limits.Limits{},
+		},
+		{
+			name:   "should not hold deletions within the limits",
+			limits: limits.Limits{MaxRemovals: 2},
+		},
+		{
+			name:          "should hold deletions exceeding the limits",
+			limits:        limits.Limits{MaxRemovalPercent: 50},
+			expectedHeld:  true,
+			expectedEvent: "Warning DeletionsHeld 2 removals of 3 items exceed the limit of 50% of the items, annotate the namespace with " + approveDeletionsAnnotation + "=" + token + " to apply them",
+		},
+		{
+			name:          "should hold deletions approved with another token",
+			limits:        limits.Limits{MaxRemovals: 1},
+			approval:      "0000000000000000",
+			expectedHeld:  true,
+			expectedEvent: "Warning DeletionsHeld 2 removals of 3 items exceed the limit of 1 removals, annotate the namespace with " + approveDeletionsAnnotation + "=" + token + " to apply them",
+		},
+		{
+			name:     "should not hold approved deletions",
+			limits:   limits.Limits{MaxRemovals: 1},
+			approval: token,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newScopedController(t, nil)
+			c.deletionLimits = tt.limits
+			recorder := record.NewFakeRecorder(1)
+			c.recorder = recorder
+			namespace := &v1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-namespace",
+					Annotations: map[string]string{approveDeletionsAnnotation: tt.approval},
+				},
+			}
+			assert.Nil(t, c.namespaceIndexInformer.GetIndexer().Update(namespace), "updating the namespace should return nil")
+
+			held := c.holdDeletions("test-namespace/test.namespace", ad, "test-namespace", changeList, 3)
+			assert.Equal(t, tt.expectedHeld, held, "held should be equal")
+			if tt.expectedEvent == "" {
+				assert.Equal(t, 0, len(recorder.Events), "no event should be recorded")
+				return
+			}
+			assert.Equal(t, tt.expectedEvent, <-recorder.Events, "event should be equal")
+		})
+	}
+}
+
+func TestApprovalChanged(t *testing.T) {
+	c := newScopedController(t, nil)
+	oldNs := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-namespace"}}
+	newNs := oldNs.DeepCopy()
+	newNs.Labels = map[string]string{"team": "b"}
+
+	c.approvalChanged(oldNs, newNs)
+	assert.Equal(t, 0, c.queue.Len(), "queue length should be 0 if the approval did not change")
+
+	newNs.Annotations = map[string]string{approveDeletionsAnnotation: "0000000000000000"}
+	c.approvalChanged(oldNs, newNs)
+	assert.Equal(t, 1, c.queue.Len(), "queue length should be 1 if the approval changed")
+	item, _ := c.queue.Get()
+	assert.Equal(t, "test-namespace/test.namespace", item, "key should be equal")
+	c.queue.Done(item)
+
+	oldNs = newNs.DeepCopy()
+	newNs.Annotations = map[string]string{approveDeletionsAnnotation: "0000000000000000", allowEmptyAnnotation: "true"}
+	c.approvalChanged(oldNs, newNs)
+	assert.Equal(t, 1, c.queue.Len(), "queue length should be 1 if the allow empty annotation changed")
+	item, _ = c.queue.Get()
+	c.queue.Done(item)
+
+	oldNs = newNs.DeepCopy()
+	newNs.Annotations = map[string]string{approveDeletionsAnnotation: "0000000000000000", allowEmptyAnnotation: "true", previewVersionAnnotation: "1"}
+	c.approvalChanged(oldNs, newNs)
+	assert.Equal(t, 1, c.queue.Len(), "queue length should be 1 if the preview annotation changed")
+}
@@ -0,0 +1,60 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/audit"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/config"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/filter"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/onboarding"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/limits"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/retry"
+)
+
+// Options configures the controller and its dependencies
+type Options struct {
+	ADResyncInterval time.Duration
+	SkipUnchanged    bool
+	Workers          int
+	ProcessorWorkers int
+	WriteQPS         float32
+	WriteBurst       int
+	RetryPolicy      retry.Policy
+	Filter           *filter.Filter
+	Protected        filter.Protected
+	DeletionLimits   limits.Limits
+	AuditLog         *audit.Logger
+	Onboarding       onboarding.Options
+}
+
+// NewOptions returns the options of the controller and of its onboarding
+// controller from the config, the audit logger is left to the caller
+func NewOptions(cfg *config.Config) (Options, error) {
+	onboardingOpts, err := onboarding.NewOptions(cfg)
+	if err != nil {
+		return Options{}, err
+	}
+
+	namespaceFilter, err := cfg.NamespaceFilter()
+	if err != nil {
+		return Options{}, fmt.Errorf("error parsing the filter: %s", err.Error())
+	}
+
+	return Options{
+		ADResyncInterval: cfg.AthenzDomains.ResyncInterval.Duration,
+		SkipUnchanged:    cfg.AthenzDomains.SkipUnchanged,
+		Workers:          cfg.AthenzDomains.Workers,
+		ProcessorWorkers: cfg.Processor.Workers,
+		WriteQPS:         float32(cfg.Kube.QPS),
+		WriteBurst:       cfg.Kube.Burst,
+		RetryPolicy:      cfg.RetryPolicy(),
+		Filter:           namespaceFilter,
+		Protected:        filter.NewProtected(cfg.ProtectedNamespaces),
+		DeletionLimits:   cfg.DeletionLimits(),
+		Onboarding:       onboardingOpts,
+	}, nil
+}
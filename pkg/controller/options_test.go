@@ -0,0 +1,41 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"istio.io/api/rbac/v1alpha1"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/config"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/limits"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewOptions(t *testing.T) {
+	cfg := config.Default()
+	cfg.ClusterRbacConfig.Mode = "ON_WITH_EXCLUSION"
+	cfg.AthenzDomains.Workers = 4
+	cfg.Processor.Workers = 2
+	cfg.ClusterRbacConfig.Debounce.Duration = 3 * time.Second
+	cfg.Filter.NamespaceExclude = []string{"kube-system"}
+	cfg.Limits.MaxDeletions = 5
+	cfg.Limits.MaxServiceRemovals = 7
+
+	opts, err := NewOptions(cfg)
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, v1alpha1.RbacConfig_ON_WITH_EXCLUSION, opts.Onboarding.Mode, "mode should be equal")
+	assert.Equal(t, 4, opts.Workers, "workers should be equal")
+	assert.Equal(t, 2, opts.ProcessorWorkers, "processor workers should be equal")
+	assert.Equal(t, 3*time.Second, opts.Onboarding.Debounce, "debounce should be equal")
+	assert.False(t, opts.Filter.Empty(), "filter should not be empty")
+	assert.Equal(t, limits.Limits{MaxRemovals: 5}, opts.DeletionLimits, "deletion limits should be equal")
+	assert.Equal(t, limits.Limits{MaxRemovals: 7}, opts.Onboarding.RemovalLimits, "service removal limits should be equal")
+
+	cfg.ClusterRbacConfig.Mode = "OFF"
+	_, err = NewOptions(cfg)
+	assert.NotNil(t, err, "error should not be nil for an invalid mode")
+}
@@ -0,0 +1,151 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"istio.io/istio/pilot/pkg/model"
+
+	"k8s.io/api/core/v1"
+
+	adv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/apis/athenz/v1"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/processor"
+)
+
+const previewVersionAnnotation = "authz.istio.io/preview-policy-version"
+
+// PreviewState is the shadow set of Istio RBAC resources generated from a
+// policy version of a domain, which is never written
+type PreviewState struct {
+	Version string          `json:"version"`
+	Model   athenz.Model    `json:"model"`
+	Desired []model.Config  `json:"desired"`
+	Pending []PendingChange `json:"pending"`
+}
+
+// previewVersion returns the policy version to preview for the domains of the
+// namespace, set with the preview annotation
+func (c *Controller) previewVersion(namespace string) string {
+	namespaceRaw, exists, err := c.namespaceIndexInformer.GetIndexer().GetByKey(namespace)
+	if err != nil || !exists {
+		return ""
+	}
+
+	ns, ok := namespaceRaw.(*v1.Namespace)
+	if !ok {
+		logger.WithNamespace(namespace).Errorf("Could not cast to namespace object")
+		return ""
+	}
+	return ns.Annotations[previewVersionAnnotation]
+}
+
+// previewState returns the shadow set of resources generated from the preview
+// version of the domain policies and the changes it would apply to the current
+// resources, or nil if no version is previewed for the namespace of the domain.
+// The policies without the preview version use their active version.
+func (c *Controller) previewState(athenzDomain *adv1.AthenzDomain, namespace string, currentCRs []model.Config) *PreviewState {
+	version := c.previewVersion(namespace)
+	if version == "" {
+		return nil
+	}
+
+	spec := athenzDomain.Spec
+	previewRBAC := athenz.ConvertVersionedPoliciesIntoRbacModel(spec.SignedDomain.Domain, spec.PolicyVersions, version)
+	previewCRs := c.rbacProvider.ConvertAthenzModelIntoIstioRbac(previewRBAC)
+	state := &PreviewState{
+		Version: version,
+		Model:   previewRBAC,
+		Desired: previewCRs,
+		Pending: make([]PendingChange, 0),
+	}
+	for _, item := range computeChangeList(currentCRs, previewCRs, nil) {
+		state.Pending = append(state.Pending, PendingChange{
+			Operation: item.Operation.String(),
+			Key:       item.Resource.Key(),
+			Resource:  item.Resource,
+		})
+	}
+	return state
+}
+
+// getPreviewed returns the resource version and policy version last previewed
+// for a domain
+func (c *Controller) getPreviewed(key string) (string, bool) {
+	c.appliedLock.Lock()
+	defer c.appliedLock.Unlock()
+	previewed, exists := c.previewed[key]
+	return previewed, exists
+}
+
+// recordPreviewed records the resource version and policy version previewed
+// for a domain
+func (c *Controller) recordPreviewed(key, previewed string) {
+	c.appliedLock.Lock()
+	defer c.appliedLock.Unlock()
+	c.previewed[key] = previewed
+}
+
+// forgetPreviewed removes the preview of a domain which is deleted or no
+// longer previewed
+func (c *Controller) forgetPreviewed(key string) {
+	c.appliedLock.Lock()
+	defer c.appliedLock.Unlock()
+	delete(c.previewed, key)
+}
+
+// newPreviewChange returns the audit record of the changes a dry-run of the
+// preview version of a domain would apply
+func newPreviewChange(athenzDomain *adv1.AthenzDomain, domainRBAC athenz.Model, state *PreviewState, applied []*processor.Item) AccessChange {
+	diff := athenz.DiffModels(domainRBAC, state.Model)
+	change := AccessChange{
+		Time:               time.Now(),
+		Preview:            state.Version,
+		Domain:             domainRBAC.Name,
+		Namespace:          domainRBAC.Namespace,
+		OldResourceVersion: athenzDomain.ResourceVersion,
+		ResourceVersion:    athenzDomain.ResourceVersion,
+		Diff:               &diff,
+		Changes:            make([]ConfigChange, 0, len(applied)),
+	}
+	for _, item := range applied {
+		change.Changes = append(change.Changes, ConfigChange{
+			Operation: item.Operation.String(),
+			Key:       item.Resource.Key(),
+		})
+	}
+	return change
+}
+
+// dryRunPreview runs the changes of the preview version of a domain through a
+// dry-run of the processor, and audits the access change they would make
+// against the active version. A version of a domain is previewed once, the
+// resyncs do not audit it again.
+func (c *Controller) dryRunPreview(key string, athenzDomain *adv1.AthenzDomain, domainRBAC athenz.Model, currentCRs []model.Config) {
+	state := c.previewState(athenzDomain, domainRBAC.Namespace, currentCRs)
+	if state == nil {
+		c.forgetPreviewed(key)
+		return
+	}
+
+	previewed := athenzDomain.ResourceVersion + "/" + state.Version
+	if last, exists := c.getPreviewed(key); exists && last == previewed {
+		return
+	}
+	c.recordPreviewed(key, previewed)
+
+	batch := processor.NewBatch(key, computeChangeList(currentCRs, state.Desired, nil))
+	batch.OnApplied = func(applied []*processor.Item, err error) {
+		change := newPreviewChange(athenzDomain, domainRBAC, state, applied)
+		message := fmt.Sprintf("preview of policy version %s: access would change: %s, %d Istio RBAC changes would be applied",
+			state.Version, change.Diff, len(change.Changes))
+		c.recordAccessChange(athenzDomain, change, accessPreviewedReason, message)
+	}
+	status := c.processor.DryRunBatch(batch)
+	if status.Applied == 0 {
+		batch.OnApplied(nil, status.Err)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/yahoo/athenz/clients/go/zms"
+
+	"istio.io/api/rbac/v1alpha1"
+	"istio.io/istio/pilot/pkg/model"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	adv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/apis/athenz/v1"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/audit"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/onboarding"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/retry"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// previewDomain adds a draft version 1 to the first policy of the domain of
+// the key, which allows post instead of get
+func previewDomain(t *testing.T, c *Controller, key string) {
+	athenzDomainRaw, _, err := c.adIndexInformer.GetIndexer().GetByKey(key)
+	assert.Nil(t, err, "error should be nil")
+	athenzDomain := athenzDomainRaw.(*adv1.AthenzDomain).DeepCopy()
+
+	active, inactive := true, false
+	policies := athenzDomain.Spec.SignedDomain.Domain.Policies.Contents
+	draft := &zms.Policy{
+		Name: policies.Policies[0].Name,
+		Assertions: []*zms.Assertion{
+			{
+				Role:     "test.namespace:role.client",
+				Resource: "test.namespace:svc.my-service",
+				Action:   "post",
+				Effect:   policies.Policies[0].Assertions[0].Effect,
+			},
+		},
+	}
+	policies.Policies = append(policies.Policies, draft)
+	athenzDomain.Spec.PolicyVersions = adv1.PolicyVersions{
+		{Name: draft.Name, Version: "0", Active: &active, Assertions: policies.Policies[0].Assertions},
+		{Name: draft.Name, Version: "1", Active: &inactive, Assertions: draft.Assertions},
+	}
+	assert.Nil(t, c.adIndexInformer.GetIndexer().Update(athenzDomain), "updating the athenz domain should return nil")
+}
+
+func TestDomainStatePreview(t *testing.T) {
+	key := "test-namespace/test.namespace"
+	c := newDebugController(t)
+	previewDomain(t, c, key)
+
+	state, _, err := c.DomainState(key)
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, 1, len(state.Model.Rules["test.namespace:role.client"]), "inactive version should be skipped")
+	assert.Equal(t, "get", state.Model.Rules["test.namespace:role.client"][0].Action, "active version should be used")
+	assert.Nil(t, state.Preview, "preview should be nil without the preview annotation")
+
+	ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-namespace", Annotations: map[string]string{previewVersionAnnotation: "1"}}}
+	assert.Nil(t, c.namespaceIndexInformer.GetIndexer().Add(ns), "adding the namespace should return nil")
+	state, _, err = c.DomainState(key)
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, "get", state.Model.Rules["test.namespace:role.client"][0].Action, "active version should still be synced")
+	assert.NotNil(t, state.Preview, "preview should be set")
+	assert.Equal(t, "1", state.Preview.Version, "preview version should be equal")
+	assert.Equal(t, 1, len(state.Preview.Model.Rules["test.namespace:role.client"]), "preview should only use the preview version")
+	assert.Equal(t, "post", state.Preview.Model.Rules["test.namespace:role.client"][0].Action, "preview version should be used")
+	assert.Equal(t, 2, len(state.Preview.Desired), "preview configs should be converted")
+	assert.NotEqual(t, state.Desired, state.Preview.Desired, "preview configs should differ from the desired configs")
+	assert.Equal(t, 2, len(state.Preview.Pending), "preview pending changes should create the preview configs")
+	_, exists := c.processor.BatchStatus(key)
+	assert.False(t, exists, "preview should not process any batch")
+}
+
+func TestSyncDryRunsPreview(t *testing.T) {
+	key := "test-namespace/test.namespace"
+	buf := &bytes.Buffer{}
+	recorder := record.NewFakeRecorder(10)
+	c := newDebugController(t)
+	c.crcController = onboarding.NewController(c.configStoreCache, c.namespaceIndexInformer, c.namespaceIndexInformer, c.processor, retry.NewDeadLetters(), nil, onboarding.Options{
+		DNSSuffix:      "svc.cluster.local",
+		Mode:           v1alpha1.RbacConfig_ON_WITH_INCLUSION,
+		ResyncInterval: time.Hour,
+		Debounce:       time.Second,
+		RetryPolicy:    retry.DefaultPolicy(),
+	})
+	c.auditLog = audit.NewWriterLogger(buf)
+	c.recorder = recorder
+	c.synced = make(map[string]syncState)
+	previewDomain(t, c, key)
+	ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-namespace", Annotations: map[string]string{previewVersionAnnotation: "1"}}}
+	assert.Nil(t, c.namespaceIndexInformer.GetIndexer().Add(ns), "adding the namespace should return nil")
+
+	err := c.sync(key)
+	assert.Nil(t, err, "sync should return nil")
+	assert.Contains(t, <-recorder.Events, "preview of policy version 1: access would change: roles +0 -0, members +0 -0, assertions +1 -1, 2 Istio RBAC changes would be applied", "event should summarize the preview")
+	var change AccessChange
+	err = json.Unmarshal(buf.Bytes(), &change)
+	assert.Nil(t, err, "audit record should be valid json")
+	assert.Equal(t, "1", change.Preview, "preview version should be equal")
+	assert.NotNil(t, change.Diff, "diff should be set")
+	assert.Equal(t, 1, len(change.Diff.AddedAssertions), "diff should add the preview assertion")
+	assert.Equal(t, "post", change.Diff.AddedAssertions[0].Action, "added assertion should be the preview one")
+	assert.Equal(t, []ConfigChange{
+		{Operation: "add", Key: "service-role/test-namespace/client"},
+		{Operation: "add", Key: "service-role-binding/test-namespace/client"},
+	}, change.Changes, "changes should be the ones of the dry-run")
+	assert.Nil(t, c.configStoreCache.Get(model.ServiceRole.Type, "client", "test-namespace"), "preview should not write any config")
+
+	// a resync of the previewed version is not audited again
+	buf.Reset()
+	err = c.sync(key)
+	assert.Nil(t, err, "sync should return nil")
+	assert.Equal(t, 0, buf.Len(), "preview should be audited once")
+
+	ns = ns.DeepCopy()
+	ns.Annotations = nil
+	assert.Nil(t, c.namespaceIndexInformer.GetIndexer().Update(ns), "updating the namespace should return nil")
+	err = c.sync(key)
+	assert.Nil(t, err, "sync should return nil")
+	_, exists := c.getPreviewed(key)
+	assert.False(t, exists, "preview should be forgotten without the preview annotation")
+}
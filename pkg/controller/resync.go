@@ -0,0 +1,154 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package controller
+
+import (
+	"hash/fnv"
+	"sort"
+	"time"
+
+	"istio.io/istio/pilot/pkg/model"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+
+	adv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/apis/athenz/v1"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
+)
+
+// resyncJitter is the maximum fraction of the resync interval added to it
+const resyncJitter = 0.1
+
+// syncState is the state of a domain at its last successful sync, when the
+// Istio RBAC resources of its namespace matched the desired ones
+type syncState struct {
+	resourceVersion string
+	namespace       string
+	istioHash       uint64
+}
+
+// istioStateHash returns a hash of the keys and resource versions of the given
+// Istio resources, which changes whenever one of them is created, updated or
+// deleted
+func istioStateHash(configs []model.Config) uint64 {
+	versions := make([]string, 0, len(configs))
+	for _, config := range configs {
+		versions = append(versions, config.Key()+"@"+config.ResourceVersion)
+	}
+	sort.Strings(versions)
+
+	h := fnv.New64a()
+	for _, version := range versions {
+		h.Write([]byte(version))
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
+
+// resyncOffset returns the stable offset of a domain within the resync
+// interval, so that the domain resyncs are spread over the interval
+func resyncOffset(key string, interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return time.Duration(uint64(h.Sum32()) % uint64(interval))
+}
+
+// recordSynced records the state of a domain after a sync which found its
+// Istio RBAC resources up to date
+func (c *Controller) recordSynced(key, resourceVersion, namespace string, current []model.Config) {
+	c.syncedLock.Lock()
+	defer c.syncedLock.Unlock()
+	c.synced[key] = syncState{
+		resourceVersion: resourceVersion,
+		namespace:       namespace,
+		istioHash:       istioStateHash(current),
+	}
+}
+
+// forgetSynced removes the recorded state of a domain
+func (c *Controller) forgetSynced(key string) {
+	c.syncedLock.Lock()
+	defer c.syncedLock.Unlock()
+	delete(c.synced, key)
+}
+
+// unchangedSinceSync returns true if neither the Athenz domain nor the Istio
+// RBAC resources of its namespace changed since its last successful sync
+func (c *Controller) unchangedSinceSync(key string, athenzDomain *adv1.AthenzDomain) bool {
+	c.syncedLock.Lock()
+	state, exists := c.synced[key]
+	c.syncedLock.Unlock()
+	if !exists || state.resourceVersion != athenzDomain.ResourceVersion {
+		return false
+	}
+
+	sr, err := c.configStoreCache.List(model.ServiceRole.Type, state.namespace)
+	if err != nil {
+		return false
+	}
+	srb, err := c.configStoreCache.List(model.ServiceRoleBinding.Type, state.namespace)
+	if err != nil {
+		return false
+	}
+	return istioStateHash(append(sr, srb...)) == state.istioHash
+}
+
+// resync will run as a periodic resync at a jittered interval, it will take
+// all the current athenz domains in the cache and put them onto the queue at
+// their offset within the interval. Domains which did not change since their
+// last successful sync are skipped if skipUnchanged is set, and domains outside
+// of the scope of the filter are always skipped.
+func (c *Controller) resync(stopCh <-chan struct{}) {
+	for {
+		adResyncInterval, skipUnchanged := c.resyncSettings()
+		select {
+		case <-time.After(wait.Jitter(adResyncInterval, resyncJitter)):
+			logger.Infof("Running resync for athenz domains...")
+			adListRaw := c.adIndexInformer.GetIndexer().List()
+			for _, adRaw := range adListRaw {
+				key, err := cache.MetaNamespaceKeyFunc(adRaw)
+				if err != nil {
+					logger.WithError(err).Errorf("resync(): Error calling key func")
+					continue
+				}
+
+				if !c.domainInScope(adRaw) {
+					continue
+				}
+				athenzDomain, ok := adRaw.(*adv1.AthenzDomain)
+				if ok && skipUnchanged && c.unchangedSinceSync(key, athenzDomain) {
+					metrics.ResyncsSkipped.Inc()
+					continue
+				}
+				c.queue.AddAfter(key, resyncOffset(key, adResyncInterval))
+			}
+		case <-stopCh:
+			logger.Infof("Stopping athenz domain resync...")
+			return
+		}
+	}
+}
+
+// Reload changes the resync settings of the athenz domains and the cluster
+// rbac config while the controllers run, they take effect on the next resync.
+// The cached desired state of the domains is invalidated.
+func (c *Controller) Reload(adResyncInterval time.Duration, skipUnchanged bool, crcResyncInterval, crcDebounce time.Duration) {
+	c.settingsLock.Lock()
+	c.adResyncInterval = adResyncInterval
+	c.skipUnchanged = skipUnchanged
+	c.settingsLock.Unlock()
+	c.crcController.Reload(crcResyncInterval, crcDebounce)
+	c.invalidateDesiredState()
+}
+
+// resyncSettings returns the resync interval of the athenz domains and
+// whether the unchanged ones are skipped
+func (c *Controller) resyncSettings() (time.Duration, bool) {
+	c.settingsLock.RLock()
+	defer c.settingsLock.RUnlock()
+	return c.adResyncInterval, c.skipUnchanged
+}
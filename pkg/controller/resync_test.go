@@ -0,0 +1,69 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"istio.io/istio/pilot/pkg/config/memory"
+	"istio.io/istio/pilot/pkg/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIstioStateHash(t *testing.T) {
+	sr := newSr("test-ns", "test-role")
+	sr.ResourceVersion = "1"
+	srb := newSrb("test-ns", "test-role")
+	srb.ResourceVersion = "2"
+
+	assert.Equal(t, istioStateHash([]model.Config{sr, srb}), istioStateHash([]model.Config{srb, sr}), "hash should not depend on the order")
+	assert.NotEqual(t, istioStateHash([]model.Config{sr, srb}), istioStateHash([]model.Config{sr}), "hash should change on deletion")
+
+	updated := sr
+	updated.ResourceVersion = "3"
+	assert.NotEqual(t, istioStateHash([]model.Config{sr, srb}), istioStateHash([]model.Config{updated, srb}), "hash should change on update")
+}
+
+func TestResyncOffset(t *testing.T) {
+	interval := time.Hour
+	offset := resyncOffset("test-namespace/test.namespace", interval)
+	assert.True(t, offset >= 0 && offset < interval, "offset should be within the interval")
+	assert.Equal(t, offset, resyncOffset("test-namespace/test.namespace", interval), "offset should be stable")
+	assert.NotEqual(t, offset, resyncOffset("other-namespace/other.namespace", interval), "offsets should differ between domains")
+	assert.Equal(t, time.Duration(0), resyncOffset("test-namespace/test.namespace", 0), "offset should be zero without interval")
+}
+
+func TestUnchangedSinceSync(t *testing.T) {
+	configStoreCache := memory.NewController(memory.Make(model.ConfigDescriptor{model.ServiceRole, model.ServiceRoleBinding}))
+	_, err := configStoreCache.Create(newSr("test-namespace", "test-role"))
+	assert.Nil(t, err, "create should return nil")
+
+	c := &Controller{
+		configStoreCache: configStoreCache,
+		synced:           make(map[string]syncState),
+	}
+	athenzDomain := ad.DeepCopy()
+	athenzDomain.ResourceVersion = "1"
+	key := "test-namespace/test.namespace"
+	assert.False(t, c.unchangedSinceSync(key, athenzDomain), "domain without a successful sync should not be skipped")
+
+	current, err := configStoreCache.List(model.ServiceRole.Type, "test-namespace")
+	assert.Nil(t, err, "list should return nil")
+	c.recordSynced(key, "1", "test-namespace", current)
+	assert.True(t, c.unchangedSinceSync(key, athenzDomain), "unchanged domain should be skipped")
+
+	athenzDomain.ResourceVersion = "2"
+	assert.False(t, c.unchangedSinceSync(key, athenzDomain), "updated domain should not be skipped")
+
+	athenzDomain.ResourceVersion = "1"
+	_, err = configStoreCache.Create(newSrb("test-namespace", "test-role"))
+	assert.Nil(t, err, "create should return nil")
+	assert.False(t, c.unchangedSinceSync(key, athenzDomain), "domain with changed istio resources should not be skipped")
+
+	c.forgetSynced(key)
+	_, exists := c.synced[key]
+	assert.False(t, exists, "sync state should be removed")
+}
@@ -0,0 +1,76 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package controller
+
+import (
+	"k8s.io/api/core/v1"
+
+	adv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/apis/athenz/v1"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/filter"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
+)
+
+// namespaceInScope returns true if the namespace is matched by the filter of
+// the controller
+func (c *Controller) namespaceInScope(namespace string) bool {
+	if c.filter.Empty() {
+		return true
+	}
+	return c.filter.Namespace(namespace, c.namespaceIndexInformer.GetIndexer())
+}
+
+// domainInScope returns true if the AthenzDomain is matched by the filter of
+// the controller, objects which are not an AthenzDomain are left to the sync
+func (c *Controller) domainInScope(obj interface{}) bool {
+	if c.filter.Empty() {
+		return true
+	}
+	athenzDomain, ok := obj.(*adv1.AthenzDomain)
+	if !ok {
+		return true
+	}
+	return c.filter.AthenzDomain(athenzDomain, c.namespaceIndexInformer.GetIndexer())
+}
+
+// keyInScope returns true if the athenz domain name and the target namespace
+// of a queue key are matched by the filter of the controller. The domain
+// selector is not checked as the AthenzDomain may no longer exist.
+func (c *Controller) keyInScope(key string) bool {
+	if c.filter.Empty() {
+		return true
+	}
+	domain, namespace, err := c.keyTarget(key)
+	if err != nil {
+		return true
+	}
+	return c.filter.DomainName(domain) && c.namespaceInScope(namespace)
+}
+
+// configInScope returns true if the athenz domain of a queue key computed
+// from an Istio custom resource is matched by the filter of the controller.
+// With a domain selector, the AthenzDomain must be in the informer cache,
+// which only holds the selected ones.
+func (c *Controller) configInScope(key string) bool {
+	if !c.keyInScope(key) {
+		return false
+	}
+	if !c.filter.HasDomainSelector() {
+		return true
+	}
+	obj, exists, err := c.adIndexInformer.GetIndexer().GetByKey(key)
+	return err == nil && exists && c.domainInScope(obj)
+}
+
+// reportProtected logs, records an event, writes the status and counts an
+// athenz domain whose Istio RBAC resources would be written to a protected
+// namespace
+func (c *Controller) reportProtected(key string, athenzDomain *adv1.AthenzDomain, namespace string) {
+	err := c.protected.Error(namespace)
+	domainLogger(key).WithError(err).Warningf("Refusing to sync the domain")
+	metrics.ProtectedNamespaceViolations.WithLabelValues(metrics.ResourceAthenzDomain).Inc()
+	if c.recorder != nil {
+		c.recorder.Event(newDomainReference(athenzDomain), v1.EventTypeWarning, filter.ProtectedNamespaceReason, err.Error())
+	}
+	c.updateDomainStatus(athenzDomain, filter.ProtectedNamespaceReason, err.Error())
+}
@@ -0,0 +1,187 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package controller
+
+import (
+	"testing"
+
+	"istio.io/istio/pilot/pkg/config/memory"
+	"istio.io/istio/pilot/pkg/model"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+
+	adv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/apis/athenz/v1"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/client/clientset/versioned/fake"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/filter"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/processor"
+	rbacv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/v1"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/retry"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newScopedController returns a controller with the filter, the test
+// namespace labeled team=a and its athenz domain labeled env=prod in cache
+func newScopedController(t *testing.T, f *filter.Filter) *Controller {
+	configStoreCache := memory.NewController(memory.Make(model.ConfigDescriptor{model.ServiceRole}))
+	c := &Controller{
+		queue:                  workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		processor:              processor.NewController(configStoreCache, 1, retry.DefaultPolicy(), retry.NewDeadLetters(), nil),
+		adIndexInformer:        cache.NewSharedIndexInformer(nil, &adv1.AthenzDomain{}, 0, adIndexers()),
+		namespaceIndexInformer: cache.NewSharedIndexInformer(nil, &v1.Namespace{}, 0, cache.Indexers{}),
+		desired:                make(map[string]desiredState),
+		knownGood:              make(map[string]knownGoodState),
+		applied:                make(map[string]appliedState),
+		invalid:                make(map[string]string),
+		filter:                 f,
+	}
+
+	namespace := &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "test-namespace",
+			Labels: map[string]string{"team": "a"},
+		},
+	}
+	assert.Nil(t, c.namespaceIndexInformer.GetIndexer().Add(namespace), "adding the namespace should return nil")
+	athenzDomain := ad.DeepCopy()
+	athenzDomain.Labels = map[string]string{"env": "prod"}
+	assert.Nil(t, c.adIndexInformer.GetIndexer().Add(athenzDomain), "adding the athenz domain should return nil")
+	return c
+}
+
+func newFilter(t *testing.T, includeNamespaces, excludeNamespaces []string, namespaceSelector, domainSelector, includeDomains, excludeDomains string) *filter.Filter {
+	f, err := filter.New(includeNamespaces, excludeNamespaces, namespaceSelector, domainSelector, includeDomains, excludeDomains)
+	assert.Nil(t, err, "error should be nil")
+	return f
+}
+
+func TestConfigInScope(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   func(t *testing.T) *filter.Filter
+		key      string
+		expected bool
+	}{
+		{
+			name:     "should match all the keys without a filter",
+			filter:   func(t *testing.T) *filter.Filter { return nil },
+			key:      "kube-system/kube.system",
+			expected: true,
+		},
+		{
+			name: "should not match a key of an excluded namespace",
+			filter: func(t *testing.T) *filter.Filter {
+				return newFilter(t, nil, []string{"test-namespace"}, "", "", "", "")
+			},
+			key:      "test-namespace/test.namespace",
+			expected: false,
+		},
+		{
+			name: "should match a key of a namespace matched by the selector",
+			filter: func(t *testing.T) *filter.Filter {
+				return newFilter(t, nil, nil, "team=a", "", "", "")
+			},
+			key:      "test-namespace/test.namespace",
+			expected: true,
+		},
+		{
+			name: "should not match a key whose domain matches the exclude regex",
+			filter: func(t *testing.T) *filter.Filter {
+				return newFilter(t, nil, nil, "", "", "", `^test\.`)
+			},
+			key:      "test-namespace/test.namespace",
+			expected: false,
+		},
+		{
+			name: "should match a key whose athenz domain is cached with a domain selector",
+			filter: func(t *testing.T) *filter.Filter {
+				return newFilter(t, nil, nil, "", "env=prod", "", "")
+			},
+			key:      "test-namespace/test.namespace",
+			expected: true,
+		},
+		{
+			name: "should not match a key whose athenz domain is not cached with a domain selector",
+			filter: func(t *testing.T) *filter.Filter {
+				return newFilter(t, nil, nil, "", "env=prod", "", "")
+			},
+			key:      "other-namespace/other.namespace",
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newScopedController(t, tt.filter(t))
+			assert.Equal(t, tt.expected, c.configInScope(tt.key), "config scope should be equal")
+		})
+	}
+}
+
+func TestKeyInScopeIndexed(t *testing.T) {
+	c := newIndexedController(t)
+
+	c.filter = newFilter(t, []string{"test-namespace"}, nil, "", "", `^test\.`, "")
+	assert.True(t, c.keyInScope("athenz-domains/custom"), "key should be matched by its target namespace and domain")
+
+	c.filter = newFilter(t, nil, []string{"test-namespace"}, "", "", "", "")
+	assert.False(t, c.keyInScope("athenz-domains/custom"), "key should not be matched if its target namespace is excluded")
+
+	c.filter = newFilter(t, nil, nil, "", "", "", `^test\.`)
+	assert.False(t, c.keyInScope("athenz-domains/custom"), "key should not be matched if its domain is excluded")
+
+	c.filter = newFilter(t, []string{"athenz-domains"}, nil, "", "", "", "")
+	assert.False(t, c.keyInScope("athenz-domains/custom"), "key should not be matched by the namespace of the athenz domain")
+}
+
+func TestProcessConfigEventFiltered(t *testing.T) {
+	c := newScopedController(t, newFilter(t, []string{"other-namespace"}, nil, "", "", "", ""))
+	config := model.Config{
+		ConfigMeta: model.ConfigMeta{
+			Type:      model.ServiceRole.Type,
+			Name:      "test",
+			Namespace: "test-namespace",
+		},
+	}
+
+	c.processConfigEvent(config, model.EventAdd)
+	assert.Equal(t, 0, c.queue.Len(), "queue length should be 0 for a namespace outside of the scope")
+}
+
+func TestSyncOutOfScope(t *testing.T) {
+	c := newScopedController(t, newFilter(t, nil, nil, "team=b", "", "", ""))
+
+	err := c.sync("test-namespace/test.namespace")
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, 0, len(c.desired), "desired state of a domain outside of the scope should not be computed")
+}
+
+func TestSyncProtected(t *testing.T) {
+	c := newScopedController(t, nil)
+	athenzDomain := ad.DeepCopy()
+	athenzDomain.Spec.SignedDomain.Domain = newCacheDomain()
+	assert.Nil(t, c.adIndexInformer.GetIndexer().Update(athenzDomain), "updating the athenz domain should return nil")
+	c.rbacProvider = rbacv1.NewProvider()
+	c.protected = filter.NewProtected([]string{"test-namespace"})
+	recorder := record.NewFakeRecorder(1)
+	c.recorder = recorder
+	adClient := fake.NewSimpleClientset()
+	_, err := adClient.AthenzV1().AthenzDomains("test-namespace").Create(athenzDomain.DeepCopy())
+	assert.Nil(t, err, "creating the athenz domain should return nil")
+	c.adClient = adClient
+
+	err = c.sync("test-namespace/test.namespace")
+	assert.Nil(t, err, "error should be nil")
+	_, exists := c.processor.BatchStatus("test-namespace/test.namespace")
+	assert.False(t, exists, "no batch should be processed for a protected namespace")
+	assert.Equal(t, "Warning ProtectedNamespace namespace test-namespace is protected, the controller never writes to it", <-recorder.Events, "event should be equal")
+
+	updated, err := adClient.AthenzV1().AthenzDomains("test-namespace").Get(athenzDomain.Name, metav1.GetOptions{})
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, "ProtectedNamespace: namespace test-namespace is protected, the controller never writes to it", updated.Status.Message, "status should report the violation")
+}
@@ -0,0 +1,43 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package controller
+
+import (
+	"strings"
+
+	adv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/apis/athenz/v1"
+)
+
+// domainStatus returns the status message of an AthenzDomain for a condition
+func domainStatus(reason, message string) string {
+	return reason + ": " + message
+}
+
+// updateDomainStatus writes the message of a condition to the status
+// subresource of the AthenzDomain, an empty message clears the status
+// previously written for the condition. The spec is never written. The status
+// is only written if it changes, a failed write is retried by the next sync of
+// the domain.
+func (c *Controller) updateDomainStatus(athenzDomain *adv1.AthenzDomain, reason, message string) {
+	if c.adClient == nil {
+		return
+	}
+
+	status := ""
+	if message != "" {
+		status = domainStatus(reason, message)
+	} else if !strings.HasPrefix(athenzDomain.Status.Message, reason+": ") {
+		return
+	}
+	if athenzDomain.Status.Message == status {
+		return
+	}
+
+	updated := athenzDomain.DeepCopy()
+	updated.Status.Message = status
+	_, err := c.adClient.AthenzV1().AthenzDomains(athenzDomain.Namespace).UpdateStatus(updated)
+	if err != nil {
+		logger.WithNamespace(athenzDomain.Namespace).WithDomain(athenzDomain.Name).WithError(err).Errorf("Error updating the athenz domain status")
+	}
+}
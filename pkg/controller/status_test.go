@@ -0,0 +1,47 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package controller
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/client/clientset/versioned/fake"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateDomainStatus(t *testing.T) {
+	adClient := fake.NewSimpleClientset()
+	athenzDomain, err := adClient.AthenzV1().AthenzDomains("test-namespace").Create(ad.DeepCopy())
+	assert.Nil(t, err, "creating the athenz domain should return nil")
+	c := &Controller{adClient: adClient}
+
+	getMessage := func() string {
+		current, err := adClient.AthenzV1().AthenzDomains("test-namespace").Get(athenzDomain.Name, metav1.GetOptions{})
+		assert.Nil(t, err, "getting the athenz domain should return nil")
+		athenzDomain = current
+		return current.Status.Message
+	}
+
+	c.updateDomainStatus(athenzDomain, "Reason", "something is wrong")
+	assert.Equal(t, "Reason: something is wrong", getMessage(), "status should be written")
+
+	c.updateDomainStatus(athenzDomain, "OtherReason", "")
+	assert.Equal(t, "Reason: something is wrong", getMessage(), "status of another reason should not be cleared")
+
+	c.updateDomainStatus(athenzDomain, "Reason", "")
+	assert.Equal(t, "", getMessage(), "status should be cleared")
+
+	for _, action := range adClient.Actions() {
+		if action.GetVerb() == "update" {
+			assert.Equal(t, "status", action.GetSubresource(), "only the status subresource should be written")
+		}
+	}
+
+	// without a client the status is not written
+	c = &Controller{}
+	c.updateDomainStatus(athenzDomain, "Reason", "something is wrong")
+}
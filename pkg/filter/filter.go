@@ -0,0 +1,193 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package filter
+
+import (
+	"fmt"
+	"regexp"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+
+	adv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/apis/athenz/v1"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+)
+
+// Filter scopes the controller to a subset of the namespaces and athenz
+// domains of the cluster. A nil filter matches everything.
+type Filter struct {
+	includeNamespaces map[string]bool
+	excludeNamespaces map[string]bool
+	namespaceSelector labels.Selector
+	domainSelector    labels.Selector
+	includeDomains    *regexp.Regexp
+	excludeDomains    *regexp.Regexp
+}
+
+// New returns a filter matching the namespaces of the include list, or all of
+// them if it is empty, which are not on the exclude list and whose labels
+// match the namespace selector, and the athenz domains of those namespaces
+// whose labels match the domain selector and whose name matches the include
+// regex and not the exclude regex. Empty selectors and regexes match
+// everything.
+func New(includeNamespaces, excludeNamespaces []string, namespaceSelector, domainSelector, includeDomains, excludeDomains string) (*Filter, error) {
+	f := &Filter{
+		includeNamespaces: make(map[string]bool),
+		excludeNamespaces: make(map[string]bool),
+	}
+	for _, namespace := range includeNamespaces {
+		f.includeNamespaces[namespace] = true
+	}
+	for _, namespace := range excludeNamespaces {
+		if f.includeNamespaces[namespace] {
+			return nil, fmt.Errorf("namespace %s is both included and excluded", namespace)
+		}
+		f.excludeNamespaces[namespace] = true
+	}
+
+	var err error
+	f.namespaceSelector, err = labels.Parse(namespaceSelector)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the namespace selector: %s", err.Error())
+	}
+	f.domainSelector, err = labels.Parse(domainSelector)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the domain selector: %s", err.Error())
+	}
+	if includeDomains != "" {
+		f.includeDomains, err = regexp.Compile(includeDomains)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing the domain include regex: %s", err.Error())
+		}
+	}
+	if excludeDomains != "" {
+		f.excludeDomains, err = regexp.Compile(excludeDomains)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing the domain exclude regex: %s", err.Error())
+		}
+	}
+	return f, nil
+}
+
+// Empty returns true if the filter matches everything
+func (f *Filter) Empty() bool {
+	return f == nil || (len(f.includeNamespaces) == 0 && len(f.excludeNamespaces) == 0 &&
+		f.namespaceSelector.Empty() && f.domainSelector.Empty() &&
+		f.includeDomains == nil && f.excludeDomains == nil)
+}
+
+// WatchNamespace returns the namespace the informers watch, the included
+// namespace if there is a single one or all the namespaces otherwise
+func (f *Filter) WatchNamespace() string {
+	if f == nil || len(f.includeNamespaces) != 1 {
+		return v1.NamespaceAll
+	}
+	for namespace := range f.includeNamespaces {
+		return namespace
+	}
+	return v1.NamespaceAll
+}
+
+// FieldSelector returns the field selector of the informers of namespaced
+// objects, which leaves out the excluded namespaces
+func (f *Filter) FieldSelector() fields.Selector {
+	if f == nil || len(f.excludeNamespaces) == 0 {
+		return fields.Everything()
+	}
+	selectors := make([]fields.Selector, 0, len(f.excludeNamespaces))
+	for namespace := range f.excludeNamespaces {
+		selectors = append(selectors, fields.OneTermNotEqualSelector("metadata.namespace", namespace))
+	}
+	return fields.AndSelectors(selectors...)
+}
+
+// TweakDomainListOptions restricts the list and watch of the AthenzDomain
+// informer to the domain selector. The AthenzDomain resources may live in any
+// namespace, their target namespace is matched by AthenzDomain instead.
+func (f *Filter) TweakDomainListOptions(options *metav1.ListOptions) {
+	if f == nil {
+		return
+	}
+	options.LabelSelector = f.domainSelector.String()
+}
+
+// HasDomainSelector returns true if the athenz domains are selected by label
+func (f *Filter) HasDomainSelector() bool {
+	return f != nil && !f.domainSelector.Empty()
+}
+
+// NamespaceName returns true if the namespace is matched by the include and
+// exclude lists
+func (f *Filter) NamespaceName(name string) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.includeNamespaces) > 0 && !f.includeNamespaces[name] {
+		return false
+	}
+	return !f.excludeNamespaces[name]
+}
+
+// Namespace returns true if the namespace, looked up from the namespace cache,
+// is matched by the filter. A namespace missing from the cache only matches an
+// empty namespace selector.
+func (f *Filter) Namespace(name string, namespaces cache.Indexer) bool {
+	if !f.NamespaceName(name) {
+		return false
+	}
+	if f == nil || f.namespaceSelector.Empty() {
+		return true
+	}
+
+	obj, exists, err := namespaces.GetByKey(name)
+	if err != nil || !exists {
+		return false
+	}
+	namespace, ok := obj.(*v1.Namespace)
+	if !ok {
+		return false
+	}
+	return f.NamespaceObject(namespace)
+}
+
+// NamespaceObject returns true if the namespace is matched by the include and
+// exclude lists and by the namespace selector
+func (f *Filter) NamespaceObject(namespace *v1.Namespace) bool {
+	if f == nil {
+		return true
+	}
+	return f.NamespaceName(namespace.Name) && f.namespaceSelector.Matches(labels.Set(namespace.Labels))
+}
+
+// DomainName returns true if the athenz domain name is matched by the include
+// and exclude regexes
+func (f *Filter) DomainName(name string) bool {
+	if f == nil {
+		return true
+	}
+	if f.includeDomains != nil && !f.includeDomains.MatchString(name) {
+		return false
+	}
+	return f.excludeDomains == nil || !f.excludeDomains.MatchString(name)
+}
+
+// AthenzDomain returns true if the AthenzDomain, the athenz domain held by its
+// spec and the namespace its Istio RBAC resources are written to are matched
+// by the filter. An AthenzDomain without a domain is matched by its name and
+// namespace.
+func (f *Filter) AthenzDomain(athenzDomain *adv1.AthenzDomain, namespaces cache.Indexer) bool {
+	if f == nil {
+		return true
+	}
+	domain, namespace := athenzDomain.Name, athenzDomain.Namespace
+	if athenzDomain.Spec.SignedDomain.Domain != nil {
+		domain = string(athenzDomain.Spec.SignedDomain.Domain.Name)
+		namespace = athenz.DomainToNamespace(domain)
+	}
+	return f.domainSelector.Matches(labels.Set(athenzDomain.Labels)) &&
+		f.DomainName(domain) && f.Namespace(namespace, namespaces)
+}
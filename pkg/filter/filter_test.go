@@ -0,0 +1,271 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package filter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yahoo/athenz/clients/go/zms"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+
+	adv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/apis/athenz/v1"
+)
+
+func newNamespace(name string, labels map[string]string) *v1.Namespace {
+	return &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+	}
+}
+
+func newAthenzDomain(namespace, name string, labels map[string]string) *adv1.AthenzDomain {
+	return &adv1.AthenzDomain{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			Labels:    labels,
+		},
+	}
+}
+
+func newIndexer(namespaces ...*v1.Namespace) cache.Indexer {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, namespace := range namespaces {
+		indexer.Add(namespace)
+	}
+	return indexer
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name              string
+		includeNamespaces []string
+		excludeNamespaces []string
+		namespaceSelector string
+		domainSelector    string
+		includeDomains    string
+		excludeDomains    string
+		expectedErr       string
+	}{
+		{
+			name: "should accept an empty filter",
+		},
+		{
+			name:              "should accept a valid filter",
+			includeNamespaces: []string{"team-a"},
+			excludeNamespaces: []string{"kube-system"},
+			namespaceSelector: "team=a",
+			domainSelector:    "env in (prod, staging)",
+			includeDomains:    `^team\.`,
+			excludeDomains:    `\.test$`,
+		},
+		{
+			name:              "should reject a namespace both included and excluded",
+			includeNamespaces: []string{"team-a"},
+			excludeNamespaces: []string{"team-a"},
+			expectedErr:       "namespace team-a is both included and excluded",
+		},
+		{
+			name:              "should reject an invalid namespace selector",
+			namespaceSelector: "team in (a",
+			expectedErr:       "error parsing the namespace selector: unable to parse requirement: found '', expected: ',' or ')'",
+		},
+		{
+			name:           "should reject an invalid domain include regex",
+			includeDomains: "team(",
+			expectedErr:    "error parsing the domain include regex: error parsing regexp: missing closing ): `team(`",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := New(tt.includeNamespaces, tt.excludeNamespaces, tt.namespaceSelector, tt.domainSelector, tt.includeDomains, tt.excludeDomains)
+			if tt.expectedErr == "" {
+				assert.Nil(t, err, "error should be nil")
+				assert.NotNil(t, f, "filter should not be nil")
+				return
+			}
+			assert.Nil(t, f, "filter should be nil")
+			assert.Equal(t, tt.expectedErr, err.Error(), "error should be equal")
+		})
+	}
+}
+
+func TestNilFilter(t *testing.T) {
+	var f *Filter
+	assert.True(t, f.Empty(), "nil filter should be empty")
+	assert.True(t, f.NamespaceName("kube-system"), "nil filter should match all the namespaces")
+	assert.True(t, f.DomainName("team.a"), "nil filter should match all the domains")
+	assert.True(t, f.AthenzDomain(newAthenzDomain("team-a", "team.a", nil), nil), "nil filter should match all the athenz domains")
+	assert.Equal(t, v1.NamespaceAll, f.WatchNamespace(), "nil filter should watch all the namespaces")
+	assert.Equal(t, "", f.FieldSelector().String(), "nil filter should select all the fields")
+	assert.False(t, f.HasDomainSelector(), "nil filter should not have a domain selector")
+
+	empty, err := New(nil, nil, "", "", "", "")
+	assert.Nil(t, err, "error should be nil")
+	assert.True(t, empty.Empty(), "filter without settings should be empty")
+}
+
+func TestNamespace(t *testing.T) {
+	namespaces := newIndexer(
+		newNamespace("team-a", map[string]string{"team": "a"}),
+		newNamespace("team-b", map[string]string{"team": "b"}),
+		newNamespace("kube-system", nil),
+	)
+
+	tests := []struct {
+		name      string
+		filter    func() (*Filter, error)
+		namespace string
+		expected  bool
+	}{
+		{
+			name:      "should match a namespace of the include list",
+			filter:    func() (*Filter, error) { return New([]string{"team-a"}, nil, "", "", "", "") },
+			namespace: "team-a",
+			expected:  true,
+		},
+		{
+			name:      "should not match a namespace missing from the include list",
+			filter:    func() (*Filter, error) { return New([]string{"team-a"}, nil, "", "", "", "") },
+			namespace: "team-b",
+			expected:  false,
+		},
+		{
+			name:      "should not match a namespace of the exclude list",
+			filter:    func() (*Filter, error) { return New(nil, []string{"kube-system"}, "", "", "", "") },
+			namespace: "kube-system",
+			expected:  false,
+		},
+		{
+			name:      "should match a namespace whose labels match the selector",
+			filter:    func() (*Filter, error) { return New(nil, nil, "team=a", "", "", "") },
+			namespace: "team-a",
+			expected:  true,
+		},
+		{
+			name:      "should not match a namespace whose labels do not match the selector",
+			filter:    func() (*Filter, error) { return New(nil, nil, "team=a", "", "", "") },
+			namespace: "team-b",
+			expected:  false,
+		},
+		{
+			name:      "should not match a namespace missing from the cache with a selector",
+			filter:    func() (*Filter, error) { return New(nil, nil, "team=a", "", "", "") },
+			namespace: "team-c",
+			expected:  false,
+		},
+		{
+			name:      "should match a namespace missing from the cache without a selector",
+			filter:    func() (*Filter, error) { return New(nil, []string{"kube-system"}, "", "", "", "") },
+			namespace: "team-c",
+			expected:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := tt.filter()
+			assert.Nil(t, err, "error should be nil")
+			assert.Equal(t, tt.expected, f.Namespace(tt.namespace, namespaces), "namespace match should be equal")
+		})
+	}
+}
+
+func TestAthenzDomain(t *testing.T) {
+	namespaces := newIndexer(
+		newNamespace("team-a", map[string]string{"team": "a"}),
+		newNamespace("team-b", nil),
+	)
+	f, err := New(nil, nil, "team", "env=prod", `^team\.`, `\.test$`)
+	assert.Nil(t, err, "error should be nil")
+
+	tests := []struct {
+		name         string
+		athenzDomain *adv1.AthenzDomain
+		expected     bool
+	}{
+		{
+			name:         "should match a domain matched by all the filters",
+			athenzDomain: newAthenzDomain("team-a", "team.a", map[string]string{"env": "prod"}),
+			expected:     true,
+		},
+		{
+			name:         "should not match a domain whose labels do not match the selector",
+			athenzDomain: newAthenzDomain("team-a", "team.a", map[string]string{"env": "dev"}),
+			expected:     false,
+		},
+		{
+			name:         "should not match a domain whose name does not match the include regex",
+			athenzDomain: newAthenzDomain("team-a", "other.a", map[string]string{"env": "prod"}),
+			expected:     false,
+		},
+		{
+			name:         "should not match a domain whose name matches the exclude regex",
+			athenzDomain: newAthenzDomain("team-a", "team.a.test", map[string]string{"env": "prod"}),
+			expected:     false,
+		},
+		{
+			name: "should match a domain by the domain of its spec and its target namespace",
+			athenzDomain: func() *adv1.AthenzDomain {
+				athenzDomain := newAthenzDomain("athenz-domains", "custom", map[string]string{"env": "prod"})
+				athenzDomain.Spec.SignedDomain.Domain = &zms.DomainData{Name: "team.a"}
+				return athenzDomain
+			}(),
+			expected: true,
+		},
+		{
+			name: "should not match a domain whose target namespace does not match",
+			athenzDomain: func() *adv1.AthenzDomain {
+				athenzDomain := newAthenzDomain("team-a", "team.a", map[string]string{"env": "prod"})
+				athenzDomain.Spec.SignedDomain.Domain = &zms.DomainData{Name: "team.b"}
+				return athenzDomain
+			}(),
+			expected: false,
+		},
+		{
+			name:         "should not match a domain whose namespace does not match",
+			athenzDomain: newAthenzDomain("team-b", "team.b", map[string]string{"env": "prod"}),
+			expected:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, f.AthenzDomain(tt.athenzDomain, namespaces), "athenz domain match should be equal")
+		})
+	}
+}
+
+func TestListOptions(t *testing.T) {
+	f, err := New([]string{"team-a"}, nil, "", "env=prod", "", "")
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, "team-a", f.WatchNamespace(), "single included namespace should be watched")
+
+	f, err = New([]string{"team-a", "team-b"}, []string{"kube-system"}, "", "env=prod", "", "")
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, v1.NamespaceAll, f.WatchNamespace(), "all the namespaces should be watched for several included namespaces")
+	assert.Equal(t, "metadata.namespace!=kube-system", f.FieldSelector().String(), "field selector should exclude the namespace")
+
+	options := metav1.ListOptions{}
+	f.TweakDomainListOptions(&options)
+	assert.Equal(t, "", options.FieldSelector, "field selector should not restrict the namespaces of the athenz domains")
+	assert.Equal(t, "env=prod", options.LabelSelector, "label selector should be set")
+}
+
+func TestProtected(t *testing.T) {
+	p := NewProtected([]string{"kube-system", "istio-system"})
+	assert.True(t, p.Contains("kube-system"), "namespace should be protected")
+	assert.False(t, p.Contains("team-a"), "namespace should not be protected")
+	assert.Equal(t, "namespace kube-system is protected, the controller never writes to it", p.Error("kube-system").Error(), "error should be equal")
+
+	var none Protected
+	assert.False(t, none.Contains("kube-system"), "nil set should protect no namespace")
+}
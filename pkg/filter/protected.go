@@ -0,0 +1,35 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package filter
+
+import (
+	"fmt"
+)
+
+// ProtectedNamespaceReason is the reason of the events recorded on the objects
+// which would have the controller write to a protected namespace
+const ProtectedNamespaceReason = "ProtectedNamespace"
+
+// Protected is the set of namespaces the controller never generates Istio RBAC
+// resources for nor onboards. A nil set protects no namespace.
+type Protected map[string]bool
+
+// NewProtected returns the set of the given namespaces
+func NewProtected(namespaces []string) Protected {
+	p := make(Protected, len(namespaces))
+	for _, namespace := range namespaces {
+		p[namespace] = true
+	}
+	return p
+}
+
+// Contains returns true if the namespace is protected
+func (p Protected) Contains(namespace string) bool {
+	return p[namespace]
+}
+
+// Error returns the error reported for a write to a protected namespace
+func (p Protected) Error(namespace string) error {
+	return fmt.Errorf("namespace %s is protected, the controller never writes to it", namespace)
+}
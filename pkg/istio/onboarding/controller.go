@@ -5,74 +5,246 @@ package onboarding
 
 import (
 	"errors"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 
+	"github.com/gogo/protobuf/proto"
+	"istio.io/api/networking/v1alpha3"
 	"istio.io/api/rbac/v1alpha1"
 	"istio.io/istio/pilot/pkg/model"
 
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/filter"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/processor"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/limits"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/retry"
 )
 
 const (
-	queueNumRetries        = 3
 	authzEnabled           = "true"
+	authzDisabled          = "false"
 	authzEnabledAnnotation = "authz.istio.io/enabled"
+	skipPolicyCheck        = "authz.istio.io/skip-policy-check"
+	dnsSuffixAnnotation    = "authz.istio.io/dns-suffix"
+	additionalHosts        = "authz.istio.io/additional-hosts"
+	svcLabel               = "svc"
+	wildCardAll            = "*"
 	queueKey               = v1.NamespaceDefault + "/" + model.DefaultRbacConfigName
-	logPrefix              = "[onboarding]"
+	resyncJitter           = 0.1
 )
 
+var logger = log.WithComponent("onboarding")
+
 type Controller struct {
-	configStoreCache     model.ConfigStoreCache
-	dnsSuffix            string
-	serviceIndexInformer cache.SharedIndexInformer
-	processor            *processor.Controller
-	queue                workqueue.RateLimitingInterface
-	crcResyncInterval    time.Duration
+	configStoreCache       model.ConfigStoreCache
+	dnsSuffix              string
+	serviceIndexInformer   cache.SharedIndexInformer
+	namespaceIndexInformer cache.SharedIndexInformer
+	processor              *processor.Controller
+	queue                  workqueue.RateLimitingInterface
+	crcResyncInterval      time.Duration
+	mode                   v1alpha1.RbacConfig_Mode
+	safeOnboarding         bool
+	policyTargets          map[string]map[string]bool
+	policyTargetsLock      sync.RWMutex
+	debounce               time.Duration
+	settingsLock           sync.RWMutex
+	index                  *namespaceIndex
+	retryPolicy            retry.Policy
+	deadLetters            *retry.DeadLetters
+	filter                 *filter.Filter
+	protected              filter.Protected
+	recorder               record.EventRecorder
+	removalLimits          limits.Limits
+}
+
+// onboardedTargets holds the namespaces and services which should be listed on the ClusterRbacConfig
+type onboardedTargets struct {
+	namespaces         []string
+	services           []string
+	excludedNamespaces []string
+	excludedServices   []string
+	pendingServices    []string
+}
+
+// newOnboardedTargets returns targets with empty lists
+func newOnboardedTargets() onboardedTargets {
+	return onboardedTargets{
+		namespaces:         make([]string, 0),
+		services:           make([]string, 0),
+		excludedNamespaces: make([]string, 0),
+		excludedServices:   make([]string, 0),
+		pendingServices:    make([]string, 0),
+	}
+}
+
+// mergeTargets concatenates the lists of the given targets
+func mergeTargets(targetsList []onboardedTargets) onboardedTargets {
+	merged := newOnboardedTargets()
+	for _, targets := range targetsList {
+		merged.namespaces = append(merged.namespaces, targets.namespaces...)
+		merged.services = append(merged.services, targets.services...)
+		merged.excludedNamespaces = append(merged.excludedNamespaces, targets.excludedNamespaces...)
+		merged.excludedServices = append(merged.excludedServices, targets.excludedServices...)
+		merged.pendingServices = append(merged.pendingServices, targets.pendingServices...)
+	}
+	sort.Strings(merged.namespaces)
+	sort.Strings(merged.excludedNamespaces)
+	sort.Strings(merged.pendingServices)
+	return merged
+}
+
+// empty returns true if there are no namespaces or services to enable authz for
+func (t onboardedTargets) empty() bool {
+	return len(t.namespaces) == 0 && len(t.services) == 0
 }
 
 // NewController initializes the Controller object and its dependencies
-func NewController(configStoreCache model.ConfigStoreCache, dnsSuffix string, serviceIndexInformer cache.SharedIndexInformer, crcResyncInterval time.Duration, processor *processor.Controller) *Controller {
-	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+// The options are built from the config with NewOptions. The service index
+// informer must have the cache.NamespaceIndex indexer. Only the namespaces
+// matched by the filter are onboarded, a nil filter matches all of them. The
+// protected namespaces are never onboarded, the attempts are reported with the
+// recorder, and their existing inclusions are kept. A sync removing more
+// services from the inclusion list than the removal limits allow is held until
+// approved.
+func NewController(configStoreCache model.ConfigStoreCache, serviceIndexInformer, namespaceIndexInformer cache.SharedIndexInformer, processor *processor.Controller, deadLetters *retry.DeadLetters, recorder record.EventRecorder, opts Options) *Controller {
+	queue := workqueue.NewRateLimitingQueue(opts.RetryPolicy.RateLimiter())
 
 	c := &Controller{
-		configStoreCache:     configStoreCache,
-		dnsSuffix:            dnsSuffix,
-		serviceIndexInformer: serviceIndexInformer,
-		processor:            processor,
-		queue:                queue,
-		crcResyncInterval:    crcResyncInterval,
+		configStoreCache:       configStoreCache,
+		dnsSuffix:              opts.DNSSuffix,
+		serviceIndexInformer:   serviceIndexInformer,
+		namespaceIndexInformer: namespaceIndexInformer,
+		processor:              processor,
+		queue:                  queue,
+		crcResyncInterval:      opts.ResyncInterval,
+		mode:                   opts.Mode,
+		safeOnboarding:         opts.SafeOnboarding,
+		policyTargets:          make(map[string]map[string]bool),
+		debounce:               opts.Debounce,
+		index:                  newNamespaceIndex(),
+		retryPolicy:            opts.RetryPolicy,
+		deadLetters:            deadLetters,
+		filter:                 opts.Filter,
+		protected:              opts.Protected,
+		recorder:               recorder,
+		removalLimits:          opts.RemovalLimits,
 	}
 
 	serviceIndexInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: func(_ interface{}) {
-			c.queue.Add(queueKey)
+		AddFunc: func(obj interface{}) {
+			c.processEvent(serviceResource, obj)
 		},
-		UpdateFunc: func(_ interface{}, _ interface{}) {
-			c.queue.Add(queueKey)
+		UpdateFunc: func(oldObj interface{}, newObj interface{}) {
+			if !serviceChanged(oldObj, newObj) {
+				metrics.OnboardingEvents.WithLabelValues(serviceResource, metrics.ResultFiltered).Inc()
+				return
+			}
+			c.processEvent(serviceResource, newObj)
 		},
-		DeleteFunc: func(_ interface{}) {
-			c.queue.Add(queueKey)
+		DeleteFunc: func(obj interface{}) {
+			c.processEvent(serviceResource, obj)
+		},
+	})
+	namespaceIndexInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			c.processEvent(namespaceResource, obj)
+		},
+		UpdateFunc: func(oldObj interface{}, newObj interface{}) {
+			if !namespaceChanged(oldObj, newObj) && !c.namespaceScopeChanged(oldObj, newObj) {
+				metrics.OnboardingEvents.WithLabelValues(namespaceResource, metrics.ResultFiltered).Inc()
+				return
+			}
+			c.processEvent(namespaceResource, newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			c.processEvent(namespaceResource, obj)
 		},
 	})
 
 	return c
 }
 
-// Run starts the worker thread
+// processEvent marks the namespace of the service or namespace object as dirty
+func (c *Controller) processEvent(resource string, obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		logger.WithError(err).Errorf("processEvent(): Error calling key func")
+		return
+	}
+
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		logger.WithResource(key).WithError(err).Errorf("processEvent(): Error splitting key")
+		return
+	}
+
+	// namespaces are cluster scoped, their key is their name
+	if resource == namespaceResource {
+		namespace = name
+	} else if !c.namespaceInScope(namespace) {
+		metrics.OnboardingEvents.WithLabelValues(resource, metrics.ResultFiltered).Inc()
+		return
+	}
+
+	metrics.OnboardingEvents.WithLabelValues(resource, metrics.ResultEnqueued).Inc()
+	c.markDirty(namespace)
+}
+
+// markDirty marks a namespace for recomputation and enqueues the cluster rbac
+// config key after the debounce window, events received during the window are
+// batched into a single sync
+func (c *Controller) markDirty(namespace string) {
+	c.index.markDirty(namespace)
+	_, debounce := c.settings()
+	c.queue.AddAfter(queueKey, debounce)
+}
+
+// Reload changes the resync interval and the debounce window while the
+// controller runs, they take effect on the next resync and event
+func (c *Controller) Reload(crcResyncInterval, debounce time.Duration) {
+	c.settingsLock.Lock()
+	defer c.settingsLock.Unlock()
+	c.crcResyncInterval = crcResyncInterval
+	c.debounce = debounce
+}
+
+// settings returns the resync interval and the debounce window
+func (c *Controller) settings() (time.Duration, time.Duration) {
+	c.settingsLock.RLock()
+	defer c.settingsLock.RUnlock()
+	return c.crcResyncInterval, c.debounce
+}
+
+// Run starts the worker thread. The ClusterRbacConfig is a single queue key,
+// which the queue never hands to two workers, so a single worker is started.
+// Once stopCh is closed, Run returns when the in-flight sync finished.
 func (c *Controller) Run(stopCh <-chan struct{}) {
 	go c.resync(stopCh)
 
-	defer c.queue.ShutDown()
-	wait.Until(c.runWorker, 0, stopCh)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.runWorker()
+	}()
+	<-stopCh
+
+	// the worker finishes its in-flight sync and skips the queued key
+	c.queue.ShutDown()
+	<-done
 }
 
-// runWorker calls processNextItem to process events of the work queue
+// runWorker calls processNextItem to process events of the work queue until
+// the queue shuts down
 func (c *Controller) runWorker() {
 	for c.processNextItem() {
 	}
@@ -87,21 +259,41 @@ func (c *Controller) processNextItem() bool {
 	}
 
 	defer c.queue.Done(key)
+	if c.queue.ShuttingDown() {
+		return false
+	}
 
 	err := c.sync()
 	if err != nil {
-		log.Errorf("%s Error syncing cluster rbac config for key %s: %s", logPrefix, key, err)
-		if c.queue.NumRequeues(key) < queueNumRetries {
-			log.Infof("%s Retrying key %s due to sync error", logPrefix, key)
+		logger.WithResource(writeKey()).WithError(err).Errorf("Error syncing cluster rbac config")
+		if c.queue.NumRequeues(key) < c.retryPolicy.MaxRetries {
+			logger.WithResource(writeKey()).Infof("Retrying cluster rbac config due to sync error")
+			metrics.Retries.WithLabelValues(metrics.ControllerClusterRbacConfig).Inc()
 			c.queue.AddRateLimited(key)
 			return true
 		}
+		c.deadLetters.Add(metrics.ControllerClusterRbacConfig, queueKey, err, c.queue.NumRequeues(key))
+		c.queue.Forget(key)
+		return true
+	}
+
+	// the backoff of the key is kept until the write of the cluster rbac
+	// config succeeds, the processor reports its failures to errHandler
+	if status, exists := c.processor.BatchStatus(writeKey()); exists && status.Err != nil {
+		return true
 	}
 
 	c.queue.Forget(key)
+	c.deadLetters.Remove(metrics.ControllerClusterRbacConfig, queueKey)
 	return true
 }
 
+// writeKey returns the processor key of the cluster rbac config writes
+func writeKey() string {
+	config := newClusterRbacConfig(v1alpha1.RbacConfig_ON_WITH_INCLUSION, newOnboardedTargets())
+	return config.Key()
+}
+
 // addService will add a service to the ClusterRbacConfig object
 func addServices(services []string, clusterRbacConfig *v1alpha1.RbacConfig) {
 	if clusterRbacConfig == nil || clusterRbacConfig.Inclusion == nil {
@@ -134,19 +326,41 @@ func deleteServices(services []string, clusterRbacConfig *v1alpha1.RbacConfig) {
 	}
 }
 
-// newClusterRbacSpec creates the rbac config object with the inclusion field
-func newClusterRbacSpec(services []string) *v1alpha1.RbacConfig {
+// newTarget returns a ClusterRbacConfig target for the given namespaces and
+// services, or nil if both are empty and the mode does not require the target
+func newTarget(namespaces, services []string, required bool) *v1alpha1.RbacConfig_Target {
+	if !required && len(namespaces) == 0 && len(services) == 0 {
+		return nil
+	}
+	return &v1alpha1.RbacConfig_Target{
+		Services:   services,
+		Namespaces: namespaces,
+	}
+}
+
+// newClusterRbacSpec creates the rbac config object for the given mode. Both
+// the inclusion and the exclusion fields are always populated from the
+// annotations, independently of the mode, so that switching between modes is
+// a single atomic update of the mode field with the target lists already in
+// place. In ON_WITH_EXCLUSION mode, the services pending safe onboarding are
+// excluded as well so that their traffic is not denied until they have a
+// policy.
+func newClusterRbacSpec(mode v1alpha1.RbacConfig_Mode, targets onboardedTargets) *v1alpha1.RbacConfig {
+	excludedServices := targets.excludedServices
+	if mode == v1alpha1.RbacConfig_ON_WITH_EXCLUSION && len(targets.pendingServices) > 0 {
+		excludedServices = make([]string, 0, len(targets.excludedServices)+len(targets.pendingServices))
+		excludedServices = append(excludedServices, targets.excludedServices...)
+		excludedServices = append(excludedServices, targets.pendingServices...)
+	}
 	return &v1alpha1.RbacConfig{
-		Mode: v1alpha1.RbacConfig_ON_WITH_INCLUSION,
-		Inclusion: &v1alpha1.RbacConfig_Target{
-			Services: services,
-		},
-		Exclusion: nil,
+		Mode:      mode,
+		Inclusion: newTarget(targets.namespaces, targets.services, mode == v1alpha1.RbacConfig_ON_WITH_INCLUSION),
+		Exclusion: newTarget(targets.excludedNamespaces, excludedServices, mode == v1alpha1.RbacConfig_ON_WITH_EXCLUSION),
 	}
 }
 
 // newClusterRbacConfig creates the ClusterRbacConfig model config object
-func newClusterRbacConfig(services []string) model.Config {
+func newClusterRbacConfig(mode v1alpha1.RbacConfig_Mode, targets onboardedTargets) model.Config {
 	return model.Config{
 		ConfigMeta: model.ConfigMeta{
 			Type:    model.ClusterRbacConfig.Type,
@@ -154,108 +368,441 @@ func newClusterRbacConfig(services []string) model.Config {
 			Group:   model.ClusterRbacConfig.Group + model.IstioAPIGroupDomain,
 			Version: model.ClusterRbacConfig.Version,
 		},
-		Spec: newClusterRbacSpec(services),
+		Spec: newClusterRbacSpec(mode, targets),
+	}
+}
+
+// UpdatePolicyTargets records the services targeted by the ServiceRole
+// constraints generated from the Athenz domain of the given namespace. In safe
+// onboarding mode, a service is only added to the ClusterRbacConfig once its
+// domain has at least one ServiceRole for it.
+func (c *Controller) UpdatePolicyTargets(namespace string, services []string) {
+	targets := make(map[string]bool, len(services))
+	for _, service := range services {
+		targets[service] = true
+	}
+
+	c.policyTargetsLock.Lock()
+	current, exists := c.policyTargets[namespace]
+	c.policyTargets[namespace] = targets
+	c.policyTargetsLock.Unlock()
+
+	if c.safeOnboarding && (!exists || !equalSets(current, targets)) {
+		c.markDirty(namespace)
+	}
+}
+
+// DeletePolicyTargets removes the recorded ServiceRole targets of a namespace
+// whose Athenz domain no longer exists
+func (c *Controller) DeletePolicyTargets(namespace string) {
+	c.policyTargetsLock.Lock()
+	_, exists := c.policyTargets[namespace]
+	delete(c.policyTargets, namespace)
+	c.policyTargetsLock.Unlock()
+
+	if c.safeOnboarding && exists {
+		c.markDirty(namespace)
+	}
+}
+
+// hasPolicy returns true if the Athenz domain of the service namespace exists
+// and generated a ServiceRole constraint targeting the service. The service is
+// matched by its svc selector label, or by its name if the label is not set.
+func (c *Controller) hasPolicy(svc *v1.Service) bool {
+	c.policyTargetsLock.RLock()
+	defer c.policyTargetsLock.RUnlock()
+
+	targets, exists := c.policyTargets[svc.Namespace]
+	if !exists {
+		return false
+	}
+
+	name, exists := svc.Spec.Selector[svcLabel]
+	if !exists {
+		name = svc.Name
+	}
+	return targets[name] || targets[wildCardAll]
+}
+
+// isReadyForOnboarding returns true if the service can be added to the
+// ClusterRbacConfig without denying all of its traffic
+func (c *Controller) isReadyForOnboarding(svc *v1.Service) bool {
+	if !c.safeOnboarding || svc.Annotations[skipPolicyCheck] == authzEnabled {
+		return true
+	}
+	return c.hasPolicy(svc)
+}
+
+// getNamespaceAuthz returns the value of the authz label on the namespace, or
+// of the authz annotation if the label is not set
+func getNamespaceAuthz(namespace *v1.Namespace) string {
+	if value, exists := namespace.Labels[authzEnabledAnnotation]; exists {
+		return value
+	}
+	return namespace.Annotations[authzEnabledAnnotation]
+}
+
+// splitList splits a comma separated annotation value into its trimmed non empty items
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// getDNSSuffixes returns the dns suffixes for a service, in order of precedence
+// from the dns suffix annotation of the service, the dns suffix annotation of
+// its namespace or the controller dns suffix
+func (c *Controller) getDNSSuffixes(svc *v1.Service) []string {
+	if suffixes := splitList(svc.Annotations[dnsSuffixAnnotation]); len(suffixes) > 0 {
+		return suffixes
+	}
+
+	namespaceRaw, exists, err := c.namespaceIndexInformer.GetIndexer().GetByKey(svc.Namespace)
+	if err == nil && exists {
+		if ns, ok := namespaceRaw.(*v1.Namespace); ok {
+			if suffixes := splitList(ns.Annotations[dnsSuffixAnnotation]); len(suffixes) > 0 {
+				return suffixes
+			}
+		}
+	}
+
+	return []string{c.dnsSuffix}
+}
+
+// getServiceHostnames returns all the fully qualified names a service is known
+// by: one per dns suffix, followed by the hosts listed in its additional hosts
+// annotation
+func (c *Controller) getServiceHostnames(svc *v1.Service) []string {
+	hostnames := make([]string, 0)
+	for _, suffix := range c.getDNSSuffixes(svc) {
+		hostnames = append(hostnames, svc.Name+"."+svc.Namespace+"."+suffix)
+	}
+	return append(hostnames, splitList(svc.Annotations[additionalHosts])...)
+}
+
+// listServiceEntries returns the ServiceEntries of a namespace, or of all
+// namespaces for v1.NamespaceAll, if the config store holds them
+func (c *Controller) listServiceEntries(namespace string) ([]model.Config, error) {
+	if _, exists := c.configStoreCache.ConfigDescriptor().GetByType(model.ServiceEntry.Type); !exists {
+		return nil, nil
 	}
+	return c.configStoreCache.List(model.ServiceEntry.Type, namespace)
 }
 
-// getOnboardedServiceList extracts all services from the indexer with the authz
-// annotation set to true.
-func (c *Controller) getOnboardedServiceList() []string {
-	cacheServiceList := c.serviceIndexInformer.GetIndexer().List()
-	serviceList := make([]string, 0)
+// getServiceEntryHosts returns the hosts of the ServiceEntries of a namespace
+// with the authz annotation set to true, and the ones with it set to false.
+// ServiceEntries are not subject to the safe onboarding policy check as they
+// do not select any workload the policy constraints could be matched against.
+func (c *Controller) getServiceEntryHosts(namespace string) ([]string, []string) {
+	onboarded := make([]string, 0)
+	optedOut := make([]string, 0)
 
+	serviceEntries, err := c.listServiceEntries(namespace)
+	if err != nil {
+		logger.WithNamespace(namespace).WithError(err).Errorf("Error listing the ServiceEntry resources")
+		return onboarded, optedOut
+	}
+
+	for _, serviceEntry := range serviceEntries {
+		spec, ok := serviceEntry.Spec.(*v1alpha3.ServiceEntry)
+		if !ok {
+			logger.WithResource(serviceEntry.Key()).Errorf("Could not cast to service entry object, skipping service list addition...")
+			continue
+		}
+
+		switch serviceEntry.Annotations[authzEnabledAnnotation] {
+		case authzEnabled:
+			onboarded = append(onboarded, spec.Hosts...)
+		case authzDisabled:
+			optedOut = append(optedOut, spec.Hosts...)
+		}
+	}
+
+	return onboarded, optedOut
+}
+
+// getNamespaceTargets extracts the namespaces and services of a namespace
+// which should have authz enabled or disabled:
+// 1. An onboarded namespace is added to the namespace inclusion list, unless
+//    one of its services opted out with the authz annotation set to false.
+//    Istio ignores the exclusion list in ON_WITH_INCLUSION mode, so such a
+//    namespace is expanded into the list of its remaining services instead.
+// 2. Services with the authz annotation set to true in a namespace which is
+//    not onboarded are added to the service inclusion list.
+// 3. Namespaces and services with the authz annotation set to false are added
+//    to the exclusion lists.
+// 4. In safe onboarding mode, services without an Athenz policy are held back
+//    as pending, and their namespace is expanded into its remaining services.
+//    In ON_WITH_EXCLUSION mode every service enforced by the mode is checked,
+//    whether it opted in or not, as it is enforced unless excluded.
+// 5. The hosts of the ServiceEntries with the authz annotation set are added
+//    to the service inclusion or exclusion list.
+// Every service is listed under all of its hostnames. A namespace outside of
+// the scope of the filter has no targets, a protected namespace is excluded.
+func (c *Controller) getNamespaceTargets(namespace string) onboardedTargets {
+	targets := newOnboardedTargets()
+	if !c.namespaceInScope(namespace) {
+		return targets
+	}
+	if c.protected.Contains(namespace) {
+		return c.getProtectedTargets(namespace)
+	}
+
+	namespaceAuthz := ""
+	namespaceRaw, exists, err := c.namespaceIndexInformer.GetIndexer().GetByKey(namespace)
+	if err == nil && exists {
+		ns, ok := namespaceRaw.(*v1.Namespace)
+		if !ok {
+			logger.Errorf("Could not cast to namespace object, skipping namespace list addition...")
+		} else {
+			namespaceAuthz = getNamespaceAuthz(ns)
+		}
+	}
+	namespaceOnboarded := namespaceAuthz == authzEnabled
+	if namespaceAuthz == authzDisabled {
+		targets.excludedNamespaces = append(targets.excludedNamespaces, namespace)
+	}
+
+	cacheServiceList, err := c.serviceIndexInformer.GetIndexer().ByIndex(cache.NamespaceIndex, namespace)
+	if err != nil {
+		logger.WithNamespace(namespace).WithError(err).Errorf("Error listing the services of the namespace")
+	}
+
+	namespaceServices := make([]string, 0)
+	partiallyOnboarded := false
 	for _, service := range cacheServiceList {
 		svc, ok := service.(*v1.Service)
 		if !ok {
-			log.Errorf("%s Could not cast to service object, skipping service list addition...", logPrefix)
+			logger.WithNamespace(namespace).Errorf("Could not cast to service object, skipping service list addition...")
 			continue
 		}
 
+		serviceNames := c.getServiceHostnames(svc)
 		key, exists := svc.Annotations[authzEnabledAnnotation]
+		if exists && key == authzDisabled {
+			targets.excludedServices = append(targets.excludedServices, serviceNames...)
+			partiallyOnboarded = true
+			continue
+		}
+
+		if namespaceOnboarded {
+			if !c.isReadyForOnboarding(svc) {
+				targets.pendingServices = append(targets.pendingServices, serviceNames...)
+				partiallyOnboarded = true
+				continue
+			}
+			namespaceServices = append(namespaceServices, serviceNames...)
+			continue
+		}
+
 		if exists && key == authzEnabled {
-			serviceName := svc.Name + "." + svc.Namespace + "." + c.dnsSuffix
-			serviceList = append(serviceList, serviceName)
+			if !c.isReadyForOnboarding(svc) {
+				targets.pendingServices = append(targets.pendingServices, serviceNames...)
+				continue
+			}
+			targets.services = append(targets.services, serviceNames...)
+			continue
+		}
+
+		if c.mode == v1alpha1.RbacConfig_ON_WITH_EXCLUSION && namespaceAuthz != authzDisabled && !c.isReadyForOnboarding(svc) {
+			targets.pendingServices = append(targets.pendingServices, serviceNames...)
 		}
 	}
 
-	return serviceList
+	if namespaceOnboarded {
+		if partiallyOnboarded {
+			targets.services = append(targets.services, namespaceServices...)
+		} else {
+			targets.namespaces = append(targets.namespaces, namespace)
+		}
+	}
+
+	onboardedHosts, optedOutHosts := c.getServiceEntryHosts(namespace)
+	targets.services = append(targets.services, onboardedHosts...)
+	targets.excludedServices = append(targets.excludedServices, optedOutHosts...)
+
+	return targets
+}
+
+// getNamespaces returns all the namespaces which hold a namespace object, a
+// service or a service entry
+func (c *Controller) getNamespaces() []string {
+	namespaces := make(map[string]bool)
+	for _, namespace := range c.namespaceIndexInformer.GetIndexer().ListKeys() {
+		namespaces[namespace] = true
+	}
+	for _, namespace := range c.serviceIndexInformer.GetIndexer().ListIndexFuncValues(cache.NamespaceIndex) {
+		namespaces[namespace] = true
+	}
+	serviceEntries, err := c.listServiceEntries(v1.NamespaceAll)
+	if err != nil {
+		logger.WithError(err).Errorf("Error listing the ServiceEntry resources")
+	}
+	for _, serviceEntry := range serviceEntries {
+		namespaces[serviceEntry.Namespace] = true
+	}
+
+	namespaceList := make([]string, 0, len(namespaces))
+	for namespace := range namespaces {
+		namespaceList = append(namespaceList, namespace)
+	}
+	sort.Strings(namespaceList)
+	return namespaceList
+}
+
+// getOnboardedTargets computes the targets of every namespace in the cluster
+func (c *Controller) getOnboardedTargets() onboardedTargets {
+	namespaceTargets := make([]onboardedTargets, 0)
+	for _, namespace := range c.getNamespaces() {
+		namespaceTargets = append(namespaceTargets, c.getNamespaceTargets(namespace))
+	}
+	return mergeTargets(namespaceTargets)
+}
+
+// reportPendingServices reports the onboarded services which are waiting on an
+// Athenz policy before being added to the ClusterRbacConfig
+func reportPendingServices(pendingServices []string) {
+	metrics.ServicesPendingPolicy.Set(float64(len(pendingServices)))
+	if len(pendingServices) > 0 {
+		logger.Warningf("Services waiting on an Athenz policy before onboarding: %s", strings.Join(pendingServices, ", "))
+	}
 }
 
-// errHandler re-adds the key for a failed processor.sync operation
+// errHandler re-adds the key for a failed processor.sync operation, so that
+// the cluster rbac config is recomputed from the current state, until the
+// retries are exhausted
 func (c *Controller) errHandler(err error, item *processor.Item) error {
 	if err != nil {
 		if item != nil {
-			log.Errorf("%s Error performing %s on %s: %s", logPrefix, item.Operation, item.Resource.Key(), err)
+			logger.WithResource(item.Resource.Key()).WithOperation(item.Operation).WithError(err).Errorf("Error performing the change")
 		}
-		c.queue.AddRateLimited(queueKey)
+		if c.queue.NumRequeues(queueKey) < c.retryPolicy.MaxRetries {
+			metrics.Retries.WithLabelValues(metrics.ControllerClusterRbacConfig).Inc()
+			c.queue.AddRateLimited(queueKey)
+			return nil
+		}
+		c.deadLetters.Add(metrics.ControllerClusterRbacConfig, queueKey, err, c.queue.NumRequeues(queueKey))
+		c.queue.Forget(queueKey)
 	}
 	return nil
 }
 
+// equalTargets returns true if both ClusterRbacConfig targets list the same
+// namespaces and services, a nil target is equal to an empty one
+func equalTargets(targetA, targetB *v1alpha1.RbacConfig_Target) bool {
+	if targetA == nil {
+		targetA = &v1alpha1.RbacConfig_Target{}
+	}
+	if targetB == nil {
+		targetB = &v1alpha1.RbacConfig_Target{}
+	}
+	return equalLists(targetA.Namespaces, targetB.Namespaces) && equalLists(targetA.Services, targetB.Services)
+}
+
 // sync decides whether to create / update / delete the ClusterRbacConfig
-// object based on the current onboarded services in the cluster
+// object based on the configured mode and the current onboarded namespaces
+// and services in the cluster. The ClusterRbacConfig is only deleted in
+// ON_WITH_INCLUSION mode when nothing is onboarded, deleting it in any other
+// mode would turn authz off for the whole cluster. The targets of the
+// namespaces outside of the scope of the filter are kept as is. The sync is
+// held if it removes more namespaces and services than the removal limits
+// allow from the inclusion list in ON_WITH_INCLUSION mode, or from the
+// exclusion list in ON_WITH_EXCLUSION mode.
 func (c *Controller) sync() error {
-	serviceList := c.getOnboardedServiceList()
+	metrics.Syncs.WithLabelValues(metrics.ControllerClusterRbacConfig).Inc()
+	targets := c.updateIndex()
+	if c.safeOnboarding {
+		reportPendingServices(targets.pendingServices)
+	}
+	inclusionMode := c.mode == v1alpha1.RbacConfig_ON_WITH_INCLUSION
 	config := c.configStoreCache.Get(model.ClusterRbacConfig.Type, model.DefaultRbacConfigName, "")
-	if config == nil && len(serviceList) == 0 {
-		log.Infof("%s Service list is empty and cluster rbac config does not exist, skipping sync...", logPrefix)
+	if config != nil {
+		if existing, ok := config.Spec.(*v1alpha1.RbacConfig); ok {
+			targets = mergeTargets([]onboardedTargets{targets, c.outOfScopeTargets(existing), c.protectedInclusions(existing)})
+		}
+	}
+	if config == nil && inclusionMode && targets.empty() {
+		logger.Infof("Service list is empty and cluster rbac config does not exist, skipping sync...")
+		c.processor.ClearStatus(writeKey())
 		return nil
 	}
 
 	if config == nil {
-		log.Infof("%s Creating cluster rbac config...", logPrefix)
+		logger.WithResource(writeKey()).WithOperation(model.EventAdd).Infof("Creating cluster rbac config with mode %s...", c.mode)
 		item := processor.Item{
 			Operation:    model.EventAdd,
-			Resource:     newClusterRbacConfig(serviceList),
+			Resource:     newClusterRbacConfig(c.mode, targets),
 			ErrorHandler: c.errHandler,
 		}
 		c.processor.ProcessConfigChange(&item)
 		return nil
 	}
 
-	if len(serviceList) == 0 {
-		log.Infof("%s Deleting cluster rbac config...", logPrefix)
+	cached, ok := config.Spec.(*v1alpha1.RbacConfig)
+	if !ok {
+		return errors.New("Could not cast to ClusterRbacConfig")
+	}
+	// the spec is shared with the config store cache, modify a copy
+	clusterRbacConfig := proto.Clone(cached).(*v1alpha1.RbacConfig)
+
+	if c.holdRemovals(*config, clusterRbacConfig, targets) {
+		return nil
+	}
+
+	if inclusionMode && targets.empty() {
+		logger.WithResource(writeKey()).WithOperation(model.EventDelete).Infof("Deleting cluster rbac config...")
 		item := processor.Item{
 			Operation:    model.EventDelete,
-			Resource:     newClusterRbacConfig(serviceList),
+			Resource:     newClusterRbacConfig(c.mode, targets),
 			ErrorHandler: c.errHandler,
 		}
 		c.processor.ProcessConfigChange(&item)
 		return nil
 	}
 
-	clusterRbacConfig, ok := config.Spec.(*v1alpha1.RbacConfig)
-	if !ok {
-		return errors.New("Could not cast to ClusterRbacConfig")
+	desired := newClusterRbacSpec(c.mode, targets)
+	modeChanged := clusterRbacConfig.Mode != desired.Mode
+	if modeChanged {
+		logger.WithResource(writeKey()).Infof("Switching cluster rbac config mode from %s to %s...", clusterRbacConfig.Mode, desired.Mode)
+		clusterRbacConfig.Mode = desired.Mode
 	}
 
-	if clusterRbacConfig.Inclusion == nil || clusterRbacConfig.Mode != v1alpha1.RbacConfig_ON_WITH_INCLUSION {
-		log.Infof("%s ClusterRBacConfig inclusion field is nil or ON_WITH_INCLUSION mode is not set, syncing...", logPrefix)
-		config := model.Config{
-			ConfigMeta: config.ConfigMeta,
-			Spec:       newClusterRbacSpec(serviceList),
+	var newServices, oldServices []string
+	inclusionChanged := false
+	if clusterRbacConfig.Inclusion == nil || desired.Inclusion == nil {
+		inclusionChanged = !equalTargets(clusterRbacConfig.Inclusion, desired.Inclusion) ||
+			(clusterRbacConfig.Inclusion == nil) != (desired.Inclusion == nil)
+		clusterRbacConfig.Inclusion = desired.Inclusion
+	} else {
+		newServices = compareServiceLists(targets.services, clusterRbacConfig.Inclusion.Services)
+		if len(newServices) > 0 {
+			addServices(newServices, clusterRbacConfig)
 		}
-		item := processor.Item{
-			Operation:    model.EventUpdate,
-			Resource:     config,
-			ErrorHandler: c.errHandler,
+
+		oldServices = compareServiceLists(clusterRbacConfig.Inclusion.Services, targets.services)
+		if len(oldServices) > 0 {
+			deleteServices(oldServices, clusterRbacConfig)
 		}
-		c.processor.ProcessConfigChange(&item)
-		return nil
-	}
 
-	newServices := compareServiceLists(serviceList, clusterRbacConfig.Inclusion.Services)
-	if len(newServices) > 0 {
-		addServices(newServices, clusterRbacConfig)
+		if !equalLists(clusterRbacConfig.Inclusion.Namespaces, targets.namespaces) {
+			inclusionChanged = true
+			clusterRbacConfig.Inclusion.Namespaces = targets.namespaces
+		}
 	}
 
-	oldServices := compareServiceLists(clusterRbacConfig.Inclusion.Services, serviceList)
-	if len(oldServices) > 0 {
-		deleteServices(oldServices, clusterRbacConfig)
+	exclusionChanged := !equalTargets(clusterRbacConfig.Exclusion, desired.Exclusion) ||
+		(clusterRbacConfig.Exclusion == nil) != (desired.Exclusion == nil)
+	if exclusionChanged {
+		clusterRbacConfig.Exclusion = desired.Exclusion
 	}
 
-	if len(newServices) > 0 || len(oldServices) > 0 {
-		log.Infof("%s Updating cluster rbac config...", logPrefix)
+	if modeChanged || len(newServices) > 0 || len(oldServices) > 0 || inclusionChanged || exclusionChanged {
+		logger.WithResource(writeKey()).WithOperation(model.EventUpdate).Infof("Updating cluster rbac config...")
 		config := model.Config{
 			ConfigMeta: config.ConfigMeta,
 			Spec:       clusterRbacConfig,
@@ -269,26 +816,40 @@ func (c *Controller) sync() error {
 		return nil
 	}
 
-	log.Infof("%s Sync state is current, no changes needed...", logPrefix)
+	logger.Infof("Sync state is current, no changes needed...")
+	c.processor.ClearStatus(writeKey())
 	return nil
 }
 
+// EventHandler enqueues the cluster rbac config key on external edits of the
+// ClusterRbacConfig, the onboarded targets do not need to be recomputed
 func (c *Controller) EventHandler(config model.Config, e model.Event) {
+	if c.processor.IsOwnWrite(config, e) {
+		metrics.OnboardingEvents.WithLabelValues(config.Type, metrics.ResultSuppressed).Inc()
+		return
+	}
+	metrics.OnboardingEvents.WithLabelValues(config.Type, metrics.ResultEnqueued).Inc()
 	c.queue.Add(queueKey)
 }
 
-// resync will run as a periodic resync at a given interval, it will put the
-// cluster rbac config key onto the queue
+// ServiceEntryEventHandler marks the namespace of the ServiceEntry as dirty
+func (c *Controller) ServiceEntryEventHandler(config model.Config, e model.Event) {
+	metrics.OnboardingEvents.WithLabelValues(config.Type, metrics.ResultEnqueued).Inc()
+	c.markDirty(config.Namespace)
+}
+
+// resync will run as a periodic resync at a jittered interval, it will put the
+// cluster rbac config key onto the queue and recompute all the namespaces
 func (c *Controller) resync(stopCh <-chan struct{}) {
-	t := time.NewTicker(c.crcResyncInterval)
-	defer t.Stop()
 	for {
+		crcResyncInterval, _ := c.settings()
 		select {
-		case <-t.C:
-			log.Infof("%s Running resync for cluster rbac config...", logPrefix)
+		case <-time.After(wait.Jitter(crcResyncInterval, resyncJitter)):
+			logger.Infof("Running resync for cluster rbac config...")
+			c.index.markAllDirty()
 			c.queue.Add(queueKey)
 		case <-stopCh:
-			log.Infof("%s Stopping cluster rbac config resync...", logPrefix)
+			logger.Infof("Stopping cluster rbac config resync...")
 			return
 		}
 	}
@@ -311,6 +872,24 @@ func compareServiceLists(serviceListA, serviceListB []string) []string {
 	return serviceListDiff
 }
 
+// equalSets returns true if both sets contain the same items
+func equalSets(setA, setB map[string]bool) bool {
+	if len(setA) != len(setB) {
+		return false
+	}
+	for item := range setA {
+		if !setB[item] {
+			return false
+		}
+	}
+	return true
+}
+
+// equalLists returns true if both lists contain the same items regardless of order
+func equalLists(listA, listB []string) bool {
+	return len(compareServiceLists(listA, listB)) == 0 && len(compareServiceLists(listB, listA)) == 0
+}
+
 // removeIndexElement removes an element from an array at the given index
 func removeIndexElement(serviceList []string, indexToRemove int) []string {
 	if indexToRemove > len(serviceList) || indexToRemove < 0 {
@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"istio.io/api/networking/v1alpha3"
 	"istio.io/api/rbac/v1alpha1"
 	"istio.io/istio/pilot/pkg/config/memory"
 	"istio.io/istio/pilot/pkg/model"
@@ -23,6 +24,7 @@ import (
 
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/processor"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/retry"
 )
 
 var (
@@ -41,10 +43,49 @@ var (
 			Namespace: "test-namespace",
 		},
 	}
+	optedOutService = &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "opted-out-service",
+			Namespace: "test-namespace",
+			Annotations: map[string]string{
+				authzEnabledAnnotation: "false",
+			},
+		},
+	}
+	onboardedNamespace = &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test-namespace",
+			Labels: map[string]string{
+				authzEnabledAnnotation: "true",
+			},
+		},
+	}
+	annotatedNamespace = &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test-namespace",
+			Annotations: map[string]string{
+				authzEnabledAnnotation: "true",
+			},
+		},
+	}
+	optedOutNamespace = &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "opted-out-namespace",
+			Labels: map[string]string{
+				authzEnabledAnnotation: "false",
+			},
+		},
+	}
+	notOnboardedNamespace = &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test-namespace",
+		},
+	}
 
 	onboardedServiceName    = "onboarded-service.test-namespace.svc.cluster.local"
 	existingServiceName     = "existing-service.test-namespace.svc.cluster.local"
 	notOnboardedServiceName = "not-onboarded-service.test-namespace.svc.cluster.local"
+	optedOutServiceName     = "opted-out-service.test-namespace.svc.cluster.local"
 	dnsSuffix               = "svc.cluster.local"
 )
 
@@ -107,7 +148,7 @@ func getClusterRbacConfig(c *Controller) (*v1alpha1.RbacConfig, error) {
 	return clusterRbacConfig, nil
 }
 
-func newFakeController(services []*v1.Service, fake bool, stopCh <-chan struct{}) *Controller {
+func newFakeController(services []*v1.Service, namespaces []*v1.Namespace, fake bool, stopCh <-chan struct{}) *Controller {
 	c := &Controller{}
 	configDescriptor := model.ConfigDescriptor{
 		model.ClusterRbacConfig,
@@ -121,21 +162,35 @@ func newFakeController(services []*v1.Service, fake bool, stopCh <-chan struct{}
 		}
 	}
 	c.configStoreCache = memory.NewController(configStore)
-	c.processor = processor.NewController(c.configStoreCache)
+	c.processor = processor.NewController(c.configStoreCache, 1, retry.DefaultPolicy(), retry.NewDeadLetters(), nil)
 	go c.processor.Run(stopCh)
 
 	source := fcache.NewFakeControllerSource()
 	for _, service := range services {
 		source.Add(service)
 	}
-	fakeIndexInformer := cache.NewSharedIndexInformer(source, &v1.Service{}, 0, nil)
+	fakeIndexInformer := cache.NewSharedIndexInformer(source, &v1.Service{}, 0, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
 	go fakeIndexInformer.Run(stopCh)
 
-	if !cache.WaitForCacheSync(stopCh, fakeIndexInformer.HasSynced) {
+	namespaceSource := fcache.NewFakeControllerSource()
+	for _, namespace := range namespaces {
+		namespaceSource.Add(namespace)
+	}
+	fakeNamespaceIndexInformer := cache.NewSharedIndexInformer(namespaceSource, &v1.Namespace{}, 0, nil)
+	go fakeNamespaceIndexInformer.Run(stopCh)
+
+	if !cache.WaitForCacheSync(stopCh, fakeIndexInformer.HasSynced, fakeNamespaceIndexInformer.HasSynced) {
 		log.Panicln("timed out waiting for cache to sync")
 	}
 	c.serviceIndexInformer = fakeIndexInformer
+	c.namespaceIndexInformer = fakeNamespaceIndexInformer
 	c.dnsSuffix = dnsSuffix
+	c.mode = v1alpha1.RbacConfig_ON_WITH_INCLUSION
+	c.policyTargets = make(map[string]map[string]bool)
+	c.queue = workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	c.index = newNamespaceIndex()
+	c.retryPolicy = retry.DefaultPolicy()
+	c.deadLetters = retry.NewDeadLetters()
 
 	return c
 }
@@ -147,17 +202,33 @@ func TestNewController(t *testing.T) {
 
 	source := fcache.NewFakeControllerSource()
 	fakeIndexInformer := cache.NewSharedIndexInformer(source, &v1.Service{}, 0, nil)
+	fakeNamespaceIndexInformer := cache.NewSharedIndexInformer(fcache.NewFakeControllerSource(), &v1.Namespace{}, 0, nil)
 	configStore := memory.Make(configDescriptor)
 	configStoreCache := memory.NewController(configStore)
-	processor := processor.NewController(configStoreCache)
+	processor := processor.NewController(configStoreCache, 1, retry.DefaultPolicy(), retry.NewDeadLetters(), nil)
 	stopCh := make(chan struct{})
 	go processor.Run(stopCh)
 
-	c := NewController(configStoreCache, dnsSuffix, fakeIndexInformer, time.Second, processor)
+	c := NewController(configStoreCache, fakeIndexInformer, fakeNamespaceIndexInformer, processor, retry.NewDeadLetters(), nil, Options{
+		DNSSuffix:      dnsSuffix,
+		Mode:           v1alpha1.RbacConfig_ON_WITH_EXCLUSION,
+		SafeOnboarding: true,
+		ResyncInterval: time.Second,
+		Debounce:       time.Millisecond,
+		RetryPolicy:    retry.DefaultPolicy(),
+	})
 	assert.Equal(t, dnsSuffix, c.dnsSuffix, "dns suffix should be equal")
 	assert.Equal(t, fakeIndexInformer, c.serviceIndexInformer, "service index informer pointer should be equal")
+	assert.Equal(t, fakeNamespaceIndexInformer, c.namespaceIndexInformer, "namespace index informer pointer should be equal")
 	assert.Equal(t, configStoreCache, c.configStoreCache, "config configStoreCache cache pointer should be equal")
 	assert.Equal(t, time.Second, c.crcResyncInterval, "crc resync interval should be equal")
+	assert.Equal(t, time.Millisecond, c.debounce, "debounce should be equal")
+	c.Reload(time.Minute, time.Second)
+	crcResyncInterval, debounce := c.settings()
+	assert.Equal(t, time.Minute, crcResyncInterval, "reloaded crc resync interval should be equal")
+	assert.Equal(t, time.Second, debounce, "reloaded debounce should be equal")
+	assert.Equal(t, v1alpha1.RbacConfig_ON_WITH_EXCLUSION, c.mode, "crc mode should be equal")
+	assert.True(t, c.safeOnboarding, "safe onboarding should be enabled")
 	assert.Equal(t, processor, c.processor, "processor controller pointer should be equal")
 
 }
@@ -172,13 +243,13 @@ func TestAddService(t *testing.T) {
 		{
 			name:              "test adding new service to ClusterRbacConfig",
 			inputServices:     []string{onboardedServiceName},
-			clusterRbacConfig: newClusterRbacSpec(nil),
+			clusterRbacConfig: newClusterRbacSpec(v1alpha1.RbacConfig_ON_WITH_INCLUSION, onboardedTargets{}),
 			expectedArray:     []string{onboardedServiceName},
 		},
 		{
 			name:              "test adding existing service to ClusterRbacConfig",
 			inputServices:     []string{onboardedServiceName},
-			clusterRbacConfig: newClusterRbacSpec([]string{existingServiceName}),
+			clusterRbacConfig: newClusterRbacSpec(v1alpha1.RbacConfig_ON_WITH_INCLUSION, onboardedTargets{services: []string{existingServiceName}}),
 			expectedArray:     []string{existingServiceName, onboardedServiceName},
 		},
 		{
@@ -190,7 +261,7 @@ func TestAddService(t *testing.T) {
 		{
 			name:              "test adding with empty ClusterRbacConfig",
 			inputServices:     []string{onboardedServiceName},
-			clusterRbacConfig: newClusterRbacSpec(nil),
+			clusterRbacConfig: newClusterRbacSpec(v1alpha1.RbacConfig_ON_WITH_INCLUSION, onboardedTargets{}),
 			expectedArray:     []string{onboardedServiceName},
 		},
 	}
@@ -214,25 +285,25 @@ func TestDeleteService(t *testing.T) {
 	}{
 		{
 			name:              "test deleting service from ClusterRbacConfig",
-			clusterRbacConfig: newClusterRbacSpec([]string{onboardedServiceName}),
+			clusterRbacConfig: newClusterRbacSpec(v1alpha1.RbacConfig_ON_WITH_INCLUSION, onboardedTargets{services: []string{onboardedServiceName}}),
 			inputArray:        []string{onboardedServiceName},
 			expectedArray:     []string{},
 		},
 		{
 			name:              "test deleting existing service from ClusterRbacConfig",
-			clusterRbacConfig: newClusterRbacSpec([]string{onboardedServiceName, existingServiceName}),
+			clusterRbacConfig: newClusterRbacSpec(v1alpha1.RbacConfig_ON_WITH_INCLUSION, onboardedTargets{services: []string{onboardedServiceName, existingServiceName}}),
 			inputArray:        []string{onboardedServiceName},
 			expectedArray:     []string{existingServiceName},
 		},
 		{
 			name:              "test deleting empty input array to ClusterRbacConfig",
-			clusterRbacConfig: newClusterRbacSpec([]string{existingServiceName}),
+			clusterRbacConfig: newClusterRbacSpec(v1alpha1.RbacConfig_ON_WITH_INCLUSION, onboardedTargets{services: []string{existingServiceName}}),
 			inputArray:        []string{},
 			expectedArray:     []string{existingServiceName},
 		},
 		{
 			name:              "test deleting empty service which does not exist in the ClusterRbacConfig",
-			clusterRbacConfig: newClusterRbacSpec([]string{existingServiceName}),
+			clusterRbacConfig: newClusterRbacSpec(v1alpha1.RbacConfig_ON_WITH_INCLUSION, onboardedTargets{services: []string{existingServiceName}}),
 			inputArray:        []string{onboardedServiceName},
 			expectedArray:     []string{existingServiceName},
 		},
@@ -247,7 +318,7 @@ func TestDeleteService(t *testing.T) {
 }
 
 func TestCreateClusterRbacConfig(t *testing.T) {
-	config := newClusterRbacConfig([]string{onboardedServiceName, existingServiceName})
+	config := newClusterRbacConfig(v1alpha1.RbacConfig_ON_WITH_INCLUSION, onboardedTargets{services: []string{onboardedServiceName, existingServiceName}})
 	clusterRbacConfig, ok := config.Spec.(*v1alpha1.RbacConfig)
 	if !ok {
 		log.Panicln("cannot cast to rbac config")
@@ -257,36 +328,102 @@ func TestCreateClusterRbacConfig(t *testing.T) {
 	assert.Equal(t, model.ClusterRbacConfig.Group+model.IstioAPIGroupDomain, config.Group, "ClusterRbacConfig group should be equal")
 	assert.Equal(t, model.ClusterRbacConfig.Version, config.Version, "ClusterRbacConfig version should be equal")
 	assert.Equal(t, []string{onboardedServiceName, existingServiceName}, clusterRbacConfig.Inclusion.Services, "ClusterRbacConfig service list should be equal to expected")
+	assert.Nil(t, clusterRbacConfig.Exclusion, "ClusterRbacConfig exclusion should be nil")
 }
 
-func TestGetServiceList(t *testing.T) {
+func TestCreateClusterRbacConfigWithNamespaces(t *testing.T) {
+	targets := onboardedTargets{
+		namespaces:       []string{"test-namespace"},
+		services:         []string{existingServiceName},
+		excludedServices: []string{optedOutServiceName},
+	}
+	clusterRbacConfig := newClusterRbacSpec(v1alpha1.RbacConfig_ON_WITH_INCLUSION, targets)
+	assert.Equal(t, v1alpha1.RbacConfig_ON_WITH_INCLUSION, clusterRbacConfig.Mode, "ClusterRbacConfig mode should be equal")
+	assert.Equal(t, []string{"test-namespace"}, clusterRbacConfig.Inclusion.Namespaces, "ClusterRbacConfig namespace list should be equal to expected")
+	assert.Equal(t, []string{existingServiceName}, clusterRbacConfig.Inclusion.Services, "ClusterRbacConfig service list should be equal to expected")
+	assert.Equal(t, []string{optedOutServiceName}, clusterRbacConfig.Exclusion.Services, "ClusterRbacConfig exclusion list should be equal to expected")
+}
+
+func TestGetOnboardedTargets(t *testing.T) {
 	onboardedServiceCopy := onboardedService.DeepCopy()
 	onboardedServiceCopy.Name = "onboarded-service-copy"
 	onboardedServiceCopyName := "onboarded-service-copy.test-namespace.svc.cluster.local"
 
 	tests := []struct {
-		name                 string
-		inputServiceList     []*v1.Service
-		expectedServiceArray []string
+		name                            string
+		inputServiceList                []*v1.Service
+		inputNamespaceList              []*v1.Namespace
+		expectedNamespaceArray          []string
+		expectedServiceArray            []string
+		expectedExclusionArray          []string
+		expectedExcludedNamespacesArray []string
 	}{
 		{
-			name:                 "test getting onboarded services",
-			inputServiceList:     []*v1.Service{onboardedService},
-			expectedServiceArray: []string{onboardedServiceName},
+			name:                   "test getting onboarded services",
+			inputServiceList:       []*v1.Service{onboardedService},
+			expectedNamespaceArray: []string{},
+			expectedServiceArray:   []string{onboardedServiceName},
+			expectedExclusionArray: []string{},
+		},
+		{
+			name:                   "test getting mix of onboarded and not onboarded services",
+			inputServiceList:       []*v1.Service{onboardedService, onboardedServiceCopy, notOnboardedService},
+			inputNamespaceList:     []*v1.Namespace{notOnboardedNamespace},
+			expectedNamespaceArray: []string{},
+			expectedServiceArray:   []string{onboardedServiceName, onboardedServiceCopyName},
+			expectedExclusionArray: []string{},
+		},
+		{
+			name:                   "test getting namespace onboarded with a label",
+			inputServiceList:       []*v1.Service{onboardedService, notOnboardedService},
+			inputNamespaceList:     []*v1.Namespace{onboardedNamespace},
+			expectedNamespaceArray: []string{"test-namespace"},
+			expectedServiceArray:   []string{},
+			expectedExclusionArray: []string{},
+		},
+		{
+			name:                   "test getting namespace onboarded with an annotation",
+			inputServiceList:       []*v1.Service{notOnboardedService},
+			inputNamespaceList:     []*v1.Namespace{annotatedNamespace},
+			expectedNamespaceArray: []string{"test-namespace"},
+			expectedServiceArray:   []string{},
+			expectedExclusionArray: []string{},
 		},
 		{
-			name:                 "test getting mix of onboarded and not onboarded services",
-			inputServiceList:     []*v1.Service{onboardedService, onboardedServiceCopy, notOnboardedService},
-			expectedServiceArray: []string{onboardedServiceName, onboardedServiceCopyName},
+			name:                   "test getting onboarded namespace with an opted out service",
+			inputServiceList:       []*v1.Service{onboardedService, notOnboardedService, optedOutService},
+			inputNamespaceList:     []*v1.Namespace{onboardedNamespace},
+			expectedNamespaceArray: []string{},
+			expectedServiceArray:   []string{onboardedServiceName, notOnboardedServiceName},
+			expectedExclusionArray: []string{optedOutServiceName},
+		},
+		{
+			name:                   "test opted out service is excluded if namespace is not onboarded",
+			inputServiceList:       []*v1.Service{optedOutService},
+			inputNamespaceList:     []*v1.Namespace{notOnboardedNamespace},
+			expectedNamespaceArray: []string{},
+			expectedServiceArray:   []string{},
+			expectedExclusionArray: []string{optedOutServiceName},
+		},
+		{
+			name:                            "test getting opted out namespace",
+			inputServiceList:                []*v1.Service{onboardedService},
+			inputNamespaceList:              []*v1.Namespace{notOnboardedNamespace, optedOutNamespace},
+			expectedNamespaceArray:          []string{},
+			expectedServiceArray:            []string{onboardedServiceName},
+			expectedExclusionArray:          []string{},
+			expectedExcludedNamespacesArray: []string{"opted-out-namespace"},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c := newFakeController(tt.inputServiceList, false, make(chan struct{}))
-			ret := c.getOnboardedServiceList()
-			diff := compareServiceLists(tt.expectedServiceArray, ret)
-			assert.Equal(t, []string{}, diff, "list should be equal to expected")
+			c := newFakeController(tt.inputServiceList, tt.inputNamespaceList, false, make(chan struct{}))
+			ret := c.getOnboardedTargets()
+			assert.Equal(t, tt.expectedNamespaceArray, ret.namespaces, "namespace list should be equal to expected")
+			assert.True(t, equalLists(tt.expectedServiceArray, ret.services), "service list should be equal to expected")
+			assert.True(t, equalLists(tt.expectedExclusionArray, ret.excludedServices), "exclusion list should be equal to expected")
+			assert.True(t, equalLists(tt.expectedExcludedNamespacesArray, ret.excludedNamespaces), "excluded namespace list should be equal to expected")
 		})
 	}
 }
@@ -309,6 +446,220 @@ func createClusterRbacExclusionConfig(services []string) model.Config {
 	}
 }
 
+func TestGetOnboardedTargetsSafeOnboarding(t *testing.T) {
+	selectedService := notOnboardedService.DeepCopy()
+	selectedService.Name = "selected-service"
+	selectedService.Spec.Selector = map[string]string{svcLabel: "policy-service"}
+	selectedServiceName := "selected-service.test-namespace.svc.cluster.local"
+
+	skippedService := onboardedService.DeepCopy()
+	skippedService.Name = "skipped-service"
+	skippedService.Annotations[skipPolicyCheck] = "true"
+	skippedServiceName := "skipped-service.test-namespace.svc.cluster.local"
+
+	tests := []struct {
+		name                   string
+		inputServiceList       []*v1.Service
+		inputNamespaceList     []*v1.Namespace
+		inputPolicyTargets     []string
+		expectedNamespaceArray []string
+		expectedServiceArray   []string
+		expectedPendingArray   []string
+	}{
+		{
+			name:                   "test service is pending if the athenz domain does not exist",
+			inputServiceList:       []*v1.Service{onboardedService},
+			expectedNamespaceArray: []string{},
+			expectedServiceArray:   []string{},
+			expectedPendingArray:   []string{onboardedServiceName},
+		},
+		{
+			name:                   "test service is pending if there is no policy for it",
+			inputServiceList:       []*v1.Service{onboardedService},
+			inputPolicyTargets:     []string{"another-service"},
+			expectedNamespaceArray: []string{},
+			expectedServiceArray:   []string{},
+			expectedPendingArray:   []string{onboardedServiceName},
+		},
+		{
+			name:                   "test service is onboarded if there is a policy for it",
+			inputServiceList:       []*v1.Service{onboardedService},
+			inputPolicyTargets:     []string{"onboarded-service"},
+			expectedNamespaceArray: []string{},
+			expectedServiceArray:   []string{onboardedServiceName},
+			expectedPendingArray:   []string{},
+		},
+		{
+			name:                   "test service is onboarded if there is a wildcard policy",
+			inputServiceList:       []*v1.Service{onboardedService},
+			inputPolicyTargets:     []string{"*"},
+			expectedNamespaceArray: []string{},
+			expectedServiceArray:   []string{onboardedServiceName},
+			expectedPendingArray:   []string{},
+		},
+		{
+			name:                   "test service is onboarded if the override annotation is set",
+			inputServiceList:       []*v1.Service{skippedService},
+			expectedNamespaceArray: []string{},
+			expectedServiceArray:   []string{skippedServiceName},
+			expectedPendingArray:   []string{},
+		},
+		{
+			name:                   "test onboarded namespace is expanded if a service is pending",
+			inputServiceList:       []*v1.Service{onboardedService, selectedService},
+			inputNamespaceList:     []*v1.Namespace{onboardedNamespace},
+			inputPolicyTargets:     []string{"policy-service"},
+			expectedNamespaceArray: []string{},
+			expectedServiceArray:   []string{selectedServiceName},
+			expectedPendingArray:   []string{onboardedServiceName},
+		},
+		{
+			name:                   "test onboarded namespace is added if all services have a policy",
+			inputServiceList:       []*v1.Service{onboardedService, selectedService},
+			inputNamespaceList:     []*v1.Namespace{onboardedNamespace},
+			inputPolicyTargets:     []string{"policy-service", "onboarded-service"},
+			expectedNamespaceArray: []string{"test-namespace"},
+			expectedServiceArray:   []string{},
+			expectedPendingArray:   []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newFakeController(tt.inputServiceList, tt.inputNamespaceList, false, make(chan struct{}))
+			c.safeOnboarding = true
+			if tt.inputPolicyTargets != nil {
+				c.UpdatePolicyTargets("test-namespace", tt.inputPolicyTargets)
+			}
+			ret := c.getOnboardedTargets()
+			assert.Equal(t, tt.expectedNamespaceArray, ret.namespaces, "namespace list should be equal to expected")
+			assert.True(t, equalLists(tt.expectedServiceArray, ret.services), "service list should be equal to expected")
+			assert.Equal(t, tt.expectedPendingArray, ret.pendingServices, "pending service list should be equal to expected")
+		})
+	}
+}
+
+func TestGetServiceHostnames(t *testing.T) {
+	suffixNamespace := notOnboardedNamespace.DeepCopy()
+	suffixNamespace.Annotations = map[string]string{dnsSuffixAnnotation: "svc.cluster-a.local, svc.cluster-b.local"}
+
+	suffixService := onboardedService.DeepCopy()
+	suffixService.Annotations[dnsSuffixAnnotation] = "svc.cluster-c.local"
+
+	additionalHostsService := onboardedService.DeepCopy()
+	additionalHostsService.Annotations[additionalHosts] = "onboarded.example.com,,onboarded.mesh"
+
+	tests := []struct {
+		name               string
+		inputService       *v1.Service
+		inputNamespaceList []*v1.Namespace
+		expectedHostnames  []string
+	}{
+		{
+			name:              "test service hostname with the controller dns suffix",
+			inputService:      onboardedService,
+			expectedHostnames: []string{onboardedServiceName},
+		},
+		{
+			name:               "test service hostnames with the namespace dns suffixes",
+			inputService:       onboardedService,
+			inputNamespaceList: []*v1.Namespace{suffixNamespace},
+			expectedHostnames: []string{
+				"onboarded-service.test-namespace.svc.cluster-a.local",
+				"onboarded-service.test-namespace.svc.cluster-b.local",
+			},
+		},
+		{
+			name:               "test service dns suffix takes precedence over the namespace dns suffix",
+			inputService:       suffixService,
+			inputNamespaceList: []*v1.Namespace{suffixNamespace},
+			expectedHostnames:  []string{"onboarded-service.test-namespace.svc.cluster-c.local"},
+		},
+		{
+			name:         "test service hostnames with additional hosts",
+			inputService: additionalHostsService,
+			expectedHostnames: []string{
+				onboardedServiceName,
+				"onboarded.example.com",
+				"onboarded.mesh",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newFakeController(nil, tt.inputNamespaceList, false, make(chan struct{}))
+			assert.Equal(t, tt.expectedHostnames, c.getServiceHostnames(tt.inputService), "hostnames should be equal to expected")
+		})
+	}
+}
+
+func newServiceEntry(name, authz string, hosts []string) model.Config {
+	return model.Config{
+		ConfigMeta: model.ConfigMeta{
+			Type:        model.ServiceEntry.Type,
+			Group:       model.ServiceEntry.Group + model.IstioAPIGroupDomain,
+			Version:     model.ServiceEntry.Version,
+			Name:        name,
+			Namespace:   "test-namespace",
+			Annotations: map[string]string{authzEnabledAnnotation: authz},
+		},
+		Spec: &v1alpha3.ServiceEntry{
+			Hosts: hosts,
+			Ports: []*v1alpha3.Port{
+				{
+					Number:   80,
+					Protocol: "HTTP",
+					Name:     "http",
+				},
+			},
+			Resolution: v1alpha3.ServiceEntry_DNS,
+		},
+	}
+}
+
+func TestGetServiceEntryHosts(t *testing.T) {
+	c := newFakeController(nil, nil, false, make(chan struct{}))
+	onboarded, optedOut := c.getServiceEntryHosts(v1.NamespaceAll)
+	assert.Equal(t, []string{}, onboarded, "onboarded hosts should be empty if the store does not hold service entries")
+	assert.Equal(t, []string{}, optedOut, "opted out hosts should be empty if the store does not hold service entries")
+
+	c.configStoreCache = memory.NewController(memory.Make(model.ConfigDescriptor{
+		model.ClusterRbacConfig,
+		model.ServiceEntry,
+	}))
+	for _, serviceEntry := range []model.Config{
+		newServiceEntry("onboarded", "true", []string{"onboarded.example.com", "onboarded.mesh"}),
+		newServiceEntry("opted-out", "false", []string{"opted-out.example.com"}),
+		newServiceEntry("not-onboarded", "", []string{"not-onboarded.example.com"}),
+	} {
+		_, err := c.configStoreCache.Create(serviceEntry)
+		assert.Nil(t, err, "creating the ServiceEntry should return nil")
+	}
+
+	onboarded, optedOut = c.getServiceEntryHosts("test-namespace")
+	assert.True(t, equalLists([]string{"onboarded.example.com", "onboarded.mesh"}, onboarded), "onboarded hosts should be equal to expected")
+	assert.Equal(t, []string{"opted-out.example.com"}, optedOut, "opted out hosts should be equal to expected")
+}
+
+func TestUpdatePolicyTargets(t *testing.T) {
+	c := newFakeController(nil, nil, false, make(chan struct{}))
+	c.safeOnboarding = true
+
+	c.UpdatePolicyTargets("test-namespace", []string{"onboarded-service"})
+	assert.Equal(t, 1, c.queue.Len(), "queue length should be 1 after adding policy targets")
+	assert.True(t, c.hasPolicy(onboardedService), "service should have a policy")
+
+	item, _ := c.queue.Get()
+	c.queue.Done(item)
+	c.UpdatePolicyTargets("test-namespace", []string{"onboarded-service"})
+	assert.Equal(t, 0, c.queue.Len(), "queue length should be 0 if policy targets did not change")
+
+	c.DeletePolicyTargets("test-namespace")
+	assert.Equal(t, 1, c.queue.Len(), "queue length should be 1 after deleting policy targets")
+	assert.False(t, c.hasPolicy(onboardedService), "service should not have a policy")
+}
+
 func TestSyncService(t *testing.T) {
 	onboardedServiceCopy := onboardedService.DeepCopy()
 	onboardedServiceCopy.Name = "onboarded-service-copy"
@@ -332,7 +683,7 @@ func TestSyncService(t *testing.T) {
 		{
 			name:                   "Update: update ClusterRbacConfig when it exists with multiple services",
 			inputServiceList:       []*v1.Service{onboardedService, onboardedServiceCopy, notOnboardedService, notOnboardedServiceCopy},
-			inputClusterRbacConfig: newClusterRbacConfig([]string{onboardedServiceCopyName}),
+			inputClusterRbacConfig: newClusterRbacConfig(v1alpha1.RbacConfig_ON_WITH_INCLUSION, onboardedTargets{services: []string{onboardedServiceCopyName}}),
 			expectedServiceList:    []string{onboardedServiceCopyName, onboardedServiceName},
 		},
 		{
@@ -344,13 +695,13 @@ func TestSyncService(t *testing.T) {
 		{
 			name:                   "Update: update ClusterRbacConfig when not onboarded service exists",
 			inputServiceList:       []*v1.Service{onboardedService, notOnboardedService},
-			inputClusterRbacConfig: newClusterRbacConfig([]string{onboardedServiceName, notOnboardedServiceName}),
+			inputClusterRbacConfig: newClusterRbacConfig(v1alpha1.RbacConfig_ON_WITH_INCLUSION, onboardedTargets{services: []string{onboardedServiceName, notOnboardedServiceName}}),
 			expectedServiceList:    []string{onboardedServiceName},
 		},
 		{
 			name:                   "Delete: delete cluster rbacconfig if service is no longer onboarded",
 			inputServiceList:       []*v1.Service{notOnboardedService},
-			inputClusterRbacConfig: newClusterRbacConfig([]string{notOnboardedServiceName}),
+			inputClusterRbacConfig: newClusterRbacConfig(v1alpha1.RbacConfig_ON_WITH_INCLUSION, onboardedTargets{services: []string{notOnboardedServiceName}}),
 			expectedServiceList:    []string{},
 		},
 	}
@@ -358,7 +709,7 @@ func TestSyncService(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			stopCh := make(chan struct{})
-			c := newFakeController(tt.inputServiceList, true, stopCh)
+			c := newFakeController(tt.inputServiceList, nil, true, stopCh)
 
 			if tt.inputClusterRbacConfig.Spec != nil {
 				_, err := c.configStoreCache.Create(tt.inputClusterRbacConfig)
@@ -385,10 +736,208 @@ func TestSyncService(t *testing.T) {
 	}
 }
 
+func TestSyncCopiesCachedSpec(t *testing.T) {
+	c := newFakeController([]*v1.Service{onboardedService}, nil, true, make(chan struct{}))
+	config := newClusterRbacConfig(v1alpha1.RbacConfig_ON_WITH_INCLUSION, onboardedTargets{services: []string{notOnboardedServiceName}})
+	_, err := c.configStoreCache.Create(config)
+	assert.Nil(t, err, "creating the ClusterRbacConfig should return nil")
+	cached := c.configStoreCache.Get(model.ClusterRbacConfig.Type, model.DefaultRbacConfigName, "")
+	spec := cached.Spec.(*v1alpha1.RbacConfig)
+
+	err = c.sync()
+	assert.Nil(t, err, "sync error should be nil")
+	assert.Equal(t, []string{notOnboardedServiceName}, spec.Inclusion.Services, "cached spec should not be modified by the sync")
+}
+
+func TestSyncNamespace(t *testing.T) {
+	tests := []struct {
+		name                   string
+		inputServiceList       []*v1.Service
+		inputNamespaceList     []*v1.Namespace
+		inputClusterRbacConfig model.Config
+		expectedNamespaceList  []string
+		expectedServiceList    []string
+		expectedExclusionList  []string
+	}{
+		{
+			name:                  "Create: create ClusterRbacConfig when it does not exist with an onboarded namespace",
+			inputServiceList:      []*v1.Service{notOnboardedService},
+			inputNamespaceList:    []*v1.Namespace{onboardedNamespace},
+			expectedNamespaceList: []string{"test-namespace"},
+		},
+		{
+			name:                   "Update: update ClusterRbacConfig when a namespace is onboarded",
+			inputServiceList:       []*v1.Service{onboardedService, notOnboardedService},
+			inputNamespaceList:     []*v1.Namespace{onboardedNamespace},
+			inputClusterRbacConfig: newClusterRbacConfig(v1alpha1.RbacConfig_ON_WITH_INCLUSION, onboardedTargets{services: []string{onboardedServiceName}}),
+			expectedNamespaceList:  []string{"test-namespace"},
+		},
+		{
+			name:                   "Update: update ClusterRbacConfig when a service opts out of an onboarded namespace",
+			inputServiceList:       []*v1.Service{onboardedService, optedOutService},
+			inputNamespaceList:     []*v1.Namespace{onboardedNamespace},
+			inputClusterRbacConfig: newClusterRbacConfig(v1alpha1.RbacConfig_ON_WITH_INCLUSION, onboardedTargets{namespaces: []string{"test-namespace"}}),
+			expectedServiceList:    []string{onboardedServiceName},
+			expectedExclusionList:  []string{optedOutServiceName},
+		},
+		{
+			name:                   "Update: update ClusterRbacConfig when a namespace is no longer onboarded",
+			inputServiceList:       []*v1.Service{onboardedService, notOnboardedService},
+			inputNamespaceList:     []*v1.Namespace{notOnboardedNamespace},
+			inputClusterRbacConfig: newClusterRbacConfig(v1alpha1.RbacConfig_ON_WITH_INCLUSION, onboardedTargets{namespaces: []string{"test-namespace"}}),
+			expectedServiceList:    []string{onboardedServiceName},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stopCh := make(chan struct{})
+			c := newFakeController(tt.inputServiceList, tt.inputNamespaceList, true, stopCh)
+
+			if tt.inputClusterRbacConfig.Spec != nil {
+				_, err := c.configStoreCache.Create(tt.inputClusterRbacConfig)
+				assert.Nil(t, err, "creating the ClusterRbacConfig should return nil")
+			}
+
+			err := c.sync()
+			assert.Nil(t, err, "sync error should be nil")
+
+			// Add a sleep for processing controller to work on the queue
+			time.Sleep(100 * time.Millisecond)
+
+			clusterRbacConfig, err := getClusterRbacConfig(c)
+			assert.Nil(t, err, fmt.Sprintf("error should be nil for getClusterRbacConfig: %s", err))
+			assert.True(t, equalLists(tt.expectedNamespaceList, clusterRbacConfig.Inclusion.Namespaces), "ClusterRbacConfig inclusion namespace list should be equal to the expected namespace list")
+			assert.True(t, equalLists(tt.expectedServiceList, clusterRbacConfig.Inclusion.Services), "ClusterRbacConfig inclusion service list should be equal to the expected service list")
+			var exclusion []string
+			if clusterRbacConfig.Exclusion != nil {
+				exclusion = clusterRbacConfig.Exclusion.Services
+			}
+			assert.True(t, equalLists(tt.expectedExclusionList, exclusion), "ClusterRbacConfig exclusion service list should be equal to the expected exclusion list")
+			close(stopCh)
+		})
+	}
+}
+
+func TestSyncMode(t *testing.T) {
+	tests := []struct {
+		name                   string
+		mode                   v1alpha1.RbacConfig_Mode
+		safeOnboarding         bool
+		inputServiceList       []*v1.Service
+		inputNamespaceList     []*v1.Namespace
+		inputPolicyTargets     []string
+		inputClusterRbacConfig model.Config
+		expectedServiceList    []string
+		expectedExclusionList  []string
+	}{
+		{
+			name:                  "Create: create ClusterRbacConfig in ON_WITH_EXCLUSION mode without any opted out services",
+			mode:                  v1alpha1.RbacConfig_ON_WITH_EXCLUSION,
+			inputServiceList:      []*v1.Service{notOnboardedService},
+			expectedExclusionList: []string{},
+		},
+		{
+			name:                  "Create: create ClusterRbacConfig in ON_WITH_EXCLUSION mode with opted out services",
+			mode:                  v1alpha1.RbacConfig_ON_WITH_EXCLUSION,
+			inputServiceList:      []*v1.Service{onboardedService, optedOutService},
+			expectedServiceList:   []string{onboardedServiceName},
+			expectedExclusionList: []string{optedOutServiceName},
+		},
+		{
+			name:                  "Create: exclude the services pending safe onboarding in ON_WITH_EXCLUSION mode",
+			mode:                  v1alpha1.RbacConfig_ON_WITH_EXCLUSION,
+			safeOnboarding:        true,
+			inputServiceList:      []*v1.Service{onboardedService, notOnboardedService, optedOutService},
+			expectedExclusionList: []string{onboardedServiceName, notOnboardedServiceName, optedOutServiceName},
+		},
+		{
+			name:                  "Create: do not exclude the services with a policy in ON_WITH_EXCLUSION mode",
+			mode:                  v1alpha1.RbacConfig_ON_WITH_EXCLUSION,
+			safeOnboarding:        true,
+			inputServiceList:      []*v1.Service{onboardedService, notOnboardedService, optedOutService},
+			inputPolicyTargets:    []string{"onboarded-service"},
+			expectedServiceList:   []string{onboardedServiceName},
+			expectedExclusionList: []string{notOnboardedServiceName, optedOutServiceName},
+		},
+		{
+			name:                   "Update: remove the service from the exclusion list once it has a policy in ON_WITH_EXCLUSION mode",
+			mode:                   v1alpha1.RbacConfig_ON_WITH_EXCLUSION,
+			safeOnboarding:         true,
+			inputServiceList:       []*v1.Service{notOnboardedService},
+			inputPolicyTargets:     []string{"*"},
+			inputClusterRbacConfig: createClusterRbacExclusionConfig([]string{notOnboardedServiceName}),
+			expectedExclusionList:  []string{},
+		},
+		{
+			name:                   "Update: switch ClusterRbacConfig from ON_WITH_INCLUSION to ON_WITH_EXCLUSION mode",
+			mode:                   v1alpha1.RbacConfig_ON_WITH_EXCLUSION,
+			inputServiceList:       []*v1.Service{onboardedService, optedOutService},
+			inputClusterRbacConfig: newClusterRbacConfig(v1alpha1.RbacConfig_ON_WITH_INCLUSION, onboardedTargets{services: []string{onboardedServiceName}}),
+			expectedServiceList:    []string{onboardedServiceName},
+			expectedExclusionList:  []string{optedOutServiceName},
+		},
+		{
+			name:                   "Update: switch ClusterRbacConfig from ON_WITH_EXCLUSION to ON_WITH_INCLUSION mode",
+			mode:                   v1alpha1.RbacConfig_ON_WITH_INCLUSION,
+			inputServiceList:       []*v1.Service{onboardedService, optedOutService},
+			inputClusterRbacConfig: createClusterRbacExclusionConfig([]string{optedOutServiceName}),
+			expectedServiceList:    []string{onboardedServiceName},
+			expectedExclusionList:  []string{optedOutServiceName},
+		},
+		{
+			name:                   "Update: keep ClusterRbacConfig in ON mode when no service is onboarded",
+			mode:                   v1alpha1.RbacConfig_ON,
+			inputServiceList:       []*v1.Service{notOnboardedService},
+			inputClusterRbacConfig: newClusterRbacConfig(v1alpha1.RbacConfig_ON_WITH_INCLUSION, onboardedTargets{services: []string{notOnboardedServiceName}}),
+			expectedExclusionList:  []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stopCh := make(chan struct{})
+			c := newFakeController(tt.inputServiceList, tt.inputNamespaceList, true, stopCh)
+			c.mode = tt.mode
+			c.safeOnboarding = tt.safeOnboarding
+			if tt.inputPolicyTargets != nil {
+				c.UpdatePolicyTargets("test-namespace", tt.inputPolicyTargets)
+			}
+
+			if tt.inputClusterRbacConfig.Spec != nil {
+				_, err := c.configStoreCache.Create(tt.inputClusterRbacConfig)
+				assert.Nil(t, err, "creating the ClusterRbacConfig should return nil")
+			}
+
+			err := c.sync()
+			assert.Nil(t, err, "sync error should be nil")
+
+			// Add a sleep for processing controller to work on the queue
+			time.Sleep(100 * time.Millisecond)
+
+			clusterRbacConfig, err := getClusterRbacConfig(c)
+			assert.Nil(t, err, fmt.Sprintf("error should be nil for getClusterRbacConfig: %s", err))
+			assert.Equal(t, tt.mode, clusterRbacConfig.Mode, "ClusterRbacConfig mode should be equal to the configured mode")
+			assert.Nil(t, model.ValidateClusterRbacConfig(model.DefaultRbacConfigName, "", clusterRbacConfig), "ClusterRbacConfig should be valid")
+			var inclusion, exclusion []string
+			if clusterRbacConfig.Inclusion != nil {
+				inclusion = clusterRbacConfig.Inclusion.Services
+			}
+			if clusterRbacConfig.Exclusion != nil {
+				exclusion = clusterRbacConfig.Exclusion.Services
+			}
+			assert.True(t, equalLists(tt.expectedServiceList, inclusion), "ClusterRbacConfig inclusion service list should be equal to the expected service list")
+			assert.True(t, equalLists(tt.expectedExclusionList, exclusion), "ClusterRbacConfig exclusion service list should be equal to the expected exclusion list")
+			close(stopCh)
+		})
+	}
+}
+
 func TestResync(t *testing.T) {
 	c := &Controller{
 		queue:             workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
 		crcResyncInterval: time.Second * 1,
+		index:             newNamespaceIndex(),
 	}
 
 	stopCh := make(chan struct{})
@@ -466,3 +1015,26 @@ func TestRemoveIndexElement(t *testing.T) {
 		})
 	}
 }
+
+func TestRunStop(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	c := newFakeController(nil, nil, false, stopCh)
+	c.crcResyncInterval = time.Hour
+
+	runStopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run(runStopCh)
+	}()
+	close(runStopCh)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Run should return once stopped")
+	}
+	c.queue.Add(queueKey)
+	assert.Equal(t, 0, c.queue.Len(), "no key should be accepted once stopped")
+}
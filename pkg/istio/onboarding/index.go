@@ -0,0 +1,147 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package onboarding
+
+import (
+	"sort"
+	"sync"
+
+	"k8s.io/api/core/v1"
+)
+
+const (
+	serviceResource   = "service"
+	namespaceResource = "namespace"
+)
+
+// namespaceIndex holds the onboarded targets computed per namespace. Events
+// only mark their namespace as dirty, the next sync recomputes the dirty
+// namespaces and reuses the targets of the others. The namespace of every
+// service host computed is remembered, so that the hosts removed from a
+// namespace are still attributed to it.
+type namespaceIndex struct {
+	lock    sync.Mutex
+	dirty   map[string]bool
+	rebuild bool
+	// shards and hosts are only accessed by the sync worker
+	shards map[string]onboardedTargets
+	hosts  map[string]string
+}
+
+// newNamespaceIndex returns an index which is fully rebuilt on the first sync
+func newNamespaceIndex() *namespaceIndex {
+	return &namespaceIndex{
+		dirty:   make(map[string]bool),
+		rebuild: true,
+		shards:  make(map[string]onboardedTargets),
+		hosts:   make(map[string]string),
+	}
+}
+
+// markDirty marks a namespace for recomputation on the next sync
+func (i *namespaceIndex) markDirty(namespace string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.dirty[namespace] = true
+}
+
+// markAllDirty marks every namespace for recomputation on the next sync
+func (i *namespaceIndex) markAllDirty() {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.rebuild = true
+}
+
+// takeDirty returns and resets the dirty namespaces and the rebuild flag
+func (i *namespaceIndex) takeDirty() (map[string]bool, bool) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	dirty, rebuild := i.dirty, i.rebuild
+	i.dirty = make(map[string]bool)
+	i.rebuild = false
+	return dirty, rebuild
+}
+
+// hasTargets returns true if any of the target lists is not empty
+func (t onboardedTargets) hasTargets() bool {
+	return len(t.namespaces) > 0 || len(t.services) > 0 || len(t.excludedNamespaces) > 0 ||
+		len(t.excludedServices) > 0 || len(t.pendingServices) > 0
+}
+
+// updateIndex recomputes the targets of the dirty namespaces, or of all the
+// namespaces if a rebuild was requested, and returns the merged targets of the
+// cluster
+func (c *Controller) updateIndex() onboardedTargets {
+	dirty, rebuild := c.index.takeDirty()
+	if rebuild {
+		c.index.shards = make(map[string]onboardedTargets)
+		dirty = make(map[string]bool)
+		for _, namespace := range c.getNamespaces() {
+			dirty[namespace] = true
+		}
+	}
+
+	for namespace := range dirty {
+		targets := c.getNamespaceTargets(namespace)
+		for _, hosts := range [][]string{targets.services, targets.excludedServices, targets.pendingServices} {
+			for _, host := range hosts {
+				c.index.hosts[host] = namespace
+			}
+		}
+		if !targets.hasTargets() {
+			delete(c.index.shards, namespace)
+			continue
+		}
+		c.index.shards[namespace] = targets
+	}
+
+	namespaces := make([]string, 0, len(c.index.shards))
+	for namespace := range c.index.shards {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	namespaceTargets := make([]onboardedTargets, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		namespaceTargets = append(namespaceTargets, c.index.shards[namespace])
+	}
+	return mergeTargets(namespaceTargets)
+}
+
+// serviceChanged returns true if an update of a service modified one of the
+// fields used to compute the onboarded targets
+func serviceChanged(oldObj, newObj interface{}) bool {
+	oldSvc, ok := oldObj.(*v1.Service)
+	if !ok {
+		return true
+	}
+	newSvc, ok := newObj.(*v1.Service)
+	if !ok {
+		return true
+	}
+
+	for _, annotation := range []string{authzEnabledAnnotation, skipPolicyCheck, dnsSuffixAnnotation, additionalHosts} {
+		if oldSvc.Annotations[annotation] != newSvc.Annotations[annotation] {
+			return true
+		}
+	}
+	return oldSvc.Spec.Selector[svcLabel] != newSvc.Spec.Selector[svcLabel]
+}
+
+// namespaceChanged returns true if an update of a namespace modified one of
+// the fields used to compute the onboarded targets
+func namespaceChanged(oldObj, newObj interface{}) bool {
+	oldNs, ok := oldObj.(*v1.Namespace)
+	if !ok {
+		return true
+	}
+	newNs, ok := newObj.(*v1.Namespace)
+	if !ok {
+		return true
+	}
+
+	return oldNs.Labels[authzEnabledAnnotation] != newNs.Labels[authzEnabledAnnotation] ||
+		oldNs.Annotations[authzEnabledAnnotation] != newNs.Annotations[authzEnabledAnnotation] ||
+		oldNs.Annotations[dnsSuffixAnnotation] != newNs.Annotations[dnsSuffixAnnotation]
+}
@@ -0,0 +1,149 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package onboarding
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateIndex(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	c := newFakeController([]*v1.Service{onboardedService, optedOutService}, nil, false, stopCh)
+
+	targets := c.updateIndex()
+	assert.Equal(t, []string{onboardedServiceName}, targets.services, "services should be computed on the first sync")
+	assert.Equal(t, []string{optedOutServiceName}, targets.excludedServices, "excluded services should be computed on the first sync")
+	assert.Equal(t, 1, len(c.index.shards), "index should hold one namespace")
+
+	// the cached targets are reused until the namespace is marked dirty
+	err := c.serviceIndexInformer.GetIndexer().Delete(onboardedService)
+	assert.Nil(t, err, "deleting the service from the indexer should return nil")
+	targets = c.updateIndex()
+	assert.Equal(t, []string{onboardedServiceName}, targets.services, "services of a clean namespace should not be recomputed")
+
+	c.index.markDirty("test-namespace")
+	targets = c.updateIndex()
+	assert.Equal(t, []string{}, targets.services, "services of a dirty namespace should be recomputed")
+	assert.Equal(t, []string{optedOutServiceName}, targets.excludedServices, "excluded services should be recomputed")
+
+	err = c.serviceIndexInformer.GetIndexer().Delete(optedOutService)
+	assert.Nil(t, err, "deleting the service from the indexer should return nil")
+	c.index.markAllDirty()
+	targets = c.updateIndex()
+	assert.False(t, targets.hasTargets(), "targets should be empty after a rebuild")
+	assert.Equal(t, 0, len(c.index.shards), "namespaces without targets should be removed from the index")
+}
+
+func TestMarkDirty(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	c := newFakeController(nil, nil, false, stopCh)
+
+	c.markDirty("namespace-a")
+	c.markDirty("namespace-b")
+	assert.Equal(t, 1, c.queue.Len(), "events should be batched into a single queue key")
+
+	dirty, rebuild := c.index.takeDirty()
+	assert.Equal(t, map[string]bool{"namespace-a": true, "namespace-b": true}, dirty, "dirty namespaces should be equal")
+	assert.True(t, rebuild, "a new index should be rebuilt on the first sync")
+
+	dirty, rebuild = c.index.takeDirty()
+	assert.Equal(t, map[string]bool{}, dirty, "dirty namespaces should be reset")
+	assert.False(t, rebuild, "rebuild should be reset")
+}
+
+func TestServiceChanged(t *testing.T) {
+	relabeled := onboardedService.DeepCopy()
+	relabeled.Labels = map[string]string{"app": "test"}
+	suffixed := onboardedService.DeepCopy()
+	suffixed.Annotations[dnsSuffixAnnotation] = "example.com"
+	selected := onboardedService.DeepCopy()
+	selected.Spec.Selector = map[string]string{svcLabel: "other"}
+
+	tests := []struct {
+		name     string
+		oldObj   interface{}
+		newObj   interface{}
+		expected bool
+	}{
+		{
+			name:     "should ignore unrelated changes",
+			oldObj:   onboardedService,
+			newObj:   relabeled,
+			expected: false,
+		},
+		{
+			name:     "should detect authz annotation changes",
+			oldObj:   onboardedService,
+			newObj:   optedOutService,
+			expected: true,
+		},
+		{
+			name:     "should detect dns suffix changes",
+			oldObj:   onboardedService,
+			newObj:   suffixed,
+			expected: true,
+		},
+		{
+			name:     "should detect svc selector changes",
+			oldObj:   onboardedService,
+			newObj:   selected,
+			expected: true,
+		},
+		{
+			name:     "should treat unknown objects as changed",
+			oldObj:   onboardedService,
+			newObj:   "unknown",
+			expected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, serviceChanged(tt.oldObj, tt.newObj))
+		})
+	}
+}
+
+func TestNamespaceChanged(t *testing.T) {
+	relabeled := onboardedNamespace.DeepCopy()
+	relabeled.Labels["team"] = "test"
+
+	tests := []struct {
+		name     string
+		oldObj   interface{}
+		newObj   interface{}
+		expected bool
+	}{
+		{
+			name:     "should ignore unrelated changes",
+			oldObj:   onboardedNamespace,
+			newObj:   relabeled,
+			expected: false,
+		},
+		{
+			name:     "should detect authz label changes",
+			oldObj:   onboardedNamespace,
+			newObj:   notOnboardedNamespace,
+			expected: true,
+		},
+		{
+			name:     "should detect authz annotation changes",
+			oldObj:   notOnboardedNamespace,
+			newObj:   annotatedNamespace,
+			expected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, namespaceChanged(tt.oldObj, tt.newObj))
+		})
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package onboarding
+
+import (
+	"fmt"
+
+	"istio.io/api/rbac/v1alpha1"
+	"istio.io/istio/pilot/pkg/config/kube/crd"
+	"istio.io/istio/pilot/pkg/model"
+
+	"k8s.io/api/core/v1"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/limits"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
+)
+
+const (
+	approveRemovalsAnnotation = "authz.istio.io/approve-removals"
+	removalsHeldReason        = "RemovalsHeld"
+)
+
+// newClusterRbacConfigReference returns the reference of the
+// ClusterRbacConfig to record events on
+func newClusterRbacConfigReference(config model.Config) *v1.ObjectReference {
+	return &v1.ObjectReference{
+		Kind:            crd.KebabCaseToCamelCase(config.Type),
+		APIVersion:      config.Group + "/" + config.Version,
+		Name:            config.Name,
+		ResourceVersion: config.ResourceVersion,
+	}
+}
+
+// enforcedTarget returns the target list of the ClusterRbacConfig whose
+// removals may deny traffic in the mode: the inclusion list removes authz in
+// ON_WITH_INCLUSION mode and the exclusion list enforces it in
+// ON_WITH_EXCLUSION mode. The ON mode does not use the lists.
+func enforcedTarget(mode v1alpha1.RbacConfig_Mode, rbacConfig *v1alpha1.RbacConfig) *v1alpha1.RbacConfig_Target {
+	switch mode {
+	case v1alpha1.RbacConfig_ON_WITH_INCLUSION:
+		return rbacConfig.Inclusion
+	case v1alpha1.RbacConfig_ON_WITH_EXCLUSION:
+		return rbacConfig.Exclusion
+	}
+	return nil
+}
+
+// targetRemovals returns the namespaces and services of the existing target
+// list which are not on the desired one, and the size of the existing list
+func targetRemovals(existing, desired *v1alpha1.RbacConfig_Target) ([]string, int) {
+	if existing == nil {
+		return nil, 0
+	}
+	if desired == nil {
+		desired = &v1alpha1.RbacConfig_Target{}
+	}
+
+	removed := compareServiceLists(existing.Namespaces, desired.Namespaces)
+	removed = append(removed, compareServiceLists(existing.Services, desired.Services)...)
+	return removed, len(existing.Namespaces) + len(existing.Services)
+}
+
+// holdRemovals returns true if the namespaces and services removed from the
+// inclusion list in ON_WITH_INCLUSION mode, or from the exclusion list in
+// ON_WITH_EXCLUSION mode, exceed the limits and were not approved with the
+// token annotation on the ClusterRbacConfig. The held change is logged,
+// counted and recorded as an event on the ClusterRbacConfig.
+func (c *Controller) holdRemovals(config model.Config, existing *v1alpha1.RbacConfig, targets onboardedTargets) bool {
+	desired := newClusterRbacSpec(c.mode, targets)
+	removed, total := targetRemovals(enforcedTarget(c.mode, existing), enforcedTarget(c.mode, desired))
+	err := c.removalLimits.Check(len(removed), total)
+	if err == nil {
+		return false
+	}
+
+	token := limits.Token(removed)
+	if config.Annotations[approveRemovalsAnnotation] == token {
+		logger.WithResource(writeKey()).WithError(err).Infof("Removals were approved on the cluster rbac config, applying them")
+		return false
+	}
+
+	message := fmt.Sprintf("%s, annotate the cluster rbac config with %s=%s to apply them", err.Error(), approveRemovalsAnnotation, token)
+	logger.WithResource(writeKey()).Warningf("Holding the sync of the cluster rbac config: %s", message)
+	metrics.HeldChanges.WithLabelValues(metrics.ControllerClusterRbacConfig).Inc()
+	if c.recorder != nil {
+		c.recorder.Event(newClusterRbacConfigReference(config), v1.EventTypeWarning, removalsHeldReason, message)
+	}
+	return true
+}
@@ -0,0 +1,142 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package onboarding
+
+import (
+	"testing"
+	"time"
+
+	"istio.io/api/rbac/v1alpha1"
+	"istio.io/istio/pilot/pkg/model"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/limits"
+)
+
+func TestSyncHeldRemovals(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	c := newFakeController([]*v1.Service{onboardedService}, nil, true, stopCh)
+	c.removalLimits = limits.Limits{MaxRemovals: 1}
+	recorder := record.NewFakeRecorder(1)
+	c.recorder = recorder
+
+	removed := []string{existingServiceName, otherServiceName}
+	_, err := c.configStoreCache.Create(newClusterRbacConfig(v1alpha1.RbacConfig_ON_WITH_INCLUSION, onboardedTargets{services: removed}))
+	assert.Nil(t, err, "creating the ClusterRbacConfig should return nil")
+
+	err = c.sync()
+	assert.Nil(t, err, "sync error should be nil")
+	time.Sleep(100 * time.Millisecond)
+
+	clusterRbacConfig, err := getClusterRbacConfig(c)
+	assert.Nil(t, err, "error should be nil")
+	assert.True(t, equalLists(removed, clusterRbacConfig.Inclusion.Services), "services should not be removed before the approval")
+	token := limits.Token(removed)
+	expected := "Warning RemovalsHeld 2 removals of 2 items exceed the limit of 1 removals, annotate the cluster rbac config with " + approveRemovalsAnnotation + "=" + token + " to apply them"
+	assert.Equal(t, expected, <-recorder.Events, "event should be equal")
+
+	config := c.configStoreCache.Get(model.ClusterRbacConfig.Type, model.DefaultRbacConfigName, "")
+	config.Annotations = map[string]string{approveRemovalsAnnotation: token}
+	_, err = c.configStoreCache.Update(*config)
+	assert.Nil(t, err, "updating the ClusterRbacConfig should return nil")
+
+	err = c.sync()
+	assert.Nil(t, err, "sync error should be nil")
+	time.Sleep(100 * time.Millisecond)
+
+	clusterRbacConfig, err = getClusterRbacConfig(c)
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, []string{onboardedServiceName}, clusterRbacConfig.Inclusion.Services, "services should be removed after the approval")
+}
+
+func TestTargetRemovals(t *testing.T) {
+	existing := &v1alpha1.RbacConfig_Target{
+		Namespaces: []string{"test-namespace", "other-namespace"},
+		Services:   []string{onboardedServiceName, existingServiceName},
+	}
+
+	removed, total := targetRemovals(existing, &v1alpha1.RbacConfig_Target{
+		Namespaces: []string{"test-namespace"},
+		Services:   []string{onboardedServiceName},
+	})
+	assert.Equal(t, []string{"other-namespace", existingServiceName}, removed, "removed namespaces and services should be equal")
+	assert.Equal(t, 4, total, "total should count the namespaces and services")
+
+	removed, total = targetRemovals(existing, nil)
+	assert.Equal(t, 4, len(removed), "every entry should be removed without a desired target")
+	assert.Equal(t, 4, total, "total should count the namespaces and services")
+
+	removed, total = targetRemovals(nil, existing)
+	assert.Equal(t, 0, len(removed), "nothing should be removed without an existing target")
+	assert.Equal(t, 0, total, "total should be 0 without an existing target")
+}
+
+func TestHoldRemovals(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     v1alpha1.RbacConfig_Mode
+		existing *v1alpha1.RbacConfig
+		targets  onboardedTargets
+		held     bool
+	}{
+		{
+			name:     "should hold the namespaces removed from the inclusion list",
+			mode:     v1alpha1.RbacConfig_ON_WITH_INCLUSION,
+			existing: &v1alpha1.RbacConfig{Inclusion: &v1alpha1.RbacConfig_Target{Namespaces: []string{"test-namespace", "other-namespace"}}},
+			targets:  newOnboardedTargets(),
+			held:     true,
+		},
+		{
+			name:     "should not hold the namespaces added to the inclusion list",
+			mode:     v1alpha1.RbacConfig_ON_WITH_INCLUSION,
+			existing: &v1alpha1.RbacConfig{Inclusion: &v1alpha1.RbacConfig_Target{}},
+			targets:  onboardedTargets{namespaces: []string{"test-namespace", "other-namespace"}},
+			held:     false,
+		},
+		{
+			name:     "should hold the services removed from the exclusion list",
+			mode:     v1alpha1.RbacConfig_ON_WITH_EXCLUSION,
+			existing: &v1alpha1.RbacConfig{Exclusion: &v1alpha1.RbacConfig_Target{Services: []string{onboardedServiceName, existingServiceName}}},
+			targets:  newOnboardedTargets(),
+			held:     true,
+		},
+		{
+			name:     "should hold the namespaces removed from the exclusion list",
+			mode:     v1alpha1.RbacConfig_ON_WITH_EXCLUSION,
+			existing: &v1alpha1.RbacConfig{Exclusion: &v1alpha1.RbacConfig_Target{Namespaces: []string{"test-namespace", "other-namespace"}}},
+			targets:  newOnboardedTargets(),
+			held:     true,
+		},
+		{
+			name:     "should not hold the inclusion list removals in ON_WITH_EXCLUSION mode",
+			mode:     v1alpha1.RbacConfig_ON_WITH_EXCLUSION,
+			existing: &v1alpha1.RbacConfig{Inclusion: &v1alpha1.RbacConfig_Target{Namespaces: []string{"test-namespace", "other-namespace"}}},
+			targets:  newOnboardedTargets(),
+			held:     false,
+		},
+		{
+			name:     "should not hold any removal in ON mode",
+			mode:     v1alpha1.RbacConfig_ON,
+			existing: &v1alpha1.RbacConfig{Exclusion: &v1alpha1.RbacConfig_Target{Namespaces: []string{"test-namespace", "other-namespace"}}},
+			targets:  newOnboardedTargets(),
+			held:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Controller{
+				mode:          tt.mode,
+				removalLimits: limits.Limits{MaxRemovals: 1},
+			}
+			config := newClusterRbacConfig(tt.mode, newOnboardedTargets())
+			assert.Equal(t, tt.held, c.holdRemovals(config, tt.existing, tt.targets), "held should be equal")
+		})
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package onboarding
+
+import (
+	"fmt"
+	"time"
+
+	"istio.io/api/rbac/v1alpha1"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/config"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/filter"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/limits"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/retry"
+)
+
+// Options configures the onboarding controller
+type Options struct {
+	DNSSuffix      string
+	Mode           v1alpha1.RbacConfig_Mode
+	SafeOnboarding bool
+	ResyncInterval time.Duration
+	Debounce       time.Duration
+	RetryPolicy    retry.Policy
+	Filter         *filter.Filter
+	Protected      filter.Protected
+	RemovalLimits  limits.Limits
+}
+
+// NewOptions returns the options of the onboarding controller from the config
+func NewOptions(cfg *config.Config) (Options, error) {
+	mode, err := cfg.CRCMode()
+	if err != nil {
+		return Options{}, fmt.Errorf("error parsing crc-mode: %s", err.Error())
+	}
+
+	namespaceFilter, err := cfg.NamespaceFilter()
+	if err != nil {
+		return Options{}, fmt.Errorf("error parsing the filter: %s", err.Error())
+	}
+
+	return Options{
+		DNSSuffix:      cfg.DNSSuffix,
+		Mode:           mode,
+		SafeOnboarding: cfg.ClusterRbacConfig.SafeOnboarding,
+		ResyncInterval: cfg.ClusterRbacConfig.ResyncInterval.Duration,
+		Debounce:       cfg.ClusterRbacConfig.Debounce.Duration,
+		RetryPolicy:    cfg.RetryPolicy(),
+		Filter:         namespaceFilter,
+		Protected:      filter.NewProtected(cfg.ProtectedNamespaces),
+		RemovalLimits:  cfg.ServiceRemovalLimits(),
+	}, nil
+}
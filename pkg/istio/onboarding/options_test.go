@@ -0,0 +1,41 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package onboarding
+
+import (
+	"testing"
+	"time"
+
+	"istio.io/api/rbac/v1alpha1"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/config"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/limits"
+)
+
+func TestNewOptions(t *testing.T) {
+	cfg := config.Default()
+	cfg.DNSSuffix = "cluster.example"
+	cfg.ClusterRbacConfig.Mode = "ON_WITH_EXCLUSION"
+	cfg.ClusterRbacConfig.SafeOnboarding = true
+	cfg.ClusterRbacConfig.Debounce.Duration = 3 * time.Second
+	cfg.Filter.NamespaceExclude = []string{"kube-system"}
+	cfg.Limits.MaxServiceRemovals = 7
+
+	opts, err := NewOptions(cfg)
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, "cluster.example", opts.DNSSuffix, "dns suffix should be equal")
+	assert.Equal(t, v1alpha1.RbacConfig_ON_WITH_EXCLUSION, opts.Mode, "mode should be equal")
+	assert.True(t, opts.SafeOnboarding, "safe onboarding should be set")
+	assert.Equal(t, time.Hour, opts.ResyncInterval, "resync interval should be equal")
+	assert.Equal(t, 3*time.Second, opts.Debounce, "debounce should be equal")
+	assert.False(t, opts.Filter.Empty(), "filter should not be empty")
+	assert.True(t, opts.Protected.Contains("istio-system"), "system namespaces should be protected")
+	assert.Equal(t, limits.Limits{MaxRemovals: 7}, opts.RemovalLimits, "removal limits should be equal")
+
+	cfg.ClusterRbacConfig.Mode = "OFF"
+	_, err = NewOptions(cfg)
+	assert.NotNil(t, err, "error should not be nil for an invalid mode")
+}
@@ -0,0 +1,197 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package onboarding
+
+import (
+	"strings"
+
+	"istio.io/api/networking/v1alpha3"
+	"istio.io/api/rbac/v1alpha1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/filter"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
+)
+
+// namespaceInScope returns true if the namespace is matched by the filter of
+// the controller
+func (c *Controller) namespaceInScope(namespace string) bool {
+	if c.filter.Empty() {
+		return true
+	}
+	return c.filter.Namespace(namespace, c.namespaceIndexInformer.GetIndexer())
+}
+
+// namespaceScopeChanged returns true if an update of a namespace moved it in
+// or out of the scope of the filter
+func (c *Controller) namespaceScopeChanged(oldObj, newObj interface{}) bool {
+	oldNs, ok := oldObj.(*v1.Namespace)
+	if !ok {
+		return true
+	}
+	newNs, ok := newObj.(*v1.Namespace)
+	if !ok {
+		return true
+	}
+	return c.filter.NamespaceObject(oldNs) != c.filter.NamespaceObject(newNs)
+}
+
+// hostNamespace returns the namespace of a service hostname of the form
+// name.namespace.dnsSuffix, or an empty string for any other host
+func hostNamespace(host, dnsSuffix string) string {
+	if dnsSuffix == "" || !strings.HasSuffix(host, "."+dnsSuffix) {
+		return ""
+	}
+	parts := strings.Split(strings.TrimSuffix(host, "."+dnsSuffix), ".")
+	if len(parts) != 2 {
+		return ""
+	}
+	return parts[1]
+}
+
+// hostNamespaces returns the namespace of the source object of the hosts
+// known to the controller: the hosts of the ServiceEntries of every namespace
+// and the hostnames of the services it indexed
+func (c *Controller) hostNamespaces() map[string]string {
+	namespaces := make(map[string]string, len(c.index.hosts))
+	for host, namespace := range c.index.hosts {
+		namespaces[host] = namespace
+	}
+
+	serviceEntries, err := c.listServiceEntries(v1.NamespaceAll)
+	if err != nil {
+		logger.WithError(err).Errorf("Error listing the ServiceEntry resources")
+	}
+	for _, serviceEntry := range serviceEntries {
+		spec, ok := serviceEntry.Spec.(*v1alpha3.ServiceEntry)
+		if !ok {
+			continue
+		}
+		for _, host := range spec.Hosts {
+			namespaces[host] = serviceEntry.Namespace
+		}
+	}
+	return namespaces
+}
+
+// outOfScopeTargets returns the namespaces and services listed on the
+// ClusterRbacConfig which belong to namespaces outside of the scope of the
+// filter, they are managed by other instances of the controller and are kept
+// as is. Services are attributed to the namespace of their source object, or
+// of their hostname if it ends with the cluster dns suffix. Services which
+// cannot be attributed are kept as well.
+func (c *Controller) outOfScopeTargets(clusterRbacConfig *v1alpha1.RbacConfig) onboardedTargets {
+	targets := newOnboardedTargets()
+	if c.filter.Empty() || clusterRbacConfig == nil {
+		return targets
+	}
+
+	hosts := c.hostNamespaces()
+	if clusterRbacConfig.Inclusion != nil {
+		targets.namespaces = c.outOfScopeNamespaces(clusterRbacConfig.Inclusion.Namespaces)
+		targets.services = c.outOfScopeServices(clusterRbacConfig.Inclusion.Services, hosts)
+	}
+	if clusterRbacConfig.Exclusion != nil {
+		targets.excludedNamespaces = c.outOfScopeNamespaces(clusterRbacConfig.Exclusion.Namespaces)
+		targets.excludedServices = c.outOfScopeServices(clusterRbacConfig.Exclusion.Services, hosts)
+	}
+	return targets
+}
+
+// outOfScopeNamespaces returns the namespaces which are not matched by the filter
+func (c *Controller) outOfScopeNamespaces(namespaces []string) []string {
+	outOfScope := make([]string, 0)
+	for _, namespace := range namespaces {
+		if !c.namespaceInScope(namespace) {
+			outOfScope = append(outOfScope, namespace)
+		}
+	}
+	return outOfScope
+}
+
+// outOfScopeServices returns the services whose namespace is not matched by
+// the filter or is unknown
+func (c *Controller) outOfScopeServices(services []string, hosts map[string]string) []string {
+	outOfScope := make([]string, 0)
+	for _, service := range services {
+		namespace, exists := hosts[service]
+		if !exists {
+			namespace = hostNamespace(service, c.dnsSuffix)
+		}
+		if namespace == "" || !c.namespaceInScope(namespace) {
+			outOfScope = append(outOfScope, service)
+		}
+	}
+	return outOfScope
+}
+
+// protectedInclusions returns the namespaces and services of the protected
+// namespaces in the scope of the filter which are listed on the
+// ClusterRbacConfig inclusion list. They were onboarded before the namespace
+// was protected and are kept, as removing them would lift the authz enforced
+// on them without anyone asking for it.
+func (c *Controller) protectedInclusions(clusterRbacConfig *v1alpha1.RbacConfig) onboardedTargets {
+	targets := newOnboardedTargets()
+	if len(c.protected) == 0 || clusterRbacConfig == nil || clusterRbacConfig.Inclusion == nil {
+		return targets
+	}
+
+	for _, namespace := range clusterRbacConfig.Inclusion.Namespaces {
+		if c.protected.Contains(namespace) && c.namespaceInScope(namespace) {
+			targets.namespaces = append(targets.namespaces, namespace)
+		}
+	}
+
+	hosts := c.hostNamespaces()
+	for _, service := range clusterRbacConfig.Inclusion.Services {
+		namespace, exists := hosts[service]
+		if !exists {
+			namespace = hostNamespace(service, c.dnsSuffix)
+		}
+		if c.protected.Contains(namespace) && c.namespaceInScope(namespace) {
+			targets.services = append(targets.services, service)
+		}
+	}
+	return targets
+}
+
+// getProtectedTargets returns the targets of a protected namespace, which is
+// added to the exclusion list so that authz is not enforced on it in the
+// ON_WITH_EXCLUSION mode. The namespace and services which opted in with the
+// authz label or annotation are reported.
+func (c *Controller) getProtectedTargets(namespace string) onboardedTargets {
+	targets := newOnboardedTargets()
+	targets.excludedNamespaces = append(targets.excludedNamespaces, namespace)
+
+	namespaceRaw, exists, err := c.namespaceIndexInformer.GetIndexer().GetByKey(namespace)
+	if err == nil && exists {
+		if ns, ok := namespaceRaw.(*v1.Namespace); ok && getNamespaceAuthz(ns) == authzEnabled {
+			c.reportProtected(ns, metrics.ResourceNamespace, namespace)
+		}
+	}
+
+	services, err := c.serviceIndexInformer.GetIndexer().ByIndex(cache.NamespaceIndex, namespace)
+	if err != nil {
+		logger.WithNamespace(namespace).WithError(err).Errorf("Error listing the services of the namespace")
+	}
+	for _, service := range services {
+		if svc, ok := service.(*v1.Service); ok && svc.Annotations[authzEnabledAnnotation] == authzEnabled {
+			c.reportProtected(svc, metrics.ResourceService, namespace)
+		}
+	}
+	return targets
+}
+
+// reportProtected logs, records an event and counts a namespace or service
+// which opted in to authz in a protected namespace
+func (c *Controller) reportProtected(obj runtime.Object, resource, namespace string) {
+	err := c.protected.Error(namespace)
+	logger.WithNamespace(namespace).WithError(err).Warningf("Refusing to onboard the %s", resource)
+	metrics.ProtectedNamespaceViolations.WithLabelValues(resource).Inc()
+	if c.recorder != nil {
+		c.recorder.Event(obj, v1.EventTypeWarning, filter.ProtectedNamespaceReason, err.Error())
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package onboarding
+
+import (
+	"testing"
+	"time"
+
+	"istio.io/api/rbac/v1alpha1"
+	"istio.io/istio/pilot/pkg/config/memory"
+	"istio.io/istio/pilot/pkg/model"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/filter"
+)
+
+var otherServiceName = "other-service.other-namespace.svc.cluster.local"
+
+func TestHostNamespace(t *testing.T) {
+	assert.Equal(t, "test-namespace", hostNamespace(onboardedServiceName, dnsSuffix), "namespace should be equal")
+	assert.Equal(t, "", hostNamespace("onboarded-service", dnsSuffix), "short hostname should have no namespace")
+	assert.Equal(t, "", hostNamespace("api.example.com", dnsSuffix), "external host should have no namespace")
+	assert.Equal(t, "", hostNamespace("a.b.c.svc.cluster.local", dnsSuffix), "hostname with a subdomain should have no namespace")
+	assert.Equal(t, "", hostNamespace(onboardedServiceName, ""), "hostname should have no namespace without a dns suffix")
+}
+
+func TestOutOfScopeServices(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	c := newFakeController([]*v1.Service{onboardedService}, nil, false, stopCh)
+	c.configStoreCache = memory.NewController(memory.Make(model.ConfigDescriptor{
+		model.ClusterRbacConfig,
+		model.ServiceEntry,
+	}))
+	external := newServiceEntry("external", "true", []string{"external.example.com"})
+	external.Namespace = "other-namespace"
+	_, err := c.configStoreCache.Create(external)
+	assert.Nil(t, err, "creating the ServiceEntry should return nil")
+
+	f, err := filter.New([]string{"test-namespace"}, nil, "", "", "", "")
+	assert.Nil(t, err, "error should be nil")
+	c.filter = f
+	c.index.hosts["removed.example.com"] = "test-namespace"
+
+	services := []string{
+		onboardedServiceName,
+		otherServiceName,
+		"external.example.com",
+		"removed.example.com",
+		"unknown.example.com",
+	}
+	expected := []string{otherServiceName, "external.example.com", "unknown.example.com"}
+	assert.Equal(t, expected, c.outOfScopeServices(services, c.hostNamespaces()), "out of scope services should be equal")
+}
+
+func TestGetNamespaceTargetsOutOfScope(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	c := newFakeController([]*v1.Service{onboardedService}, []*v1.Namespace{onboardedNamespace}, false, stopCh)
+
+	targets := c.getNamespaceTargets("test-namespace")
+	assert.Equal(t, []string{"test-namespace"}, targets.namespaces, "namespace should be onboarded without a filter")
+
+	f, err := filter.New(nil, []string{"test-namespace"}, "", "", "", "")
+	assert.Nil(t, err, "error should be nil")
+	c.filter = f
+	targets = c.getNamespaceTargets("test-namespace")
+	assert.False(t, targets.hasTargets(), "namespace outside of the scope should have no targets")
+}
+
+func TestSyncOutOfScope(t *testing.T) {
+	tests := []struct {
+		name                       string
+		includeNamespaces          []string
+		existingTargets            onboardedTargets
+		expectedNamespaces         []string
+		expectedServices           []string
+		expectedExcludedNamespaces []string
+	}{
+		{
+			name:              "should keep the targets of the namespaces outside of the scope",
+			includeNamespaces: []string{"test-namespace"},
+			existingTargets: onboardedTargets{
+				namespaces:         []string{"other-namespace"},
+				services:           []string{otherServiceName, existingServiceName},
+				excludedNamespaces: []string{"opted-out-namespace"},
+			},
+			expectedNamespaces:         []string{"other-namespace"},
+			expectedServices:           []string{otherServiceName, onboardedServiceName},
+			expectedExcludedNamespaces: []string{"opted-out-namespace"},
+		},
+		{
+			name:               "should replace all the targets without a filter",
+			existingTargets:    onboardedTargets{namespaces: []string{"other-namespace"}, services: []string{otherServiceName}},
+			expectedNamespaces: []string{},
+			expectedServices:   []string{onboardedServiceName},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stopCh := make(chan struct{})
+			defer close(stopCh)
+			c := newFakeController([]*v1.Service{onboardedService}, nil, true, stopCh)
+			if tt.includeNamespaces != nil {
+				f, err := filter.New(tt.includeNamespaces, nil, "", "", "", "")
+				assert.Nil(t, err, "error should be nil")
+				c.filter = f
+			}
+
+			_, err := c.configStoreCache.Create(newClusterRbacConfig(v1alpha1.RbacConfig_ON_WITH_INCLUSION, tt.existingTargets))
+			assert.Nil(t, err, "creating the ClusterRbacConfig should return nil")
+
+			err = c.sync()
+			assert.Nil(t, err, "sync error should be nil")
+			time.Sleep(100 * time.Millisecond)
+
+			clusterRbacConfig, err := getClusterRbacConfig(c)
+			assert.Nil(t, err, "error should be nil")
+			assert.True(t, equalLists(tt.expectedNamespaces, clusterRbacConfig.Inclusion.Namespaces), "inclusion namespaces should be equal")
+			assert.True(t, equalLists(tt.expectedServices, clusterRbacConfig.Inclusion.Services), "inclusion services should be equal")
+			if len(tt.expectedExcludedNamespaces) > 0 {
+				assert.Equal(t, tt.expectedExcludedNamespaces, clusterRbacConfig.Exclusion.Namespaces, "exclusion namespaces should be equal")
+			}
+		})
+	}
+}
+
+func TestGetProtectedTargets(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	c := newFakeController([]*v1.Service{onboardedService, notOnboardedService}, []*v1.Namespace{onboardedNamespace}, false, stopCh)
+	c.protected = filter.NewProtected([]string{"test-namespace"})
+	recorder := record.NewFakeRecorder(2)
+	c.recorder = recorder
+
+	targets := c.getNamespaceTargets("test-namespace")
+	assert.Equal(t, []string{}, targets.namespaces, "protected namespace should not be onboarded")
+	assert.Equal(t, []string{}, targets.services, "services of a protected namespace should not be onboarded")
+	assert.Equal(t, []string{"test-namespace"}, targets.excludedNamespaces, "protected namespace should be excluded")
+
+	expected := "Warning ProtectedNamespace namespace test-namespace is protected, the controller never writes to it"
+	assert.Equal(t, expected, <-recorder.Events, "namespace event should be equal")
+	assert.Equal(t, expected, <-recorder.Events, "service event should be equal")
+}
+
+func TestSyncKeepsProtectedInclusions(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	c := newFakeController([]*v1.Service{onboardedService}, []*v1.Namespace{onboardedNamespace}, true, stopCh)
+	c.protected = filter.NewProtected([]string{"test-namespace"})
+
+	existing := onboardedTargets{
+		namespaces: []string{"test-namespace"},
+		services:   []string{onboardedServiceName, existingServiceName},
+	}
+	_, err := c.configStoreCache.Create(newClusterRbacConfig(v1alpha1.RbacConfig_ON_WITH_INCLUSION, existing))
+	assert.Nil(t, err, "creating the ClusterRbacConfig should return nil")
+
+	err = c.sync()
+	assert.Nil(t, err, "sync error should be nil")
+	time.Sleep(100 * time.Millisecond)
+
+	clusterRbacConfig, err := getClusterRbacConfig(c)
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, []string{"test-namespace"}, clusterRbacConfig.Inclusion.Namespaces, "inclusion of the protected namespace should be kept")
+	assert.True(t, equalLists([]string{onboardedServiceName, existingServiceName}, clusterRbacConfig.Inclusion.Services), "inclusions of the protected services should be kept")
+	assert.Equal(t, []string{"test-namespace"}, clusterRbacConfig.Exclusion.Namespaces, "protected namespace should be excluded")
+}
@@ -0,0 +1,204 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"istio.io/istio/pilot/pkg/model"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
+)
+
+// Batch holds the changes of a domain, which are applied in order as a unit.
+// OnApplied, if set, is called with the changes written by an application of
+// the batch and the error which stopped it, if any.
+type Batch struct {
+	Key       string
+	Items     []*Item
+	OnApplied func(applied []*Item, err error)
+}
+
+// BatchStatus is the result of the last application of a batch
+type BatchStatus struct {
+	Applied int
+	Total   int
+	Failed  *Item
+	Err     error
+	Time    time.Time
+}
+
+// String returns a human readable summary of the batch status
+func (s BatchStatus) String() string {
+	if s.Err == nil {
+		return fmt.Sprintf("applied %d/%d changes", s.Applied, s.Total)
+	}
+	return fmt.Sprintf("applied %d/%d changes, %s on %s failed: %s", s.Applied, s.Total, s.Failed.Operation,
+		s.Failed.Resource.Key(), s.Err.Error())
+}
+
+// itemRank returns the position of a change in a batch, so that a
+// ServiceRoleBinding never references a ServiceRole which does not exist:
+// 1. ServiceRoles are created or updated
+// 2. ServiceRoleBindings are created or updated
+// 3. ServiceRoleBindings are deleted
+// 4. ServiceRoles are deleted
+// Any other resource is applied last.
+func itemRank(item *Item) int {
+	switch item.Resource.Type {
+	case model.ServiceRole.Type:
+		if item.Operation == model.EventDelete {
+			return 3
+		}
+		return 0
+	case model.ServiceRoleBinding.Type:
+		if item.Operation == model.EventDelete {
+			return 2
+		}
+		return 1
+	}
+	return 4
+}
+
+// NewBatch returns a batch holding the items in the order they must be applied in
+func NewBatch(key string, items []*Item) *Batch {
+	ordered := make([]*Item, len(items))
+	copy(ordered, items)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return itemRank(ordered[i]) < itemRank(ordered[j])
+	})
+
+	return &Batch{
+		Key:   key,
+		Items: ordered,
+	}
+}
+
+// ProcessBatch is responsible for adding the batch to the queue
+func (c *Controller) ProcessBatch(batch *Batch) {
+	batchLogger(batch.Key).Infof("ProcessBatch() Batch added to queue, Changes: %d", len(batch.Items))
+	c.enqueue(batch.Key, batch)
+}
+
+// setStatus records the status of the last application of the batch or item of a key
+func (c *Controller) setStatus(key string, status BatchStatus) {
+	c.statusLock.Lock()
+	defer c.statusLock.Unlock()
+	c.status[key] = status
+}
+
+// ClearStatus removes the status of a key once its owner found nothing left to change
+func (c *Controller) ClearStatus(key string) {
+	c.statusLock.Lock()
+	defer c.statusLock.Unlock()
+	delete(c.status, key)
+}
+
+// BatchStatus returns the status of the last application of the batch of a
+// key, or of the item of a resource key
+func (c *Controller) BatchStatus(key string) (BatchStatus, bool) {
+	c.statusLock.RLock()
+	defer c.statusLock.RUnlock()
+	status, exists := c.status[key]
+	return status, exists
+}
+
+// syncBatch applies the items of the batch in order and stops at the first
+// failure. The error handler of the failed item is called so that the owner of
+// the batch can recompute and retry the whole batch, the items are idempotent
+// against the current state of the cluster.
+func (c *Controller) syncBatch(batch *Batch) {
+	status := BatchStatus{
+		Total: len(batch.Items),
+	}
+
+	for _, item := range batch.Items {
+		err := c.sync(item)
+		if err != nil {
+			status.Failed = item
+			status.Err = err
+			break
+		}
+		status.Applied++
+	}
+	status.Time = time.Now()
+	c.setStatus(batch.Key, status)
+	if batch.OnApplied != nil && status.Applied > 0 {
+		batch.OnApplied(batch.Items[:status.Applied], status.Err)
+	}
+
+	if status.Err == nil {
+		metrics.Batches.WithLabelValues(metrics.ResultSuccess).Inc()
+		batchLogger(batch.Key).Infof("syncBatch() Batch %s", status)
+		return
+	}
+
+	metrics.Batches.WithLabelValues(metrics.ResultFailure).Inc()
+	batchLogger(batch.Key).WithResource(status.Failed.Resource.Key()).WithOperation(status.Failed.Operation).WithError(status.Err).Errorf("syncBatch() Batch %s", status)
+	if status.Failed.ErrorHandler != nil {
+		status.Failed.ErrorHandler(status.Err, status.Failed)
+	}
+}
+
+// DryRunBatch checks the items of the batch in order against the config store
+// without writing them, and stops at the first item which would fail. The
+// resources are validated with the schema of their type and must exist, or
+// not exist for a creation. OnApplied, if set, is called with the changes the
+// batch would write and the error which would stop it. The status of the key
+// is left untouched.
+func (c *Controller) DryRunBatch(batch *Batch) BatchStatus {
+	status := BatchStatus{
+		Total: len(batch.Items),
+	}
+
+	for _, item := range batch.Items {
+		err := c.validate(item)
+		if err != nil {
+			status.Failed = item
+			status.Err = err
+			break
+		}
+		status.Applied++
+	}
+	status.Time = time.Now()
+	if batch.OnApplied != nil && status.Applied > 0 {
+		batch.OnApplied(batch.Items[:status.Applied], status.Err)
+	}
+
+	if status.Err == nil {
+		batchLogger(batch.Key).Infof("DryRunBatch() Batch %s", status)
+		return status
+	}
+	batchLogger(batch.Key).WithResource(status.Failed.Resource.Key()).WithOperation(status.Failed.Operation).WithError(status.Err).Warningf("DryRunBatch() Batch %s", status)
+	return status
+}
+
+// validate returns the error the config store would return for the item
+func (c *Controller) validate(item *Item) error {
+	res := item.Resource
+	schema, exists := c.configStoreCache.ConfigDescriptor().GetByType(res.Type)
+	if !exists {
+		return fmt.Errorf("unknown type %s", res.Type)
+	}
+
+	existing := c.configStoreCache.Get(res.Type, res.Name, res.Namespace)
+	switch item.Operation {
+	case model.EventAdd:
+		if existing != nil {
+			return errors.New("item already exists")
+		}
+	case model.EventUpdate, model.EventDelete:
+		if existing == nil {
+			return errors.New("item not found")
+		}
+	}
+
+	if item.Operation == model.EventDelete {
+		return nil
+	}
+	return schema.Validate(res.Name, res.Namespace, res.Spec)
+}
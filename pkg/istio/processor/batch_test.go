@@ -0,0 +1,145 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package processor
+
+import (
+	"testing"
+
+	"istio.io/api/rbac/v1alpha1"
+	"istio.io/istio/pilot/pkg/config/memory"
+	"istio.io/istio/pilot/pkg/model"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/retry"
+)
+
+func TestNewBatch(t *testing.T) {
+	items := []*Item{
+		{Operation: model.EventDelete, Resource: newSr("test-ns", "deleted-role")},
+		{Operation: model.EventAdd, Resource: newSrb("test-ns", "added-role")},
+		{Operation: model.EventDelete, Resource: newSrb("test-ns", "deleted-role")},
+		{Operation: model.EventAdd, Resource: newSr("test-ns", "added-role")},
+		{Operation: model.EventUpdate, Resource: newSr("test-ns", "updated-role")},
+	}
+
+	batch := NewBatch("test-ns/test.ns", items)
+	assert.Equal(t, "test-ns/test.ns", batch.Key, "key should be equal")
+	assert.Equal(t, []*Item{items[3], items[4], items[1], items[2], items[0]}, batch.Items, "items should be ordered")
+	assert.Equal(t, model.EventDelete, items[0].Operation, "input items should not be reordered")
+}
+
+func TestSyncBatch(t *testing.T) {
+	configDescriptor := model.ConfigDescriptor{
+		model.ServiceRole,
+		model.ServiceRoleBinding,
+	}
+
+	t.Run("should apply all the items of the batch", func(t *testing.T) {
+		c := NewController(memory.NewController(memory.Make(configDescriptor)), 1, retry.DefaultPolicy(), retry.NewDeadLetters(), nil)
+		c.syncBatch(NewBatch("test-ns/test.ns", []*Item{
+			{Operation: model.EventAdd, Resource: newSrb("test-ns", "test-role")},
+			{Operation: model.EventAdd, Resource: newSr("test-ns", "test-role")},
+		}))
+
+		status, exists := c.BatchStatus("test-ns/test.ns")
+		assert.True(t, exists, "status should exist")
+		assert.Nil(t, status.Err, "status error should be nil")
+		assert.Equal(t, 2, status.Applied, "applied changes should be equal")
+		assert.Equal(t, 2, status.Total, "total changes should be equal")
+		assert.NotNil(t, c.configStoreCache.Get(model.ServiceRole.Type, "test-role", "test-ns"), "ServiceRole should be created")
+		assert.NotNil(t, c.configStoreCache.Get(model.ServiceRoleBinding.Type, "test-role", "test-ns"), "ServiceRoleBinding should be created")
+	})
+
+	t.Run("should stop at the first failure and call the error handler", func(t *testing.T) {
+		c := NewController(memory.NewController(memory.Make(configDescriptor)), 1, retry.DefaultPolicy(), retry.NewDeadLetters(), nil)
+		var handled *Item
+		errHandler := func(err error, item *Item) error {
+			handled = item
+			return nil
+		}
+		failing := &Item{Operation: model.EventUpdate, Resource: newSr("test-ns", "missing-role"), ErrorHandler: errHandler}
+		c.syncBatch(NewBatch("test-ns/test.ns", []*Item{
+			{Operation: model.EventAdd, Resource: newSrb("test-ns", "test-role"), ErrorHandler: errHandler},
+			failing,
+		}))
+
+		status, exists := c.BatchStatus("test-ns/test.ns")
+		assert.True(t, exists, "status should exist")
+		assert.NotNil(t, status.Err, "status error should not be nil")
+		assert.Equal(t, failing, status.Failed, "failed item should be equal")
+		assert.Equal(t, 0, status.Applied, "applied changes should be equal")
+		assert.Equal(t, failing, handled, "error handler should be called with the failed item")
+		assert.Nil(t, c.configStoreCache.Get(model.ServiceRoleBinding.Type, "test-role", "test-ns"), "ServiceRoleBinding should not be created after a failure")
+	})
+	t.Run("should report the applied items of a partially applied batch", func(t *testing.T) {
+		c := NewController(memory.NewController(memory.Make(configDescriptor)), 1, retry.DefaultPolicy(), retry.NewDeadLetters(), nil)
+		var applied []*Item
+		var appliedErr error
+		batch := NewBatch("test-ns/test.ns", []*Item{
+			{Operation: model.EventAdd, Resource: newSr("test-ns", "test-role")},
+			{Operation: model.EventUpdate, Resource: newSr("test-ns", "missing-role")},
+		})
+		batch.OnApplied = func(items []*Item, err error) {
+			applied = items
+			appliedErr = err
+		}
+		c.syncBatch(batch)
+
+		assert.Equal(t, batch.Items[:1], applied, "applied items should be equal")
+		assert.NotNil(t, appliedErr, "error of the batch should be reported")
+	})
+}
+
+func TestDryRunBatch(t *testing.T) {
+	configDescriptor := model.ConfigDescriptor{
+		model.ServiceRole,
+		model.ServiceRoleBinding,
+	}
+
+	t.Run("should check all the items of the batch without writing them", func(t *testing.T) {
+		c := NewController(memory.NewController(memory.Make(configDescriptor)), 1, retry.DefaultPolicy(), retry.NewDeadLetters(), nil)
+		var applied []*Item
+		batch := NewBatch("test-ns/test.ns", []*Item{
+			{Operation: model.EventAdd, Resource: newSrb("test-ns", "test-role")},
+			{Operation: model.EventAdd, Resource: newSr("test-ns", "test-role")},
+		})
+		batch.OnApplied = func(items []*Item, err error) {
+			applied = items
+		}
+		status := c.DryRunBatch(batch)
+
+		assert.Nil(t, status.Err, "status error should be nil")
+		assert.Equal(t, 2, status.Applied, "applied changes should be equal")
+		assert.Equal(t, batch.Items, applied, "applied items should be equal")
+		assert.Nil(t, c.configStoreCache.Get(model.ServiceRole.Type, "test-role", "test-ns"), "ServiceRole should not be created")
+		assert.Nil(t, c.configStoreCache.Get(model.ServiceRoleBinding.Type, "test-role", "test-ns"), "ServiceRoleBinding should not be created")
+		_, exists := c.BatchStatus("test-ns/test.ns")
+		assert.False(t, exists, "status should not be set")
+		role := newSr("test-ns", "test-role")
+		_, exists = c.LastWrite(role.Key())
+		assert.False(t, exists, "no write should be recorded")
+	})
+
+	t.Run("should stop at the first item which would fail", func(t *testing.T) {
+		c := NewController(memory.NewController(memory.Make(configDescriptor)), 1, retry.DefaultPolicy(), retry.NewDeadLetters(), nil)
+		invalid := newSr("test-ns", "invalid-role")
+		invalid.Spec = &v1alpha1.ServiceRole{}
+		batch := NewBatch("test-ns/test.ns", []*Item{
+			{Operation: model.EventAdd, Resource: newSr("test-ns", "test-role")},
+			{Operation: model.EventAdd, Resource: invalid},
+			{Operation: model.EventDelete, Resource: newSrb("test-ns", "missing-role")},
+		})
+		status := c.DryRunBatch(batch)
+
+		assert.NotNil(t, status.Err, "status error should not be nil")
+		assert.Equal(t, batch.Items[1], status.Failed, "failed item should be the invalid one")
+		assert.Equal(t, 1, status.Applied, "applied changes should be equal")
+
+		status = c.DryRunBatch(NewBatch("test-ns/test.ns", []*Item{
+			{Operation: model.EventDelete, Resource: newSrb("test-ns", "missing-role")},
+		}))
+		assert.Equal(t, "item not found", status.Err.Error(), "deleting a missing item should fail")
+	})
+}
@@ -4,24 +4,55 @@
 package processor
 
 import (
+	"sync"
+	"time"
+
 	"istio.io/istio/pilot/pkg/model"
 
-	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/flowcontrol"
 	"k8s.io/client-go/util/workqueue"
 
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/retry"
 )
 
-const (
-	queueNumRetries = 3
-	logPrefix       = "[processor]"
-)
+var logger = log.WithComponent("processor")
+
+// itemLogger returns a logger for the resource and operation of an item
+func itemLogger(item *Item) *log.Logger {
+	return logger.WithNamespace(item.Resource.Namespace).WithResource(item.Resource.Key()).WithOperation(item.Operation)
+}
+
+// batchLogger returns a logger for the namespace and athenz domain of a batch key
+func batchLogger(key string) *log.Logger {
+	namespace, domain, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return logger.WithResource(key)
+	}
+	return logger.WithNamespace(namespace).WithDomain(domain)
+}
 
 type Controller struct {
 	configStoreCache model.ConfigStoreCache
 	queue            workqueue.RateLimitingInterface
+	workers          int
+	retryPolicy      retry.Policy
+	deadLetters      *retry.DeadLetters
+	writeLimiter     flowcontrol.RateLimiter
+	pending          map[string][]interface{}
+	pendingLock      sync.Mutex
+	writes           map[string]string
+	deletes          map[string]bool
+	writesLock       sync.Mutex
+	status           map[string]BatchStatus
+	statusLock       sync.RWMutex
 }
 
+// OnErrorFunc is called when an item fails. Returning an error makes the
+// processor retry the item itself, returning nil means the owner of the item
+// takes care of the retry, for example by recomputing its changes.
 type OnErrorFunc func(err error, item *Item) error
 
 type Item struct {
@@ -30,68 +61,242 @@ type Item struct {
 	ErrorHandler OnErrorFunc
 }
 
-// NewController is responsible for creating the processing controller workqueue
-func NewController(configStoreCache model.ConfigStoreCache) *Controller {
-	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+// NewController is responsible for creating the processing controller workqueue.
+// The writes to the Istio custom resource client wait on the write limiter,
+// the Istio library does not expose the rate limits of its client. A nil
+// limiter does not limit the writes.
+func NewController(configStoreCache model.ConfigStoreCache, workers int, retryPolicy retry.Policy, deadLetters *retry.DeadLetters, writeLimiter flowcontrol.RateLimiter) *Controller {
+	queue := workqueue.NewRateLimitingQueue(retryPolicy.RateLimiter())
 
 	c := &Controller{
 		configStoreCache: configStoreCache,
 		queue:            queue,
+		workers:          workers,
+		retryPolicy:      retryPolicy,
+		deadLetters:      deadLetters,
+		writeLimiter:     writeLimiter,
+		pending:          make(map[string][]interface{}),
+		writes:           make(map[string]string),
+		deletes:          make(map[string]bool),
+		status:           make(map[string]BatchStatus),
 	}
 
 	return c
 }
 
+// enqueue appends the item or batch to the pending list of its key and adds
+// the key to the queue. The queue never hands the same key to two workers, so
+// the pending items of a key are processed in order. A batch replaces the
+// pending batch of its domain as it is computed from a more recent state.
+func (c *Controller) enqueue(key string, obj interface{}) {
+	c.pendingLock.Lock()
+	if _, ok := obj.(*Batch); ok {
+		c.pending[key] = []interface{}{obj}
+	} else {
+		c.pending[key] = append(c.pending[key], obj)
+	}
+	c.pendingLock.Unlock()
+
+	c.queue.Add(key)
+}
+
+// takePending removes and returns the pending items of a key
+func (c *Controller) takePending(key string) []interface{} {
+	c.pendingLock.Lock()
+	defer c.pendingLock.Unlock()
+	objs := c.pending[key]
+	delete(c.pending, key)
+	return objs
+}
+
+// restorePending puts back unprocessed items in front of the pending items of a key
+func (c *Controller) restorePending(key string, objs []interface{}) {
+	if len(objs) == 0 {
+		return
+	}
+
+	c.pendingLock.Lock()
+	defer c.pendingLock.Unlock()
+	c.pending[key] = append(objs, c.pending[key]...)
+}
+
 // ProcessConfigChange is responsible for adding the key of the item to the queue
 func (c *Controller) ProcessConfigChange(item *Item) {
-	log.Infof("%s ProcessConfigChange() Item added to queue Resource: %s, Action: %s", logPrefix, item.Resource.Key(), item.Operation)
-	c.queue.Add(item)
+	itemLogger(item).Infof("ProcessConfigChange() Item added to queue")
+	c.enqueue(item.Resource.Key(), item)
 }
 
-// Run starts the main controller loop running sync at every poll interval.
+// Run starts the workers of the processor. Once stopCh is closed no new keys
+// are accepted, and Run returns when the workers processed the queued ones.
 func (c *Controller) Run(stopCh <-chan struct{}) {
-	defer c.queue.ShutDown()
-	wait.Until(c.runWorker, 0, stopCh)
+	var workers sync.WaitGroup
+	for i := 0; i < c.workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			c.runWorker()
+		}()
+	}
+	<-stopCh
+
+	logger.Infof("Draining the queued items...")
+	c.queue.ShutDown()
+	workers.Wait()
+	if pending := c.PendingKeys(); pending > 0 {
+		logger.Warningf("Stopped with the items of %d keys left to retry", pending)
+		return
+	}
+	logger.Infof("Drained the queued items")
+}
+
+// PendingKeys returns the number of keys with items left to process, the
+// items which failed and were not retried once the processor stopped
+func (c *Controller) PendingKeys() int {
+	c.pendingLock.Lock()
+	defer c.pendingLock.Unlock()
+	return len(c.pending)
 }
 
-// runWorker calls processNextItem to process events of the work queue
+// runWorker calls processNextItem to process events of the work queue until
+// the queue shuts down
 func (c *Controller) runWorker() {
 	for c.processNextItem() {
 	}
 }
 
-// processNextItem takes an item off the queue and calls the controllers sync
-// function, handles the logic of re-queuing in case any errors occur
+// processNextItem takes a key off the queue and processes its pending items in
+// order, handles the logic of re-queuing in case any errors occur
 func (c *Controller) processNextItem() bool {
-	itemRaw, quit := c.queue.Get()
+	keyRaw, quit := c.queue.Get()
 	if quit {
 		return false
 	}
 
-	defer c.queue.Done(itemRaw)
+	defer c.queue.Done(keyRaw)
 
-	item, ok := itemRaw.(*Item)
+	key, ok := keyRaw.(string)
 	if !ok {
-		log.Errorf("%s processNextItem() Item cast failed for resource %v", logPrefix, item)
+		logger.Errorf("processNextItem() String cast failed for key %v", keyRaw)
 		return true
 	}
 
-	log.Infof("%s processNextItem() Processing %s for resource: %s", logPrefix, item.Operation, item.Resource.Key())
+	objs := c.takePending(key)
+	for i, obj := range objs {
+		retryItem, err := c.process(obj)
+		if !retryItem {
+			continue
+		}
+
+		if c.queue.NumRequeues(key) < c.retryPolicy.MaxRetries {
+			logger.WithResource(key).Infof("processNextItem() Retrying key due to sync error")
+			metrics.Retries.WithLabelValues(metrics.ControllerProcessor).Inc()
+			c.restorePending(key, objs[i:])
+			c.queue.AddRateLimited(key)
+			return true
+		}
+
+		// the failed item is dropped, the remaining ones are processed next
+		c.deadLetters.Add(metrics.ControllerProcessor, key, err, c.queue.NumRequeues(key))
+		c.restorePending(key, objs[i+1:])
+		if i+1 < len(objs) {
+			c.queue.Add(key)
+		}
+		break
+	}
+
+	c.queue.Forget(key)
+	return true
+}
+
+// process applies an item or a batch and returns true with the error if the
+// item must be retried by the processor
+func (c *Controller) process(obj interface{}) (bool, error) {
+	if batch, ok := obj.(*Batch); ok {
+		batchLogger(batch.Key).Infof("processNextItem() Processing batch")
+		c.syncBatch(batch)
+		return false, nil
+	}
+
+	item, ok := obj.(*Item)
+	if !ok {
+		logger.Errorf("processNextItem() Item cast failed for resource %v", obj)
+		return false, nil
+	}
+
+	itemLogger(item).Infof("processNextItem() Processing item")
 	err := c.sync(item)
+	status := BatchStatus{
+		Total: 1,
+		Err:   err,
+		Time:  time.Now(),
+	}
 	if err != nil {
-		log.Errorf("%s processNextItem() Error performing %s for resource: %s: %s", logPrefix, item.Operation, item.Resource.Key(), err)
+		status.Failed = item
+	} else {
+		status.Applied = 1
+	}
+	c.setStatus(item.Resource.Key(), status)
+
+	if err != nil {
+		itemLogger(item).WithError(err).Errorf("processNextItem() Error performing the operation")
 		if item.ErrorHandler != nil {
-			err := item.ErrorHandler(err, item)
-			if err != nil && c.queue.NumRequeues(itemRaw) < queueNumRetries {
-				log.Infof("%s processNextItem() Retrying %s for resource: %s due to sync error", logPrefix, item.Operation, item.Resource.Key())
-				c.queue.AddRateLimited(itemRaw)
-				return true
-			}
+			handlerErr := item.ErrorHandler(err, item)
+			return handlerErr != nil, handlerErr
 		}
+		return false, nil
 	}
 
-	c.queue.Forget(itemRaw)
-	return true
+	c.deadLetters.Remove(metrics.ControllerProcessor, item.Resource.Key())
+	return false, nil
+}
+
+// recordWrite records the resource version written by the processor for a
+// resource, or the deletion of the resource, so that the config events caused
+// by the write can be told apart from external edits
+func (c *Controller) recordWrite(item *Item, revision string) {
+	key := item.Resource.Key()
+
+	c.writesLock.Lock()
+	defer c.writesLock.Unlock()
+
+	if item.Operation == model.EventDelete {
+		delete(c.writes, key)
+		c.deletes[key] = true
+		return
+	}
+	delete(c.deletes, key)
+	c.writes[key] = revision
+}
+
+// IsOwnWrite returns true if the config event was caused by the last write of
+// the processor to the resource. An event for any other resource version, or
+// the deletion of a resource the processor did not delete, is an external edit.
+func (c *Controller) IsOwnWrite(config model.Config, e model.Event) bool {
+	key := config.Key()
+
+	c.writesLock.Lock()
+	defer c.writesLock.Unlock()
+
+	if e == model.EventDelete {
+		if c.deletes[key] {
+			delete(c.deletes, key)
+			return true
+		}
+		delete(c.writes, key)
+		return false
+	}
+
+	revision, exists := c.writes[key]
+	return exists && revision == config.ResourceVersion
+}
+
+// LastWrite returns the resource version of the last write of the processor
+// to a resource, if the processor wrote it since it started
+func (c *Controller) LastWrite(key string) (string, bool) {
+	c.writesLock.Lock()
+	defer c.writesLock.Unlock()
+	revision, exists := c.writes[key]
+	return revision, exists
 }
 
 // sync is responsible for invoking the appropriate API operation on the model.Config resource
@@ -101,16 +306,25 @@ func (c *Controller) sync(item *Item) error {
 		return nil
 	}
 
+	if c.writeLimiter != nil {
+		c.writeLimiter.Accept()
+	}
+
 	var err error
+	var revision string
 	switch item.Operation {
 	case model.EventAdd:
-		_, err = c.configStoreCache.Create(item.Resource)
+		revision, err = c.configStoreCache.Create(item.Resource)
 	case model.EventUpdate:
-		_, err = c.configStoreCache.Update(item.Resource)
+		revision, err = c.configStoreCache.Update(item.Resource)
 	case model.EventDelete:
 		res := item.Resource
 		err = c.configStoreCache.Delete(res.Type, res.Name, res.Namespace)
 	}
 
+	if err == nil {
+		c.recordWrite(item, revision)
+	}
+
 	return err
 }
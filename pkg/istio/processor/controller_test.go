@@ -3,16 +3,20 @@ package processor
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/common"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/retry"
 
 	"istio.io/api/rbac/v1alpha1"
 	"istio.io/istio/pilot/pkg/config/memory"
 	"istio.io/istio/pilot/pkg/model"
 
 	"k8s.io/api/core/v1"
+	"k8s.io/client-go/util/flowcontrol"
 )
 
 func init() {
@@ -205,7 +209,7 @@ func TestSync(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			configStoreCache := tt.startingCache
-			c := NewController(configStoreCache)
+			c := NewController(configStoreCache, 1, retry.DefaultPolicy(), retry.NewDeadLetters(), nil)
 
 			err := c.sync(tt.input)
 			assert.Equal(t, tt.expectedErr, err, "sync err should match expected error")
@@ -230,3 +234,152 @@ func TestSync(t *testing.T) {
 		})
 	}
 }
+
+func TestIsOwnWrite(t *testing.T) {
+	configDescriptor := model.ConfigDescriptor{
+		model.ServiceRole,
+	}
+	c := NewController(memory.NewController(memory.Make(configDescriptor)), 1, retry.DefaultPolicy(), retry.NewDeadLetters(), nil)
+
+	sr := newSr("test-ns", "test-role")
+	err := c.sync(&Item{Operation: model.EventAdd, Resource: sr})
+	assert.Nil(t, err, "create should return nil")
+
+	written := c.configStoreCache.Get(model.ServiceRole.Type, "test-role", "test-ns")
+	assert.NotNil(t, written, "cache should return the ServiceRole resource")
+	assert.True(t, c.IsOwnWrite(*written, model.EventAdd), "event for the written resource version should be an own write")
+
+	external := *written
+	external.ResourceVersion = "external"
+	assert.False(t, c.IsOwnWrite(external, model.EventUpdate), "event for another resource version should not be an own write")
+
+	err = c.sync(&Item{Operation: model.EventDelete, Resource: *written})
+	assert.Nil(t, err, "delete should return nil")
+	assert.True(t, c.IsOwnWrite(*written, model.EventDelete), "delete event for a deleted resource should be an own write")
+	assert.False(t, c.IsOwnWrite(*written, model.EventDelete), "repeated delete event should not be an own write")
+}
+
+// countingLimiter is a flowcontrol.RateLimiter counting the accepted tokens
+type countingLimiter struct {
+	flowcontrol.RateLimiter
+	accepted int
+}
+
+func (l *countingLimiter) Accept() {
+	l.accepted++
+}
+
+func TestSyncWriteLimiter(t *testing.T) {
+	configDescriptor := model.ConfigDescriptor{
+		model.ServiceRole,
+	}
+	limiter := &countingLimiter{}
+	c := NewController(memory.NewController(memory.Make(configDescriptor)), 1, retry.DefaultPolicy(), retry.NewDeadLetters(), limiter)
+
+	sr := newSr("test-ns", "test-role")
+	assert.Nil(t, c.sync(&Item{Operation: model.EventAdd, Resource: sr}), "create should return nil")
+	written := c.configStoreCache.Get(model.ServiceRole.Type, "test-role", "test-ns")
+	assert.NotNil(t, written, "cache should return the ServiceRole resource")
+	assert.Nil(t, c.sync(&Item{Operation: model.EventUpdate, Resource: *written}), "update should return nil")
+	assert.Nil(t, c.sync(&Item{Operation: model.EventDelete, Resource: *written}), "delete should return nil")
+	assert.Nil(t, c.sync(nil), "nil item should return nil")
+	assert.Equal(t, 3, limiter.accepted, "every write should take a token from the limiter")
+}
+
+func TestProcessNextItem(t *testing.T) {
+	configDescriptor := model.ConfigDescriptor{
+		model.ServiceRole,
+		model.ServiceRoleBinding,
+	}
+
+	t.Run("should process the items of a key in order", func(t *testing.T) {
+		c := NewController(memory.NewController(memory.Make(configDescriptor)), 1, retry.DefaultPolicy(), retry.NewDeadLetters(), nil)
+		c.ProcessConfigChange(&Item{Operation: model.EventAdd, Resource: newSr("test-ns", "test-role")})
+		c.ProcessConfigChange(&Item{Operation: model.EventDelete, Resource: newSr("test-ns", "test-role")})
+		assert.Equal(t, 1, c.queue.Len(), "items of the same key should share a queue entry")
+
+		assert.True(t, c.processNextItem(), "processNextItem should return true")
+		assert.Nil(t, c.configStoreCache.Get(model.ServiceRole.Type, "test-role", "test-ns"), "delete should be applied after create")
+		assert.Equal(t, 0, len(c.pending), "no item should be pending")
+	})
+
+	t.Run("should replace a pending batch of the same key", func(t *testing.T) {
+		c := NewController(memory.NewController(memory.Make(configDescriptor)), 1, retry.DefaultPolicy(), retry.NewDeadLetters(), nil)
+		c.ProcessBatch(NewBatch("test-ns/test.ns", []*Item{{Operation: model.EventAdd, Resource: newSr("test-ns", "old-role")}}))
+		c.ProcessBatch(NewBatch("test-ns/test.ns", []*Item{{Operation: model.EventAdd, Resource: newSr("test-ns", "new-role")}}))
+
+		assert.True(t, c.processNextItem(), "processNextItem should return true")
+		assert.Nil(t, c.configStoreCache.Get(model.ServiceRole.Type, "old-role", "test-ns"), "superseded batch should not be applied")
+		assert.NotNil(t, c.configStoreCache.Get(model.ServiceRole.Type, "new-role", "test-ns"), "latest batch should be applied")
+	})
+
+	t.Run("should keep the failed item pending for retries", func(t *testing.T) {
+		c := NewController(memory.NewController(memory.Make(configDescriptor)), 1, retry.DefaultPolicy(), retry.NewDeadLetters(), nil)
+		errHandler := func(err error, item *Item) error {
+			return err
+		}
+		sr := newSr("test-ns", "test-role")
+		c.ProcessConfigChange(&Item{Operation: model.EventUpdate, Resource: sr, ErrorHandler: errHandler})
+
+		assert.True(t, c.processNextItem(), "processNextItem should return true")
+		assert.Equal(t, 1, c.queue.NumRequeues(sr.Key()), "key should be requeued")
+		assert.Equal(t, 1, len(c.pending[sr.Key()]), "failed item should stay pending")
+	})
+	t.Run("should report the item as a dead letter once its retries are exhausted", func(t *testing.T) {
+		deadLetters := retry.NewDeadLetters()
+		c := NewController(memory.NewController(memory.Make(configDescriptor)), 1, retry.Policy{BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, MaxRetries: 0}, deadLetters, nil)
+		errHandler := func(err error, item *Item) error {
+			return err
+		}
+		sr := newSr("test-ns", "test-role")
+		c.ProcessConfigChange(&Item{Operation: model.EventUpdate, Resource: sr, ErrorHandler: errHandler})
+		c.ProcessConfigChange(&Item{Operation: model.EventAdd, Resource: sr})
+
+		assert.True(t, c.processNextItem(), "processNextItem should return true")
+		assert.Equal(t, 1, len(deadLetters.List()), "item should be reported as a dead letter")
+		assert.Equal(t, sr.Key(), deadLetters.List()[0].Key, "dead letter key should be equal")
+		assert.Equal(t, 1, len(c.pending[sr.Key()]), "remaining items should stay pending")
+		assert.Equal(t, 1, c.queue.Len(), "key should be requeued for the remaining items")
+
+		assert.True(t, c.processNextItem(), "processNextItem should return true")
+		assert.Equal(t, 0, len(deadLetters.List()), "dead letter should be removed after a successful write")
+	})
+}
+
+func TestRunDrain(t *testing.T) {
+	configDescriptor := model.ConfigDescriptor{
+		model.ServiceRole,
+		model.ServiceRoleBinding,
+	}
+
+	t.Run("should write the queued items before returning", func(t *testing.T) {
+		c := NewController(memory.NewController(memory.Make(configDescriptor)), 2, retry.DefaultPolicy(), retry.NewDeadLetters(), nil)
+		for i := 0; i < 10; i++ {
+			c.ProcessConfigChange(&Item{Operation: model.EventAdd, Resource: newSr("test-ns", fmt.Sprintf("test-role-%d", i))})
+		}
+
+		stopCh := make(chan struct{})
+		close(stopCh)
+		c.Run(stopCh)
+
+		for i := 0; i < 10; i++ {
+			assert.NotNil(t, c.configStoreCache.Get(model.ServiceRole.Type, fmt.Sprintf("test-role-%d", i), "test-ns"), "queued item should be written")
+		}
+		assert.Equal(t, 0, c.PendingKeys(), "no key should be pending")
+		c.ProcessConfigChange(&Item{Operation: model.EventAdd, Resource: newSr("test-ns", "late-role")})
+		assert.Equal(t, 0, c.queue.Len(), "no key should be accepted once stopped")
+	})
+
+	t.Run("should report the failed items left to retry", func(t *testing.T) {
+		c := NewController(memory.NewController(memory.Make(configDescriptor)), 1, retry.DefaultPolicy(), retry.NewDeadLetters(), nil)
+		errHandler := func(err error, item *Item) error {
+			return err
+		}
+		c.ProcessConfigChange(&Item{Operation: model.EventUpdate, Resource: newSr("test-ns", "missing-role"), ErrorHandler: errHandler})
+
+		stopCh := make(chan struct{})
+		close(stopCh)
+		c.Run(stopCh)
+		assert.Equal(t, 1, c.PendingKeys(), "failed item should be left to retry")
+	})
+}
@@ -15,10 +15,9 @@ import (
 	"istio.io/api/rbac/v1alpha1"
 )
 
-const (
-	ConstraintSvcKey = "destination.labels[svc]"
-	srLogPrefix      = "[servicerole]"
-)
+const ConstraintSvcKey = "destination.labels[svc]"
+
+var srLogger = log.WithComponent("servicerole")
 
 var supportedMethods = map[string]bool{
 	http.MethodGet:     true,
@@ -98,53 +97,59 @@ func parseAssertionResource(domainName zms.DomainName, assertion *zms.Assertion)
 	return svc, path, nil
 }
 
+// ParseAssertionRule returns the ServiceRole rule of an Athenz assertion of
+// the given role, or an error if the assertion cannot be converted
+func ParseAssertionRule(domainName zms.DomainName, roleName string, assertion *zms.Assertion) (*v1alpha1.AccessRule, error) {
+	assertionRole, err := ParseRoleFQDN(domainName, string(assertion.Role))
+	if err != nil {
+		return nil, err
+	}
+
+	if assertionRole != roleName {
+		return nil, fmt.Errorf("assertion: %v does not belong to the role", assertion)
+	}
+	_, err = parseAssertionEffect(assertion)
+	if err != nil {
+		return nil, err
+	}
+
+	method, err := parseAssertionAction(assertion)
+	if err != nil {
+		return nil, err
+	}
+
+	svc, path, err := parseAssertionResource(domainName, assertion)
+	if err != nil {
+		return nil, err
+	}
+
+	rule := &v1alpha1.AccessRule{
+		Constraints: []*v1alpha1.AccessRule_Constraint{
+			{
+				Key:    ConstraintSvcKey,
+				Values: []string{svc},
+			},
+		},
+		Methods:  []string{method},
+		Services: []string{WildCardAll},
+	}
+	if path != "" {
+		rule.Paths = []string{path}
+	}
+	return rule, nil
+}
+
 // GetServiceRoleSpec returns the ServiceRoleSpec for a given Athenz role and the associated assertions
 func GetServiceRoleSpec(domainName zms.DomainName, roleName string, assertions []*zms.Assertion) (*v1alpha1.ServiceRole, error) {
 
+	roleLogger := srLogger.WithDomain(string(domainName)).WithResource(roleName)
 	rules := make([]*v1alpha1.AccessRule, 0)
 	for _, assertion := range assertions {
-		assertionRole, err := ParseRoleFQDN(domainName, string(assertion.Role))
+		rule, err := ParseAssertionRule(domainName, roleName, assertion)
 		if err != nil {
-			log.Warningf("%s %s", srLogPrefix, err.Error())
+			roleLogger.WithError(err).Warningf("Skipping the assertion")
 			continue
 		}
-
-		if assertionRole != roleName {
-			log.Warningf("%s Assertion: %v does not belong to the role: %s", srLogPrefix, assertion, roleName)
-			continue
-		}
-		_, err = parseAssertionEffect(assertion)
-		if err != nil {
-			log.Warningf("%s %s", srLogPrefix, err.Error())
-			continue
-		}
-
-		method, err := parseAssertionAction(assertion)
-		if err != nil {
-			log.Warningf("%s %s", srLogPrefix, err.Error())
-			continue
-		}
-
-		svc, path, err := parseAssertionResource(domainName, assertion)
-		if err != nil {
-			log.Warningf("%s %s", srLogPrefix, err.Error())
-			continue
-		}
-
-		rule := &v1alpha1.AccessRule{
-			Constraints: []*v1alpha1.AccessRule_Constraint{
-				{
-					Key:    ConstraintSvcKey,
-					Values: []string{svc},
-				},
-			},
-			Methods:  []string{method},
-			Services: []string{WildCardAll},
-		}
-		if path != "" {
-			rule.Paths = []string{path}
-		}
-
 		rules = append(rules, rule)
 	}
 
@@ -16,9 +16,10 @@ const (
 	allUsers        = "user.*"
 	WildCardAll     = "*"
 	ServiceRoleKind = "ServiceRole"
-	srbLogPrefix    = "[servicerolebinding]"
 )
 
+var srbLogger = log.WithComponent("servicerolebinding")
+
 // parseMemberName parses the Athenz role member into a SPIFFE compliant name
 func parseMemberName(member *zms.RoleMember) (string, error) {
 
@@ -46,7 +47,7 @@ func GetServiceRoleBindingSpec(roleName string, members []*zms.RoleMember) (*v1a
 
 		memberName, err := parseMemberName(member)
 		if err != nil {
-			log.Warningf("%s %s", srbLogPrefix, err.Error())
+			srbLogger.WithResource(roleName).WithError(err).Warningf("Skipping the member")
 			continue
 		}
 
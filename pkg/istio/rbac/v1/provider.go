@@ -12,12 +12,17 @@ import (
 	"istio.io/istio/pilot/pkg/model"
 )
 
-const logPrefix = "[provider]"
+var logger = log.WithComponent("provider")
 
 type v1 struct {
 	// implements github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/Provider interface
 }
 
+// roleLogger returns a logger for the ServiceRole of an Athenz role
+func roleLogger(m athenz.Model, roleName string) *log.Logger {
+	return logger.WithNamespace(m.Namespace).WithDomain(string(m.Name)).WithResource(m.Namespace + "/" + roleName)
+}
+
 func NewProvider() rbac.Provider {
 	return &v1{}
 }
@@ -41,21 +46,21 @@ func (p *v1) ConvertAthenzModelIntoIstioRbac(m athenz.Model) []model.Config {
 		// Extract only the role name from the <domain>:role.<roleName> format
 		roleName, err := common.ParseRoleFQDN(m.Name, string(roleFQDN))
 		if err != nil {
-			log.Warningf("%s %s", logPrefix, err.Error())
+			logger.WithNamespace(m.Namespace).WithDomain(string(m.Name)).WithError(err).Warningf("Error parsing the role name")
 			continue
 		}
 
 		// Transform the assertions for an Athenz Role into a ServiceRole spec
 		srSpec, err := common.GetServiceRoleSpec(m.Name, roleName, assertions)
 		if err != nil {
-			log.Warningf("%s Error converting the assertions for role: %s to a ServiceRole: %s", logPrefix, roleName, err.Error())
+			roleLogger(m, roleName).WithError(err).Warningf("Error converting the assertions of the role to a ServiceRole")
 			continue
 		}
 
 		// Validate the ServiceRole spec
 		err = model.ValidateServiceRole(roleName, m.Namespace, srSpec)
 		if err != nil {
-			log.Warningf("%s Error validating the converted ServiceRole spec: %s for role: %s", logPrefix, err.Error(), roleName)
+			roleLogger(m, roleName).WithError(err).Warningf("Error validating the converted ServiceRole spec")
 			continue
 		}
 
@@ -65,20 +70,20 @@ func (p *v1) ConvertAthenzModelIntoIstioRbac(m athenz.Model) []model.Config {
 		// Transform the members for an Athenz Role into a ServiceRoleBinding spec
 		roleMembers, exists := m.Members[roleFQDN]
 		if !exists {
-			log.Warningf("%s Cannot find members for the role:%s while creating a ServiceRoleBinding", logPrefix, roleName)
+			roleLogger(m, roleName).Warningf("Cannot find members for the role while creating a ServiceRoleBinding")
 			continue
 		}
 
 		srbSpec, err := common.GetServiceRoleBindingSpec(roleName, roleMembers)
 		if err != nil {
-			log.Warningf("%s Error converting the members for role:%s to a ServiceRoleBinding: %s", logPrefix, roleName, err.Error())
+			roleLogger(m, roleName).WithError(err).Warningf("Error converting the members of the role to a ServiceRoleBinding")
 			continue
 		}
 
 		// Validate the ServiceRoleBinding spec
 		err = model.ValidateServiceRoleBinding(roleName, m.Namespace, srbSpec)
 		if err != nil {
-			log.Warningf("%s Error validating the converted ServiceRoleBinding spec: %s for role: %s", logPrefix, err.Error(), roleName)
+			roleLogger(m, roleName).WithError(err).Warningf("Error validating the converted ServiceRoleBinding spec")
 			continue
 		}
 
@@ -94,12 +99,12 @@ func (p *v1) GetCurrentIstioRbac(m athenz.Model, csc model.ConfigStoreCache) []m
 
 	sr, err := csc.List(model.ServiceRole.Type, m.Namespace)
 	if err != nil {
-		log.Warningf("%s Error listing the ServiceRole resources in the namespace: %s", logPrefix, m.Namespace)
+		logger.WithNamespace(m.Namespace).WithError(err).Warningf("Error listing the ServiceRole resources in the namespace")
 	}
 
 	srb, err := csc.List(model.ServiceRoleBinding.Type, m.Namespace)
 	if err != nil {
-		log.Warningf("%s Error listing the ServiceRoleBinding resources in the namespace: %s", logPrefix, m.Namespace)
+		logger.WithNamespace(m.Namespace).WithError(err).Warningf("Error listing the ServiceRoleBinding resources in the namespace")
 	}
 
 	return append(sr, srb...)
@@ -0,0 +1,44 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package limits
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// Limits caps the removals applied by a single sync, a sync exceeding them is
+// held until it is approved. A zero limit is disabled.
+type Limits struct {
+	MaxRemovals       int
+	MaxRemovalPercent int
+}
+
+// Check returns an error describing the exceeded limit if the number of
+// removals out of the total number of items exceeds one of the limits
+func (l Limits) Check(removals, total int) error {
+	if l.MaxRemovals > 0 && removals > l.MaxRemovals {
+		return fmt.Errorf("%d removals of %d items exceed the limit of %d removals", removals, total, l.MaxRemovals)
+	}
+	if l.MaxRemovalPercent > 0 && total > 0 && removals*100 > l.MaxRemovalPercent*total {
+		return fmt.Errorf("%d removals of %d items exceed the limit of %d%% of the items", removals, total, l.MaxRemovalPercent)
+	}
+	return nil
+}
+
+// Token returns the approval token of the removal of the given keys, it only
+// approves the removal of these exact keys
+func Token(keys []string) string {
+	sorted := make([]string, len(keys))
+	copy(sorted, keys)
+	sort.Strings(sorted)
+
+	h := fnv.New64a()
+	for _, key := range sorted {
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
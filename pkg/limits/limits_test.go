@@ -0,0 +1,71 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package limits
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		limits   Limits
+		removals int
+		total    int
+		expected string
+	}{
+		{
+			name:     "should allow any removal without limits",
+			limits:   Limits{},
+			removals: 10,
+			total:    10,
+		},
+		{
+			name:     "should allow removals up to the limit",
+			limits:   Limits{MaxRemovals: 2},
+			removals: 2,
+			total:    10,
+		},
+		{
+			name:     "should hold removals above the limit",
+			limits:   Limits{MaxRemovals: 2},
+			removals: 3,
+			total:    10,
+			expected: "3 removals of 10 items exceed the limit of 2 removals",
+		},
+		{
+			name:     "should allow removals up to the percentage",
+			limits:   Limits{MaxRemovalPercent: 50},
+			removals: 5,
+			total:    10,
+		},
+		{
+			name:     "should hold removals above the percentage",
+			limits:   Limits{MaxRemovalPercent: 50},
+			removals: 6,
+			total:    10,
+			expected: "6 removals of 10 items exceed the limit of 50% of the items",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.limits.Check(tt.removals, tt.total)
+			if tt.expected == "" {
+				assert.Nil(t, err, "error should be nil")
+				return
+			}
+			assert.Equal(t, tt.expected, err.Error(), "error should be equal")
+		})
+	}
+}
+
+func TestToken(t *testing.T) {
+	token := Token([]string{"b", "a"})
+	assert.Equal(t, 16, len(token), "token should be 16 hex digits")
+	assert.Equal(t, token, Token([]string{"a", "b"}), "token should not depend on the order of the keys")
+	assert.NotEqual(t, token, Token([]string{"a"}), "token should depend on the keys")
+}
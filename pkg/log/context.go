@@ -0,0 +1,112 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package log
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Names of the context fields of a Logger
+const (
+	ComponentField = "component"
+	DomainField    = "domain"
+	NamespaceField = "namespace"
+	ResourceField  = "resource"
+	OperationField = "operation"
+	ErrorField     = "error"
+)
+
+// Logger logs messages along with context fields, such as the component or
+// the domain the message is about. A Logger is immutable, each With method
+// returns a new Logger holding one more field.
+type Logger struct {
+	fields logrus.Fields
+}
+
+// WithComponent returns a logger for a component of the controller
+func WithComponent(component string) *Logger {
+	return &Logger{
+		fields: logrus.Fields{ComponentField: component},
+	}
+}
+
+// with returns a copy of the logger holding the field
+func (l *Logger) with(key string, value interface{}) *Logger {
+	fields := make(logrus.Fields, len(l.fields)+1)
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	fields[key] = value
+	return &Logger{
+		fields: fields,
+	}
+}
+
+// WithDomain returns a logger for an athenz domain
+func (l *Logger) WithDomain(domain string) *Logger {
+	return l.with(DomainField, domain)
+}
+
+// WithNamespace returns a logger for a namespace
+func (l *Logger) WithNamespace(namespace string) *Logger {
+	return l.with(NamespaceField, namespace)
+}
+
+// WithResource returns a logger for a resource key, e.g. service-role/namespace/name
+func (l *Logger) WithResource(key string) *Logger {
+	return l.with(ResourceField, key)
+}
+
+// WithOperation returns a logger for an operation on a resource
+func (l *Logger) WithOperation(operation interface{}) *Logger {
+	return l.with(OperationField, fmt.Sprint(operation))
+}
+
+// WithError returns a logger for an error, a nil error adds no field
+func (l *Logger) WithError(err error) *Logger {
+	if err == nil {
+		return l
+	}
+	return l.with(ErrorField, err.Error())
+}
+
+// Fields returns a copy of the context fields of the logger
+func (l *Logger) Fields() map[string]interface{} {
+	fields := make(map[string]interface{}, len(l.fields))
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	return fields
+}
+
+// entry returns a log entry of the current logger with the context fields
+func (l *Logger) entry() *logrus.Entry {
+	return log.WithFields(l.fields)
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.entry().Debugf(format, args...)
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.entry().Infof(format, args...)
+}
+
+func (l *Logger) Warningf(format string, args ...interface{}) {
+	l.entry().Warningf(format, args...)
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.entry().Errorf(format, args...)
+}
+
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.entry().Fatalf(format, args...)
+}
+
+func (l *Logger) Panicf(format string, args ...interface{}) {
+	l.entry().Panicf(format, args...)
+}
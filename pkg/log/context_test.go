@@ -0,0 +1,48 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogger(t *testing.T) {
+	InitLogger("", "debug")
+	err := SetFormat(FormatJSON)
+	assert.Nil(t, err, "error should be nil")
+	buf := &bytes.Buffer{}
+	log.Out = buf
+
+	base := WithComponent("controller")
+	base.WithNamespace("test-namespace").WithDomain("test.namespace").WithResource("service-role/test-namespace/test-role").
+		WithOperation("add").WithError(errors.New("failed")).Errorf("Error performing %s", "the change")
+
+	line := make(map[string]interface{})
+	err = json.Unmarshal(buf.Bytes(), &line)
+	assert.Nil(t, err, "log line should be valid json")
+	assert.Equal(t, "Error performing the change", line["msg"], "message should be equal")
+	assert.Equal(t, "error", line["level"], "level should be equal")
+	assert.Equal(t, "controller", line[ComponentField], "component should be equal")
+	assert.Equal(t, "test-namespace", line[NamespaceField], "namespace should be equal")
+	assert.Equal(t, "test.namespace", line[DomainField], "domain should be equal")
+	assert.Equal(t, "service-role/test-namespace/test-role", line[ResourceField], "resource should be equal")
+	assert.Equal(t, "add", line[OperationField], "operation should be equal")
+	assert.Equal(t, "failed", line[ErrorField], "error should be equal")
+
+	assert.Equal(t, map[string]interface{}{ComponentField: "controller"}, base.Fields(), "with methods should not change the parent logger")
+	assert.Equal(t, base.Fields(), base.WithError(nil).Fields(), "nil error should not add a field")
+}
+
+func TestSetFormat(t *testing.T) {
+	InitLogger("", "debug")
+	assert.Nil(t, SetFormat(FormatText), "text format should be supported")
+	assert.Nil(t, SetFormat(FormatJSON), "json format should be supported")
+	assert.NotNil(t, SetFormat("xml"), "unknown format should return an error")
+	assert.Nil(t, SetFormat(FormatText), "text format should be restored")
+}
@@ -0,0 +1,43 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package log
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// levelResponse is the body of the responses of the level handler
+type levelResponse struct {
+	Level string `json:"level"`
+}
+
+// LevelHandler returns a handler which writes the current log level on GET,
+// and changes it to the level query parameter on PUT or POST, for example
+// PUT /debug/loglevel?level=debug
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			level := r.URL.Query().Get("level")
+			previous := GetLevel()
+			err := SetLevel(level)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			log.Warnf("Log level changed from %s to %s", previous, level)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(levelResponse{Level: GetLevel()})
+		if err != nil {
+			log.Errorf("Error writing the log level: %s", err.Error())
+		}
+	})
+}
@@ -0,0 +1,70 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package log
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLevelHandler(t *testing.T) {
+	InitLogger("", "info")
+	handler := LevelHandler()
+
+	tests := []struct {
+		name         string
+		method       string
+		url          string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "should return the current level",
+			method:       http.MethodGet,
+			url:          "/debug/loglevel",
+			expectedCode: http.StatusOK,
+			expectedBody: "{\"level\":\"info\"}\n",
+		},
+		{
+			name:         "should change the level",
+			method:       http.MethodPut,
+			url:          "/debug/loglevel?level=debug",
+			expectedCode: http.StatusOK,
+			expectedBody: "{\"level\":\"debug\"}\n",
+		},
+		{
+			name:         "should reject an unknown level",
+			method:       http.MethodPut,
+			url:          "/debug/loglevel?level=verbose",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "should keep the level after an unknown level",
+			method:       http.MethodGet,
+			url:          "/debug/loglevel",
+			expectedCode: http.StatusOK,
+			expectedBody: "{\"level\":\"debug\"}\n",
+		},
+		{
+			name:         "should reject other methods",
+			method:       http.MethodDelete,
+			url:          "/debug/loglevel",
+			expectedCode: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.url, nil))
+			assert.Equal(t, tt.expectedCode, w.Code, "status code should be equal")
+			if tt.expectedBody != "" {
+				assert.Equal(t, tt.expectedBody, w.Body.String(), "body should be equal")
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@
 package log
 
 import (
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -12,10 +13,38 @@ import (
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
+// Formats of the log lines
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
 var log *logrus.Logger
 
-// InitLogger initializes a logger object with log rotation
+// Rotation configures the rotation of the log file
+type Rotation struct {
+	MaxSize    int // Mb
+	MaxBackups int
+	MaxAge     int // Days
+}
+
+// DefaultRotation returns the rotation used by InitLogger
+func DefaultRotation() Rotation {
+	return Rotation{
+		MaxSize:    1,
+		MaxBackups: 5,
+		MaxAge:     28,
+	}
+}
+
+// InitLogger initializes a logger object with the default log rotation
 func InitLogger(logFile, level string) {
+	InitLoggerWithRotation(logFile, level, DefaultRotation())
+}
+
+// InitLoggerWithRotation initializes a logger object writing to stdout and,
+// unless logFile is empty, to the log file rotated as configured
+func InitLoggerWithRotation(logFile, level string, rotation Rotation) {
 	logLevel, err := logrus.ParseLevel(level)
 	if err != nil {
 		logrus.Warnln("Could not parse log level, defaulting to info. Error:", err.Error())
@@ -26,33 +55,72 @@ func InitLogger(logFile, level string) {
 	if logFile != "" {
 		logger := &lumberjack.Logger{
 			Filename:   logFile,
-			MaxSize:    1, // Mb
-			MaxBackups: 5,
-			MaxAge:     28, // Days
+			MaxSize:    rotation.MaxSize,
+			MaxBackups: rotation.MaxBackups,
+			MaxAge:     rotation.MaxAge,
 		}
 		ioWriter = io.MultiWriter(os.Stdout, logger)
 	}
 
+	formatter, _ := newFormatter(FormatText)
 	l := &logrus.Logger{
-		Out: ioWriter,
-		Formatter: &logrus.TextFormatter{
+		Out:       ioWriter,
+		Formatter: formatter,
+		Level:     logLevel,
+	}
+
+	if logFile != "" {
+		dir := filepath.Dir(logFile)
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			logrus.Errorln("Could not mkdir for log file, defaulting to stdout logging. Error:", err.Error())
+			l.Out = os.Stdout
+		}
+	}
+
+	log = l
+}
+
+// SetLevel changes the level of the logger, it is safe to call while logging
+func SetLevel(level string) error {
+	logLevel, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	log.SetLevel(logLevel)
+	return nil
+}
+
+// GetLevel returns the current level of the logger
+func GetLevel() string {
+	return log.GetLevel().String()
+}
+
+// newFormatter returns the formatter of a log format
+func newFormatter(format string) (logrus.Formatter, error) {
+	switch format {
+	case FormatText:
+		return &logrus.TextFormatter{
 			ForceColors:            true,
 			DisableSorting:         true,
 			FullTimestamp:          true,
 			DisableLevelTruncation: true,
-		},
-		Level: logLevel,
+		}, nil
+	case FormatJSON:
+		return &logrus.JSONFormatter{}, nil
 	}
-	l.SetNoLock()
+	return nil, fmt.Errorf("log format %s is not one of %s or %s", format, FormatText, FormatJSON)
+}
 
-	dir := filepath.Dir(logFile)
-	err = os.MkdirAll(dir, 0755)
+// SetFormat sets the format of the log lines written by the logger, it must be
+// called after InitLogger and before logging from multiple goroutines
+func SetFormat(format string) error {
+	formatter, err := newFormatter(format)
 	if err != nil {
-		logrus.Errorln("Could not mkdir for log file, defaulting to stdout logging. Error:", err.Error())
-		l.Out = os.Stdout
+		return err
 	}
-
-	log = l
+	log.Formatter = formatter
+	return nil
 }
 
 func Debugf(format string, args ...interface{}) {
@@ -0,0 +1,179 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "athenz_istio_auth"
+
+var (
+	// ServicesPendingPolicy is the number of onboarded services which are not
+	// yet added to the ClusterRbacConfig because their domain has no policy for them
+	ServicesPendingPolicy = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "onboarding",
+		Name:      "services_pending_policy",
+		Help:      "Number of onboarded services waiting on an Athenz policy before being added to the ClusterRbacConfig.",
+	})
+
+	// OnboardingEvents counts the service, namespace and service entry events
+	// received by the onboarding controller by whether they were filtered out
+	OnboardingEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "onboarding",
+		Name:      "events_total",
+		Help:      "Number of events received by the onboarding controller.",
+	}, []string{"resource", "result"})
+
+	// ConfigEvents counts the Istio RBAC config events received by the
+	// controller by whether they were suppressed as echoes of its own writes
+	// or filtered out as outside of its scope
+	ConfigEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_events_total",
+		Help:      "Number of Istio RBAC config events received by the controller.",
+	}, []string{"type", "result"})
+
+	// DriftDetected counts the generated Istio RBAC resources found edited
+	// outside of the controller
+	DriftDetected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "drift_detected_total",
+		Help:      "Number of generated Istio RBAC resources found edited outside of the controller.",
+	}, []string{"type"})
+
+	// PausedSyncs counts the domain syncs skipped because reconciliation is
+	// paused on the namespace
+	PausedSyncs = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "paused_syncs_total",
+		Help:      "Number of domain syncs skipped because reconciliation is paused on the namespace.",
+	})
+
+	// ProtectedNamespaceViolations counts the athenz domains, namespaces and
+	// services which would have the controller write to a protected namespace
+	ProtectedNamespaceViolations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "protected_namespace_violations_total",
+		Help:      "Number of objects refused because they would have the controller write to a protected namespace.",
+	}, []string{"resource"})
+
+	// InvalidDomains is the number of domains which are invalid or empty, they
+	// are kept at their last known good state
+	InvalidDomains = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "invalid_domains",
+		Help:      "Number of athenz domains kept at their last known good state because they are invalid or empty.",
+	})
+
+	// HeldChanges counts the syncs whose removals exceeded the limits and were
+	// held until they are approved
+	HeldChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "held_changes_total",
+		Help:      "Number of syncs held because their removals exceeded the limits.",
+	}, []string{"controller"})
+
+	// Batches counts the per domain batches of Istio RBAC changes applied by
+	// the processor by whether all of their changes succeeded
+	Batches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "processor",
+		Name:      "batches_total",
+		Help:      "Number of batches of Istio RBAC changes applied by the processor.",
+	}, []string{"result"})
+
+	// Retries counts the keys requeued after a failure by each controller
+	Retries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "retries_total",
+		Help:      "Number of keys requeued after a failure.",
+	}, []string{"controller"})
+
+	// DeadLetters is the number of keys of each controller which exhausted
+	// their retries and were not synced successfully since
+	DeadLetters = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "dead_letters",
+		Help:      "Number of keys which exhausted their retries.",
+	}, []string{"controller"})
+
+	// ResyncsSkipped counts the domains skipped by the periodic resync as they
+	// did not change since their last successful sync
+	ResyncsSkipped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "resyncs_skipped_total",
+		Help:      "Number of domains skipped by the periodic resync as unchanged since their last successful sync.",
+	})
+
+	// DesiredStateCache counts the lookups of the cached desired state of the
+	// domains by whether the conversion could be skipped
+	DesiredStateCache = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "desired_state_cache_total",
+		Help:      "Number of lookups of the cached desired state of the domains.",
+	}, []string{"result"})
+
+	// ConfigReloads counts the reloads of the config file by whether the
+	// reloaded config was valid
+	ConfigReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_reloads_total",
+		Help:      "Number of reloads of the config file.",
+	}, []string{"result"})
+
+	// Syncs counts the sync runs of each controller
+	Syncs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "syncs_total",
+		Help:      "Number of sync runs of each controller.",
+	}, []string{"controller"})
+)
+
+// Label values shared by the metrics
+const (
+	ResultEnqueued   = "enqueued"
+	ResultFiltered   = "filtered"
+	ResultSuppressed = "suppressed"
+	ResultSuccess    = "success"
+	ResultFailure    = "failure"
+	ResultHit        = "hit"
+	ResultMiss       = "miss"
+
+	ControllerDomain            = "domain"
+	ControllerClusterRbacConfig = "cluster-rbac-config"
+	ControllerProcessor         = "processor"
+
+	ResourceAthenzDomain = "athenzdomain"
+	ResourceNamespace    = "namespace"
+	ResourceService      = "service"
+)
+
+func init() {
+	prometheus.MustRegister(ServicesPendingPolicy)
+	prometheus.MustRegister(OnboardingEvents)
+	prometheus.MustRegister(ConfigEvents)
+	prometheus.MustRegister(Syncs)
+	prometheus.MustRegister(DriftDetected)
+	prometheus.MustRegister(PausedSyncs)
+	prometheus.MustRegister(ProtectedNamespaceViolations)
+	prometheus.MustRegister(HeldChanges)
+	prometheus.MustRegister(InvalidDomains)
+	prometheus.MustRegister(Batches)
+	prometheus.MustRegister(Retries)
+	prometheus.MustRegister(DeadLetters)
+	prometheus.MustRegister(ResyncsSkipped)
+	prometheus.MustRegister(DesiredStateCache)
+	prometheus.MustRegister(ConfigReloads)
+}
+
+// Handler returns the http handler serving the registered metrics
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,199 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package preflight
+
+import (
+	"fmt"
+
+	"istio.io/istio/pilot/pkg/config/kube/crd"
+	"istio.io/istio/pilot/pkg/model"
+
+	authzv1 "k8s.io/api/authorization/v1"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes"
+	authzclient "k8s.io/client-go/kubernetes/typed/authorization/v1"
+
+	adv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/apis/athenz/v1"
+)
+
+// Requirement is an API resource, or one of its subresources, used by the
+// controller and the verbs it needs on it in all the namespaces
+type Requirement struct {
+	Group       string
+	Version     string
+	Resource    string
+	Subresource string
+	Verbs       []string
+}
+
+// GroupVersion returns the group version of the resource, e.g. rbac.istio.io/v1alpha1
+func (r Requirement) GroupVersion() string {
+	if r.Group == "" {
+		return r.Version
+	}
+	return r.Group + "/" + r.Version
+}
+
+// resourceName returns the name of the resource as listed by the discovery,
+// e.g. athenzdomains/status for a subresource
+func (r Requirement) resourceName() string {
+	if r.Subresource == "" {
+		return r.Resource
+	}
+	return r.Resource + "/" + r.Subresource
+}
+
+// String returns the resource qualified by its group, e.g. serviceroles.rbac.istio.io
+// or athenzdomains.athenz.io/status
+func (r Requirement) String() string {
+	name := r.Resource
+	if r.Group != "" {
+		name += "." + r.Group
+	}
+	if r.Subresource != "" {
+		name += "/" + r.Subresource
+	}
+	return name
+}
+
+// istioRequirement returns the requirement of an Istio custom resource
+func istioRequirement(schema model.ProtoSchema, verbs ...string) Requirement {
+	return Requirement{
+		Group:    crd.ResourceGroup(&schema),
+		Version:  schema.Version,
+		Resource: crd.ResourceName(schema.Plural),
+		Verbs:    verbs,
+	}
+}
+
+// Requirements returns the API resources used by the controller
+func Requirements() []Requirement {
+	writeVerbs := []string{"get", "list", "watch", "create", "update", "delete"}
+	return []Requirement{
+		{Version: "v1", Resource: "namespaces", Verbs: []string{"list", "watch"}},
+		{Version: "v1", Resource: "services", Verbs: []string{"list", "watch"}},
+		{Version: "v1", Resource: "events", Verbs: []string{"create", "patch"}},
+		{Group: adv1.SchemeGroupVersion.Group, Version: adv1.SchemeGroupVersion.Version, Resource: "athenzdomains", Verbs: []string{"list", "watch"}},
+		{Group: adv1.SchemeGroupVersion.Group, Version: adv1.SchemeGroupVersion.Version, Resource: "athenzdomains", Subresource: "status", Verbs: []string{"update"}},
+		istioRequirement(model.ServiceRole, writeVerbs...),
+		istioRequirement(model.ServiceRoleBinding, writeVerbs...),
+		istioRequirement(model.ClusterRbacConfig, writeVerbs...),
+		istioRequirement(model.ServiceEntry, "get", "list", "watch"),
+	}
+}
+
+// APIServer checks that the api server is reachable
+func APIServer(client discovery.DiscoveryInterface) Check {
+	return Check{
+		Name: "api-server",
+		Run: func() ([]string, error) {
+			_, err := client.ServerVersion()
+			if err != nil {
+				return nil, fmt.Errorf("api server is unreachable: %s", err.Error())
+			}
+			return nil, nil
+		},
+	}
+}
+
+// CustomResources checks that the custom resource definitions of the
+// requirements are installed
+func CustomResources(client discovery.DiscoveryInterface, requirements []Requirement) Check {
+	return Check{
+		Name: "custom-resources",
+		Run: func() ([]string, error) {
+			groups, err := client.ServerGroups()
+			if err != nil {
+				return nil, fmt.Errorf("error listing the api groups: %s", err.Error())
+			}
+			served := make(map[string]bool)
+			for _, group := range groups.Groups {
+				for _, version := range group.Versions {
+					served[version.GroupVersion] = true
+				}
+			}
+
+			missing := make([]string, 0)
+			resources := make(map[string]map[string]bool)
+			for _, r := range requirements {
+				if r.Group == "" {
+					continue
+				}
+				if !served[r.GroupVersion()] {
+					if r.Subresource == "" {
+						missing = append(missing, missingCRD(r))
+					}
+					continue
+				}
+				if _, exists := resources[r.GroupVersion()]; !exists {
+					list, err := client.ServerResourcesForGroupVersion(r.GroupVersion())
+					if err != nil {
+						return nil, fmt.Errorf("error listing the resources of %s: %s", r.GroupVersion(), err.Error())
+					}
+					resources[r.GroupVersion()] = make(map[string]bool)
+					for _, resource := range list.APIResources {
+						resources[r.GroupVersion()][resource.Name] = true
+					}
+				}
+				if !resources[r.GroupVersion()][r.resourceName()] {
+					missing = append(missing, missingCRD(r))
+				}
+			}
+			return missing, nil
+		},
+	}
+}
+
+// missingCRD describes the custom resource definition, or the subresource it
+// does not enable, of a missing requirement
+func missingCRD(r Requirement) string {
+	if r.Subresource == "" {
+		return fmt.Sprintf("crd %s/%s", r, r.Version)
+	}
+	return fmt.Sprintf("crd %s.%s/%s subresource %s", r.Resource, r.Group, r.Version, r.Subresource)
+}
+
+// Permissions checks with SelfSubjectAccessReviews that the service account
+// of the controller is allowed the verbs of the requirements
+func Permissions(client authzclient.SelfSubjectAccessReviewsGetter, requirements []Requirement) Check {
+	return Check{
+		Name: "permissions",
+		Run: func() ([]string, error) {
+			missing := make([]string, 0)
+			for _, r := range requirements {
+				for _, verb := range r.Verbs {
+					review, err := client.SelfSubjectAccessReviews().Create(&authzv1.SelfSubjectAccessReview{
+						Spec: authzv1.SelfSubjectAccessReviewSpec{
+							ResourceAttributes: &authzv1.ResourceAttributes{
+								Verb:        verb,
+								Group:       r.Group,
+								Version:     r.Version,
+								Resource:    r.Resource,
+								Subresource: r.Subresource,
+							},
+						},
+					})
+					if err != nil {
+						return nil, fmt.Errorf("error reviewing the access to %s: %s", r, err.Error())
+					}
+					if !review.Status.Allowed {
+						missing = append(missing, fmt.Sprintf("permission %s %s", verb, r))
+					}
+				}
+			}
+			return missing, nil
+		},
+	}
+}
+
+// DefaultChecks returns the checks of the api server, the custom resource
+// definitions and the permissions required by the controller
+func DefaultChecks(client kubernetes.Interface) []Check {
+	requirements := Requirements()
+	return []Check{
+		APIServer(client.Discovery()),
+		CustomResources(client.Discovery(), requirements),
+		Permissions(client.AuthorizationV1(), requirements),
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package preflight
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	authzv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// unreachableDiscovery fails to get the version of the api server
+type unreachableDiscovery struct {
+	*fakediscovery.FakeDiscovery
+}
+
+func (d unreachableDiscovery) ServerVersion() (*version.Info, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestAPIServer(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	missing, err := APIServer(client.Discovery()).Run()
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, 0, len(missing), "nothing should be missing")
+
+	discovery := unreachableDiscovery{client.Discovery().(*fakediscovery.FakeDiscovery)}
+	_, err = APIServer(discovery).Run()
+	assert.Equal(t, "api server is unreachable: connection refused", err.Error(), "error should be equal")
+}
+
+func TestCustomResources(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "athenz.io/v1",
+			APIResources: []metav1.APIResource{{Name: "athenzdomains"}},
+		},
+		{
+			GroupVersion: "rbac.istio.io/v1alpha1",
+			APIResources: []metav1.APIResource{{Name: "serviceroles"}, {Name: "servicerolebindings"}},
+		},
+	}
+
+	missing, err := CustomResources(client.Discovery(), Requirements()).Run()
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, []string{
+		"crd athenzdomains.athenz.io/v1 subresource status",
+		"crd clusterrbacconfigs.rbac.istio.io/v1alpha1",
+		"crd serviceentries.networking.istio.io/v1alpha3",
+	}, missing, "missing crds should be equal")
+
+	resources := client.Discovery().(*fakediscovery.FakeDiscovery).Resources
+	resources[0].APIResources = append(resources[0].APIResources, metav1.APIResource{Name: "athenzdomains/status"})
+	missing, err = CustomResources(client.Discovery(), Requirements()).Run()
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, []string{
+		"crd clusterrbacconfigs.rbac.istio.io/v1alpha1",
+		"crd serviceentries.networking.istio.io/v1alpha3",
+	}, missing, "status subresource should be found")
+}
+
+func TestPermissions(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authzv1.SelfSubjectAccessReview)
+		attributes := review.Spec.ResourceAttributes
+		review.Status.Allowed = !(attributes.Resource == "serviceroles" && attributes.Verb == "delete") && attributes.Resource != "events" && attributes.Subresource != "status"
+		return true, review, nil
+	})
+
+	missing, err := Permissions(client.AuthorizationV1(), Requirements()).Run()
+	assert.Nil(t, err, "error should be nil")
+	assert.Equal(t, []string{
+		"permission create events",
+		"permission patch events",
+		"permission update athenzdomains.athenz.io/status",
+		"permission delete serviceroles.rbac.istio.io",
+	}, missing, "missing permissions should be equal")
+
+	client.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, &authzv1.SelfSubjectAccessReview{}, errors.New("forbidden")
+	})
+	_, err = Permissions(client.AuthorizationV1(), Requirements()).Run()
+	assert.Equal(t, "error reviewing the access to namespaces: forbidden", err.Error(), "error should be equal")
+}
@@ -0,0 +1,115 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package preflight
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/retry"
+)
+
+var logger = log.WithComponent("preflight")
+
+// Check is a requirement of the controller verified before it starts. Run
+// returns what is missing for the requirement to be met, or an error if it
+// could not be verified.
+type Check struct {
+	Name string
+	Run  func() ([]string, error)
+}
+
+// Result is the outcome of a check
+type Result struct {
+	Check   string   `json:"check"`
+	Passed  bool     `json:"passed"`
+	Missing []string `json:"missing,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// Report is the diagnostic report of the last run of the checks
+type Report struct {
+	Attempts int      `json:"attempts"`
+	Results  []Result `json:"results"`
+}
+
+// Passed returns true if all the checks passed
+func (r Report) Passed() bool {
+	for _, result := range r.Results {
+		if !result.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the report as json
+func (r Report) String() string {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+// runChecks runs each check once
+func runChecks(checks []Check) []Result {
+	results := make([]Result, 0, len(checks))
+	for _, check := range checks {
+		missing, err := check.Run()
+		result := Result{
+			Check:   check.Name,
+			Passed:  err == nil && len(missing) == 0,
+			Missing: missing,
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// backoff returns the delay before a retry, doubling from the base delay of
+// the policy up to its max delay
+func backoff(policy retry.Policy, retries int) time.Duration {
+	delay := policy.BaseDelay
+	for i := 0; i < retries && delay < policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > policy.MaxDelay {
+		return policy.MaxDelay
+	}
+	return delay
+}
+
+// Run runs the checks until they all pass, up to the max retries of the policy
+// with an exponential backoff. The report of the last attempt is returned,
+// with an error if a check still fails or stopCh was closed.
+func Run(checks []Check, policy retry.Policy, stopCh <-chan struct{}) (Report, error) {
+	report := Report{}
+	for {
+		report.Attempts++
+		report.Results = runChecks(checks)
+		if report.Passed() {
+			logger.Infof("Preflight checks passed after %d attempt(s)", report.Attempts)
+			return report, nil
+		}
+
+		retries := report.Attempts - 1
+		if retries >= policy.MaxRetries {
+			return report, fmt.Errorf("preflight checks failed after %d attempt(s)", report.Attempts)
+		}
+
+		delay := backoff(policy, retries)
+		logger.Warningf("Preflight checks failed, retrying in %s: %s", delay, report)
+		select {
+		case <-time.After(delay):
+		case <-stopCh:
+			return report, fmt.Errorf("preflight checks stopped after %d attempt(s)", report.Attempts)
+		}
+	}
+}
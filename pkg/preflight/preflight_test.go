@@ -0,0 +1,79 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package preflight
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/retry"
+)
+
+func init() {
+	log.InitLogger("", "debug")
+}
+
+// newCheck returns a check which fails until it ran the given number of times
+func newCheck(failures int) (Check, *int) {
+	runs := 0
+	return Check{
+		Name: "test",
+		Run: func() ([]string, error) {
+			runs++
+			if runs <= failures {
+				return []string{"crd serviceroles.rbac.istio.io/v1alpha1"}, nil
+			}
+			return nil, nil
+		},
+	}, &runs
+}
+
+func TestRun(t *testing.T) {
+	policy := retry.Policy{BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond, MaxRetries: 3}
+
+	t.Run("should retry the checks until they pass", func(t *testing.T) {
+		check, runs := newCheck(2)
+		report, err := Run([]Check{check}, policy, nil)
+		assert.Nil(t, err, "error should be nil")
+		assert.Equal(t, 3, *runs, "check should be retried")
+		assert.Equal(t, 3, report.Attempts, "attempts should be equal")
+		assert.True(t, report.Passed(), "report should pass")
+	})
+
+	t.Run("should report what is missing once the retries are exhausted", func(t *testing.T) {
+		check, runs := newCheck(10)
+		failing := Check{
+			Name: "api-server",
+			Run: func() ([]string, error) {
+				return nil, errors.New("api server is unreachable")
+			},
+		}
+		report, err := Run([]Check{failing, check}, policy, nil)
+		assert.Equal(t, "preflight checks failed after 4 attempt(s)", err.Error(), "error should be equal")
+		assert.Equal(t, 4, *runs, "check should run once per attempt")
+		assert.False(t, report.Passed(), "report should fail")
+		assert.Equal(t, `{"attempts":4,"results":[{"check":"api-server","passed":false,"error":"api server is unreachable"},`+
+			`{"check":"test","passed":false,"missing":["crd serviceroles.rbac.istio.io/v1alpha1"]}]}`, report.String(), "report should be equal")
+	})
+
+	t.Run("should stop retrying once stopped", func(t *testing.T) {
+		check, runs := newCheck(10)
+		stopCh := make(chan struct{})
+		close(stopCh)
+		_, err := Run([]Check{check}, retry.Policy{BaseDelay: time.Hour, MaxDelay: time.Hour, MaxRetries: 3}, stopCh)
+		assert.Equal(t, "preflight checks stopped after 1 attempt(s)", err.Error(), "error should be equal")
+		assert.Equal(t, 1, *runs, "check should not be retried")
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	policy := retry.Policy{BaseDelay: time.Second, MaxDelay: 5 * time.Second, MaxRetries: 10}
+	assert.Equal(t, time.Second, backoff(policy, 0), "first retry should wait the base delay")
+	assert.Equal(t, 4*time.Second, backoff(policy, 2), "delay should double")
+	assert.Equal(t, 5*time.Second, backoff(policy, 8), "delay should be capped")
+}
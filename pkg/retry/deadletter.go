@@ -0,0 +1,111 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package retry
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
+)
+
+var logger = log.WithComponent("retry")
+
+// DeadLetter is a key which exhausted its retries
+type DeadLetter struct {
+	Controller string    `json:"controller"`
+	Key        string    `json:"key"`
+	Error      string    `json:"error"`
+	Retries    int       `json:"retries"`
+	Time       time.Time `json:"time"`
+}
+
+// DeadLetters holds the keys of all the controllers which exhausted their
+// retries, until a later sync of the key succeeds
+type DeadLetters struct {
+	lock    sync.RWMutex
+	entries map[string]DeadLetter
+}
+
+// NewDeadLetters returns an empty dead letter list
+func NewDeadLetters() *DeadLetters {
+	return &DeadLetters{
+		entries: make(map[string]DeadLetter),
+	}
+}
+
+// Add records a key which exhausted its retries
+func (d *DeadLetters) Add(controller, key string, err error, retries int) {
+	message := ""
+	if err != nil {
+		message = err.Error()
+	}
+	logger.WithResource(key).WithError(err).Errorf("Giving up on the key of controller %s after %d retries", controller, retries)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.entries[controller+"/"+key] = DeadLetter{
+		Controller: controller,
+		Key:        key,
+		Error:      message,
+		Retries:    retries,
+		Time:       time.Now(),
+	}
+	d.updateMetric(controller)
+}
+
+// Remove removes a key once it was synced successfully
+func (d *DeadLetters) Remove(controller, key string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if _, exists := d.entries[controller+"/"+key]; !exists {
+		return
+	}
+
+	logger.WithResource(key).Infof("Key of controller %s recovered", controller)
+	delete(d.entries, controller+"/"+key)
+	d.updateMetric(controller)
+}
+
+// updateMetric sets the dead letter gauge of a controller, the lock must be held
+func (d *DeadLetters) updateMetric(controller string) {
+	count := 0
+	for _, entry := range d.entries {
+		if entry.Controller == controller {
+			count++
+		}
+	}
+	metrics.DeadLetters.WithLabelValues(controller).Set(float64(count))
+}
+
+// List returns the dead letters sorted by controller and key
+func (d *DeadLetters) List() []DeadLetter {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	list := make([]DeadLetter, 0, len(d.entries))
+	for _, entry := range d.entries {
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Controller != list[j].Controller {
+			return list[i].Controller < list[j].Controller
+		}
+		return list[i].Key < list[j].Key
+	})
+	return list
+}
+
+// ServeHTTP writes the dead letters as a json list
+func (d *DeadLetters) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(d.List())
+	if err != nil {
+		logger.WithError(err).Errorf("Error writing the dead letters")
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package retry
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+)
+
+func init() {
+	log.InitLogger("", "debug")
+}
+
+func TestDeadLetters(t *testing.T) {
+	d := NewDeadLetters()
+	d.Add("processor", "test-ns/test.ns", errors.New("conflict"), 3)
+	d.Add("domain", "test-ns/test.ns", errors.New("not found"), 3)
+	d.Add("domain", "other-ns/other.ns", nil, 1)
+
+	list := d.List()
+	assert.Equal(t, 3, len(list), "dead letters should be listed")
+	assert.Equal(t, "domain", list[0].Controller, "dead letters should be sorted by controller")
+	assert.Equal(t, "other-ns/other.ns", list[0].Key, "dead letters should be sorted by key")
+	assert.Equal(t, "not found", list[1].Error, "error should be recorded")
+	assert.Equal(t, 3, list[1].Retries, "retries should be recorded")
+
+	d.Remove("domain", "test-ns/test.ns")
+	d.Remove("domain", "missing")
+	assert.Equal(t, 2, len(d.List()), "removed dead letter should not be listed")
+
+	recorder := httptest.NewRecorder()
+	d.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/deadletters", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code, "status code should be ok")
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"), "content type should be json")
+
+	var served []DeadLetter
+	err := json.Unmarshal(recorder.Body.Bytes(), &served)
+	assert.Nil(t, err, "response should be valid json")
+	assert.Equal(t, 2, len(served), "response should list the dead letters")
+	assert.Equal(t, "processor", served[1].Controller, "response should be sorted")
+}
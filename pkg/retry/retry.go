@@ -0,0 +1,60 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package retry
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// overall rate limit shared by all the keys of a queue, same as the
+	// client-go default controller rate limiter
+	bucketQPS   = 10
+	bucketBurst = 100
+)
+
+// Policy configures the backoff and the number of retries of the keys of a
+// controller queue
+type Policy struct {
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	MaxRetries int
+}
+
+// DefaultPolicy returns the policy of the client-go default controller rate
+// limiter with 3 retries
+func DefaultPolicy() Policy {
+	return Policy{
+		BaseDelay:  5 * time.Millisecond,
+		MaxDelay:   1000 * time.Second,
+		MaxRetries: 3,
+	}
+}
+
+// Validate returns an error if the policy can not be used
+func (p Policy) Validate() error {
+	if p.BaseDelay <= 0 {
+		return fmt.Errorf("base delay: %s must be positive", p.BaseDelay)
+	}
+	if p.MaxDelay < p.BaseDelay {
+		return fmt.Errorf("max delay: %s must not be lower than the base delay: %s", p.MaxDelay, p.BaseDelay)
+	}
+	if p.MaxRetries < 0 {
+		return fmt.Errorf("max retries: %d must not be negative", p.MaxRetries)
+	}
+	return nil
+}
+
+// RateLimiter returns a rate limiter backing off each key exponentially from
+// the base delay to the max delay, within an overall rate limit of the queue
+func (p Policy) RateLimiter() workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(p.BaseDelay, p.MaxDelay),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(bucketQPS), bucketBurst)},
+	)
+}
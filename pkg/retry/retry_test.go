@@ -0,0 +1,60 @@
+// Copyright 2019, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package retry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      Policy
+		expectedErr bool
+	}{
+		{
+			name:        "should accept the default policy",
+			policy:      DefaultPolicy(),
+			expectedErr: false,
+		},
+		{
+			name:        "should reject a zero base delay",
+			policy:      Policy{BaseDelay: 0, MaxDelay: time.Second, MaxRetries: 3},
+			expectedErr: true,
+		},
+		{
+			name:        "should reject a max delay lower than the base delay",
+			policy:      Policy{BaseDelay: time.Second, MaxDelay: time.Millisecond, MaxRetries: 3},
+			expectedErr: true,
+		},
+		{
+			name:        "should reject negative retries",
+			policy:      Policy{BaseDelay: time.Millisecond, MaxDelay: time.Second, MaxRetries: -1},
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Validate()
+			assert.Equal(t, tt.expectedErr, err != nil, "error should match expected")
+		})
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	policy := Policy{BaseDelay: time.Second, MaxDelay: 3 * time.Second, MaxRetries: 3}
+	limiter := policy.RateLimiter()
+
+	assert.Equal(t, time.Second, limiter.When("key"), "first delay should be the base delay")
+	assert.Equal(t, 2*time.Second, limiter.When("key"), "second delay should be doubled")
+	assert.Equal(t, 3*time.Second, limiter.When("key"), "delay should be capped at the max delay")
+	assert.Equal(t, 3, limiter.NumRequeues("key"), "requeues should be counted")
+
+	limiter.Forget("key")
+	assert.Equal(t, time.Second, limiter.When("key"), "delay should be reset after forget")
+}